@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// adminPageSize is how many devices the admin device list shows per page.
+const adminPageSize = 25
+
+//go:embed admin/templates/*.html admin/static/*
+var adminFiles embed.FS
+
+// adminPages holds one template set per page, each combined with the layout.
+var adminPages = parseAdminPages("devices", "device_form", "upload", "logs")
+
+func parseAdminPages(names ...string) map[string]*template.Template {
+	funcs := template.FuncMap{
+		"derefUint": func(v *uint) string {
+			if v == nil {
+				return ""
+			}
+			return strconv.FormatUint(uint64(*v), 10)
+		},
+	}
+	pages := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		pages[name] = template.Must(template.New("layout.html").Funcs(funcs).
+			ParseFS(adminFiles, "admin/templates/layout.html", "admin/templates/"+name+".html"))
+	}
+	return pages
+}
+
+func registerAdminRoutes(r *gin.Engine) {
+	static, err := fs.Sub(adminFiles, "admin/static")
+	if err != nil {
+		panic(err)
+	}
+	r.StaticFS("/admin/static", http.FS(static))
+
+	r.GET("/admin", adminListDevices)
+	r.GET("/admin/devices/new", adminNewDevice)
+	r.POST("/admin/devices/new", adminCreateDevice)
+	r.GET("/admin/devices/:id", adminEditDevice)
+	r.POST("/admin/devices/:id", adminSaveDevice)
+	r.GET("/admin/upload", adminUploadPage)
+	r.POST("/admin/upload", adminStartImport)
+	r.GET("/admin/imports/:id", adminImportProgress)
+	r.GET("/admin/logs", adminLogsPage)
+	r.GET("/admin/config", getEffectiveConfig)
+	r.POST("/admin/config/reload", reloadConfigNow)
+}
+
+func renderAdmin(c *gin.Context, code int, page string, data gin.H) {
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := adminPages[page].ExecuteTemplate(c.Writer, "layout.html", data); err != nil {
+		logger.Errorf("Failed to render admin page %s: %v", page, err)
+	}
+}
+
+func adminListDevices(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	values := c.Request.URL.Query()
+	filter, err := parseDeviceFilter(values)
+	if err != nil {
+		renderAdmin(c, http.StatusBadRequest, "devices", gin.H{"Title": "Devices", "Query": values, "Error": err.Error()})
+		return
+	}
+
+	// Fetch one extra row to know whether there is a next page.
+	devices, err := findDevicesWithGeo(filter.apply(db), filter.Geo, adminPageSize+1, pageOffset(page, adminPageSize))
+	if err != nil {
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		renderAdmin(c, http.StatusInternalServerError, "devices", gin.H{"Title": "Devices", "Query": values, "Error": "Failed to retrieve devices"})
+		return
+	}
+	hasNext := len(devices) > adminPageSize
+	if hasNext {
+		devices = devices[:adminPageSize]
+	}
+
+	renderAdmin(c, http.StatusOK, "devices", gin.H{
+		"Title":    "Devices",
+		"Devices":  devices,
+		"Query":    values,
+		"Page":     page,
+		"PrevLink": adminPageLink(values, page-1, page > 1),
+		"NextLink": adminPageLink(values, page+1, hasNext),
+	})
+}
+
+// adminPageLink returns the device list URL for another page with the same
+// filters, or "" if there is no such page.
+func adminPageLink(values url.Values, page int, ok bool) string {
+	if !ok {
+		return ""
+	}
+	link := url.Values{}
+	for k, v := range values {
+		link[k] = v
+	}
+	link.Set("page", strconv.Itoa(page))
+	return "/admin?" + link.Encode()
+}
+
+func adminNewDevice(c *gin.Context) {
+	renderAdmin(c, http.StatusOK, "device_form", gin.H{"Title": "New device", "Device": Device{Status: statusInStock}})
+}
+
+func adminCreateDevice(c *gin.Context) {
+	device, err := deviceFromForm(c)
+	if err != nil {
+		renderAdmin(c, http.StatusBadRequest, "device_form", gin.H{"Title": "New device", "Device": device, "Error": err.Error()})
+		return
+	}
+	var rejection *pluginRejection
+	if err := createDevice(c.Request.Context(), &device); errors.As(err, &rejection) {
+		renderAdmin(c, http.StatusUnprocessableEntity, "device_form", gin.H{"Title": "New device", "Device": device, "Error": rejection.Error()})
+		return
+	} else if err != nil {
+		logger.Errorf("Failed to register device: %v", err)
+		renderAdmin(c, http.StatusInternalServerError, "device_form", gin.H{"Title": "New device", "Device": device, "Error": "Failed to register device"})
+		return
+	}
+	logger.Infof("Device registered from admin UI: %v", device)
+	c.Redirect(http.StatusSeeOther, "/admin/devices/"+strconv.Itoa(int(device.ID))+"?saved=1")
+}
+
+func adminEditDevice(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var device Device
+	if err := db.First(&device, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.String(http.StatusNotFound, "Device not found")
+		} else {
+			logger.Errorf("Failed to retrieve device: %v", err)
+			c.String(http.StatusInternalServerError, "Failed to retrieve device")
+		}
+		return
+	}
+	renderAdmin(c, http.StatusOK, "device_form", gin.H{"Title": device.DeviceName, "Device": device, "Saved": c.Query("saved") != ""})
+}
+
+func adminSaveDevice(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	device, err := deviceFromForm(c)
+	device.ID = uint(idInt)
+	if err != nil {
+		renderAdmin(c, http.StatusBadRequest, "device_form", gin.H{"Title": device.DeviceName, "Device": device, "Error": err.Error()})
+		return
+	}
+
+	var stored Device
+	err = db.WithContext(c.Request.Context()).First(&stored, idInt).Error
+	if err == nil {
+		form := device
+		device = stored
+		err = overlayDeviceColumns(&device, form, deviceFormColumns)
+	}
+	if err == nil {
+		err = saveDeviceChanges(c.Request.Context(), stored, device)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "Device not found")
+		return
+	}
+	var rejection *pluginRejection
+	if errors.As(err, &rejection) {
+		renderAdmin(c, http.StatusUnprocessableEntity, "device_form", gin.H{"Title": device.DeviceName, "Device": device, "Error": rejection.Error()})
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to update device: %v", err)
+		renderAdmin(c, http.StatusInternalServerError, "device_form", gin.H{"Title": device.DeviceName, "Device": device, "Error": "Failed to update device"})
+		return
+	}
+	logger.Infof("Device updated from admin UI: %v", device)
+	c.Redirect(http.StatusSeeOther, "/admin/devices/"+strconv.Itoa(idInt)+"?saved=1")
+}
+
+// deviceFormColumns are the columns the device form edits. They are taken
+// as submitted so that clearing a field clears it; the rest keep their
+// stored values.
+var deviceFormColumns = []string{
+	"device_name", "asset_tag", "serial_number", "device_type", "brand", "model", "os", "os_version",
+	"purchase_date", "warranty_end", "maintenance_due", "status", "price", "location_id",
+}
+
+// overlayDeviceColumns copies the given columns of src onto dst.
+func overlayDeviceColumns(dst *Device, src Device, columns []string) error {
+	values, err := deviceFieldValues(*dst)
+	if err != nil {
+		return err
+	}
+	overlay, err := deviceFieldValues(src)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		values[column] = overlay[column]
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+// deviceFromForm reads the device form. The returned device is filled in as
+// far as possible even on error so the form can be shown again.
+func deviceFromForm(c *gin.Context) (Device, error) {
+	device := Device{
+		DeviceName:     strings.TrimSpace(c.PostForm("device_name")),
+		AssetTag:       strings.TrimSpace(c.PostForm("asset_tag")),
+		SerialNumber:   strings.TrimSpace(c.PostForm("serial_number")),
+		DeviceType:     strings.TrimSpace(c.PostForm("device_type")),
+		Brand:          strings.TrimSpace(c.PostForm("brand")),
+		Model:          strings.TrimSpace(c.PostForm("model")),
+		Os:             strings.TrimSpace(c.PostForm("os")),
+		OsVersion:      strings.TrimSpace(c.PostForm("os_version")),
+		PurchaseDate:   c.PostForm("purchase_date"),
+		WarrantyEnd:    c.PostForm("warranty_end"),
+		MaintenanceDue: c.PostForm("maintenance_due"),
+		Status:         c.PostForm("status"),
+	}
+	if device.DeviceName == "" {
+		return device, errors.New("Device name is required")
+	}
+	if raw := c.PostForm("price"); raw != "" {
+		price, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return device, errors.New("Price must be a whole number")
+		}
+		device.Price = uint(price)
+	}
+	location, err := parseOptionalUint(url.Values{"location_id": {c.PostForm("location_id")}}, "location_id")
+	if err != nil {
+		return device, err
+	}
+	device.LocationID = location
+	return device, nil
+}
+
+func adminUploadPage(c *gin.Context) {
+	renderAdmin(c, http.StatusOK, "upload", gin.H{"Title": "Upload CSV"})
+}
+
+// adminStartImport saves the uploaded CSV to a temporary file and imports it
+// in the background, returning the import ID for progress polling.
+func adminStartImport(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		logger.Warnf("File upload error: %v", err)
+		respondWithError(c, http.StatusBadRequest, "File is required")
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		logger.Errorf("Failed to open file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "device-import-*.csv")
+	if err != nil {
+		logger.Errorf("Failed to create temporary file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		logger.Errorf("Failed to store upload: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		logger.Errorf("Failed to store upload: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to store upload")
+		return
+	}
+
+	job := newImportJob(file.Filename, file.Size)
+	trackImportJob(job)
+	go func() {
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		importCSV(context.Background(), tmp, job)
+		p := job.progress()
+		logger.Infof("CSV import %s finished: %d rows, %d inserted, %d skipped", p.ID, p.Rows, p.Inserted, p.Skipped)
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{"id": job.id})
+}
+
+func adminImportProgress(c *gin.Context) {
+	job, ok := findImportJob(c.Param("id"))
+	if !ok {
+		respondWithError(c, http.StatusNotFound, "Import not found")
+		return
+	}
+	c.JSON(http.StatusOK, job.progress())
+}
+
+func adminLogsPage(c *gin.Context) {
+	renderAdmin(c, http.StatusOK, "logs", gin.H{"Title": "Logs"})
+}
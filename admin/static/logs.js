@@ -0,0 +1,28 @@
+(function () {
+  var form = document.getElementById("log-filters");
+  var body = document.querySelector("#log-table tbody");
+
+  function load() {
+    var params = new URLSearchParams(new FormData(form));
+    if (!params.get("level")) params.delete("level");
+    fetch("/logs?" + params.toString())
+      .then(function (res) { return res.json(); })
+      .then(function (data) {
+        body.innerHTML = "";
+        if (data.error) {
+          body.insertRow().insertCell().textContent = data.error;
+          return;
+        }
+        (data.entries || []).slice().reverse().forEach(function (e) {
+          var row = body.insertRow();
+          row.className = "level-" + (e.level || "");
+          row.insertCell().textContent = e.time || "";
+          row.insertCell().textContent = e.level || "";
+          row.insertCell().textContent = e.msg || "";
+        });
+      });
+  }
+
+  form.addEventListener("submit", function (ev) { ev.preventDefault(); load(); });
+  load();
+})();
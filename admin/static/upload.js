@@ -0,0 +1,66 @@
+(function () {
+  var form = document.getElementById("upload-form");
+  var status = document.getElementById("upload-status");
+  var phase = document.getElementById("upload-phase");
+  var bar = document.getElementById("upload-progress");
+  var counts = document.getElementById("upload-counts");
+  var errors = document.getElementById("upload-errors");
+  var omitted = document.getElementById("upload-omitted");
+
+  function showErrors(list, more) {
+    var body = errors.querySelector("tbody");
+    body.innerHTML = "";
+    list.forEach(function (e) {
+      var row = body.insertRow();
+      row.insertCell().textContent = e.end_line ? e.line + "–" + e.end_line : e.line;
+      row.insertCell().textContent = e.message;
+    });
+    errors.hidden = list.length === 0;
+    omitted.textContent = more > 0 ? more + " more errors not shown." : "";
+  }
+
+  function poll(id) {
+    fetch("/admin/imports/" + encodeURIComponent(id))
+      .then(function (res) { return res.json(); })
+      .then(function (p) {
+        if (p.error) { phase.textContent = p.error; return; }
+        bar.value = p.total_bytes > 0 ? Math.round(100 * p.bytes_read / p.total_bytes) : 100;
+        counts.textContent = p.rows + " rows read, " + p.inserted + " inserted, " + p.skipped + " skipped";
+        showErrors(p.errors || [], p.omitted_errors);
+        if (p.done) {
+          phase.textContent = "Import finished.";
+        } else {
+          phase.textContent = "Importing…";
+          setTimeout(function () { poll(id); }, 1000);
+        }
+      })
+      .catch(function () { phase.textContent = "Lost contact with the server."; });
+  }
+
+  form.addEventListener("submit", function (ev) {
+    ev.preventDefault();
+    status.hidden = false;
+    showErrors([], 0);
+    counts.textContent = "";
+    phase.textContent = "Uploading…";
+    bar.value = 0;
+
+    var xhr = new XMLHttpRequest();
+    xhr.open("POST", "/admin/upload");
+    xhr.upload.onprogress = function (e) {
+      if (e.lengthComputable) bar.value = Math.round(100 * e.loaded / e.total);
+    };
+    xhr.onload = function () {
+      var body = {};
+      try { body = JSON.parse(xhr.responseText); } catch (e) {}
+      if (xhr.status !== 202) {
+        phase.textContent = body.error || "Upload failed.";
+        return;
+      }
+      bar.value = 0;
+      poll(body.id);
+    };
+    xhr.onerror = function () { phase.textContent = "Upload failed."; };
+    xhr.send(new FormData(form));
+  });
+})();
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that the tail keeps the last entries in order and filters by level
+func TestTailLogEntries(t *testing.T) {
+	log := `{"level":"info","msg":"one"}
+{"level":"error","msg":"two"}
+not json
+{"level":"info","msg":"three"}
+`
+	entries, err := tailLogEntries(bufio.NewScanner(strings.NewReader(log)), 2, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "not json", entries[0]["msg"])
+	assert.Equal(t, "three", entries[1]["msg"])
+
+	entries, err = tailLogEntries(bufio.NewScanner(strings.NewReader(log)), 10, "ERROR")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "two", entries[0]["msg"])
+
+	entries, err = tailLogEntries(bufio.NewScanner(strings.NewReader(log)), 1<<40, "")
+	assert.NoError(t, err, "huge counts are capped, not allocated")
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "one", entries[0]["msg"])
+}
+
+// Test that pagination links keep the current filters
+func TestAdminPageLink(t *testing.T) {
+	values := url.Values{"status": {"In Stock"}, "page": {"2"}}
+	assert.Equal(t, "/admin?page=3&status=In+Stock", adminPageLink(values, 3, true))
+	assert.Equal(t, "", adminPageLink(values, 1, false))
+}
+
+// Test that import errors are capped and counted
+func TestImportJobErrorCap(t *testing.T) {
+	job := newImportJob("devices.csv", 100)
+	for i := 1; i <= maxImportErrors+5; i++ {
+		job.rowSkipped(i, "expected 10 columns")
+	}
+	job.batchFailed(200, 210, 11, errors.New("duplicate key"))
+	job.finish()
+
+	p := job.progress()
+	assert.True(t, p.Done)
+	assert.Equal(t, maxImportErrors+16, p.Skipped)
+	assert.Len(t, p.Errors, maxImportErrors)
+	assert.Equal(t, 6, p.OmittedErrors)
+}
+
+// Test that transform change reasons are kept per line, up to the cap
+func TestImportJobChanges(t *testing.T) {
+	job := newImportJob("devices.csv", 100)
+	job.rowChanged(2, []string{"mapped vendor status", "trimmed serial"})
+	for i := 3; i < maxImportErrors+5; i++ {
+		job.rowChanged(i, []string{"mapped vendor status"})
+	}
+
+	p := job.progress()
+	assert.Len(t, p.Changes, maxImportErrors)
+	assert.Equal(t, importRowChange{Line: 2, Reasons: []string{"mapped vendor status", "trimmed serial"}}, p.Changes[0])
+	assert.Equal(t, 3, p.OmittedChanges)
+	assert.Zero(t, p.Skipped, "changed rows are still imported")
+}
+
+// Test that every field on the device form is saved as submitted
+func TestDeviceFormColumns(t *testing.T) {
+	form, err := adminFiles.ReadFile("admin/templates/device_form.html")
+	assert.NoError(t, err)
+	for _, m := range regexp.MustCompile(`name="([a-z_]+)"`).FindAllStringSubmatch(string(form), -1) {
+		assert.Contains(t, deviceFormColumns, m[1])
+	}
+}
+
+// Test that the form's columns replace the stored ones and the rest are kept
+func TestOverlayDeviceColumns(t *testing.T) {
+	employee := uint(7)
+	device := Device{ID: 1, DeviceName: "Lab PC", SerialNumber: "SN1", AssignedTo: &employee, LegalHold: true}
+	assert.NoError(t, overlayDeviceColumns(&device, Device{DeviceName: "Lab PC 2"}, deviceFormColumns))
+	assert.Equal(t, "Lab PC 2", device.DeviceName)
+	assert.Empty(t, device.SerialNumber, "cleared on the form")
+	assert.Equal(t, &employee, device.AssignedTo)
+	assert.True(t, device.LegalHold)
+	assert.Equal(t, uint(1), device.ID)
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Where archived devices are kept.
+const (
+	archiveToTable = "table"
+	archiveToFile  = "file"
+)
+
+// archivedDeviceRecord is everything kept about an archived device.
+type archivedDeviceRecord struct {
+	Device        Device               `json:"device"`
+	StatusHistory []DeviceStatusChange `json:"status_history"`
+	Positions     []DevicePosition     `json:"positions"`
+	ArchivedAt    time.Time            `json:"archived_at"`
+}
+
+// ArchivedDevice is an archived device in the archive table. The searchable
+// fields are copied out of the full record.
+type ArchivedDevice struct {
+	DeviceID   uint      `gorm:"column:device_id;primaryKey;autoIncrement:false"`
+	DeviceName string    `gorm:"column:device_name"`
+	DeviceType string    `gorm:"column:device_type;index"`
+	Brand      string    `gorm:"column:brand"`
+	Model      string    `gorm:"column:model"`
+	Status     string    `gorm:"column:status"`
+	ArchivedAt time.Time `gorm:"column:archived_at;index"`
+	Record     string    `gorm:"column:record;type:text"` // archivedDeviceRecord as JSON
+}
+
+// archiveQuery filters archived devices.
+type archiveQuery struct {
+	Query      string
+	DeviceType string
+	Status     string
+}
+
+func (q archiveQuery) matches(d Device) bool {
+	if q.DeviceType != "" && !strings.EqualFold(d.DeviceType, q.DeviceType) {
+		return false
+	}
+	if q.Status != "" && !strings.EqualFold(d.Status, q.Status) {
+		return false
+	}
+	if q.Query == "" {
+		return true
+	}
+	needle := strings.ToLower(q.Query)
+	for _, field := range []string{d.DeviceName, d.Brand, d.Model} {
+		if strings.Contains(strings.ToLower(field), needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveCandidates selects devices that have been in a terminal status since
+// before cutoff and are not on legal hold or assigned. Devices without any
+// recorded history qualify by purchase date.
+func archiveCandidates(tx *gorm.DB, statuses []string, cutoff time.Time, limit int) ([]Device, error) {
+	var devices []Device
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status IN ? AND legal_hold = ? AND assigned_to IS NULL", statuses, false).
+		Where("NOT EXISTS (SELECT 1 FROM device_status_changes c WHERE c.device_id = devices.id AND c.changed_at >= ?)", cutoff).
+		Where("EXISTS (SELECT 1 FROM device_status_changes c WHERE c.device_id = devices.id) OR (purchase_date <> '' AND purchase_date < ?)",
+			cutoff.Format("2006-01-02")).
+		Order("id").Limit(limit).Find(&devices).Error
+	return devices, err
+}
+
+// buildArchiveRecords loads the history of each device.
+func buildArchiveRecords(tx *gorm.DB, devices []Device, now time.Time) ([]archivedDeviceRecord, error) {
+	ids := make([]uint, len(devices))
+	for i, d := range devices {
+		ids[i] = d.ID
+	}
+	var changes []DeviceStatusChange
+	if err := tx.Where("device_id IN ?", ids).Order("changed_at").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	var positions []DevicePosition
+	if err := tx.Where("device_id IN ?", ids).Order("recorded_at").Find(&positions).Error; err != nil {
+		return nil, err
+	}
+
+	records := make([]archivedDeviceRecord, len(devices))
+	index := make(map[uint]int, len(devices))
+	for i, d := range devices {
+		records[i] = archivedDeviceRecord{Device: d, StatusHistory: []DeviceStatusChange{}, Positions: []DevicePosition{}, ArchivedAt: now}
+		index[d.ID] = i
+	}
+	for _, c := range changes {
+		r := &records[index[c.DeviceID]]
+		r.StatusHistory = append(r.StatusHistory, c)
+	}
+	for _, p := range positions {
+		r := &records[index[p.DeviceID]]
+		r.Positions = append(r.Positions, p)
+	}
+	return records, nil
+}
+
+// writeArchiveFile writes records as gzip-compressed JSON lines to a new
+// file in dir and returns its path. The file only appears once complete.
+func writeArchiveFile(dir string, records []archivedDeviceRecord, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".devices-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	zw := gzip.NewWriter(tmp)
+	enc := json.NewEncoder(zw)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("devices-%s-%d.jsonl.gz", now.UTC().Format("20060102T150405"), records[0].Device.ID))
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// readArchiveFile calls fn for each record in an archive file until fn
+// returns false.
+func readArchiveFile(path string, fn func(archivedDeviceRecord) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r archivedDeviceRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func archiveFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "devices-*.jsonl.gz"))
+	sort.Strings(paths)
+	return paths, err
+}
+
+// archiveBatch archives up to limit devices and returns them. With dryRun it
+// only returns the candidates.
+func archiveBatch(ctx context.Context, limit int, dryRun bool) ([]Device, error) {
+	retention := config().Retention
+	now := time.Now()
+	cutoff := now.AddDate(-retention.Years, 0, 0)
+
+	var archived []Device
+	var writtenFile string
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		devices, err := archiveCandidates(tx, retention.TerminalStatuses, cutoff, limit)
+		if err != nil || len(devices) == 0 || dryRun {
+			archived = devices
+			return err
+		}
+		records, err := buildArchiveRecords(tx, devices, now)
+		if err != nil {
+			return err
+		}
+
+		if retention.Target == archiveToFile {
+			if writtenFile, err = writeArchiveFile(retention.Dir, records, now); err != nil {
+				return err
+			}
+		} else {
+			rows := make([]ArchivedDevice, len(records))
+			for i, r := range records {
+				raw, err := json.Marshal(r)
+				if err != nil {
+					return err
+				}
+				rows[i] = ArchivedDevice{
+					DeviceID:   r.Device.ID,
+					DeviceName: r.Device.DeviceName,
+					DeviceType: r.Device.DeviceType,
+					Brand:      r.Device.Brand,
+					Model:      r.Device.Model,
+					Status:     r.Device.Status,
+					ArchivedAt: now,
+					Record:     string(raw),
+				}
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+
+		ids := make([]uint, len(devices))
+		for i, d := range devices {
+			ids[i] = d.ID
+		}
+		for _, model := range []interface{}{&DeviceStatusChange{}, &DevicePosition{}, &SavedSearchMatch{}, &NetworkInterface{}} {
+			if err := tx.Where("device_id IN ?", ids).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("kind = ? AND target_id IN ?", watchDevice, ids).Delete(&Watch{}).Error; err != nil {
+			return err
+		}
+		if err := detachDeviceSIMs(tx, ids); err != nil {
+			return err
+		}
+		if err := tx.Delete(&Device{}, ids).Error; err != nil {
+			return err
+		}
+		archived = devices
+		return nil
+	})
+	if err != nil && writtenFile != "" {
+		// The devices are still live, so the file would only duplicate them.
+		os.Remove(writtenFile)
+	}
+	return archived, err
+}
+
+// archiveRetiredDevices archives every eligible device in batches.
+func archiveRetiredDevices(ctx context.Context) error {
+	if config().Retention.Years <= 0 {
+		return nil
+	}
+	total := 0
+	for {
+		archived, err := archiveBatch(ctx, chunkSize, false)
+		if err != nil {
+			return err
+		}
+		total += len(archived)
+		if len(archived) < chunkSize {
+			break
+		}
+	}
+	if total > 0 {
+		logger.Infof("Archived %d retired devices", total)
+	}
+	return nil
+}
+
+func startArchiveJob() {
+	startJob("archive-retired-devices", config().Retention.Interval.Duration, archiveRetiredDevices)
+}
+
+// runArchive archives eligible devices now; ?dry_run=true lists them instead.
+func runArchive(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	if config().Retention.Years <= 0 {
+		respondWithError(c, http.StatusConflict, "Archival is disabled")
+		return
+	}
+
+	if c.Query("dry_run") == "true" {
+		devices, err := archiveBatch(c.Request.Context(), -1, true)
+		if err != nil {
+			logger.Errorf("Failed to find devices to archive: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to find devices to archive")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"would_archive": len(devices), "devices": devices})
+		return
+	}
+
+	if err := archiveRetiredDevices(c.Request.Context()); err != nil {
+		logger.Errorf("Failed to archive devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to archive devices")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Archival completed"})
+}
+
+// searchArchivedDevices searches the archive table and archive files.
+func searchArchivedDevices(c *gin.Context) {
+	limit, offset := pageParams(c, 10)
+	q := archiveQuery{Query: strings.TrimSpace(c.Query("q")), DeviceType: c.Query("device_type"), Status: c.Query("status")}
+
+	query := db.Model(&ArchivedDevice{})
+	if q.Query != "" {
+		like := "%" + escapeLike(strings.ToLower(q.Query)) + "%"
+		query = query.Where("LOWER(device_name) LIKE ? OR LOWER(brand) LIKE ? OR LOWER(model) LIKE ?", like, like, like)
+	}
+	if q.DeviceType != "" {
+		query = query.Where("LOWER(device_type) = LOWER(?)", q.DeviceType)
+	}
+	if q.Status != "" {
+		query = query.Where("LOWER(status) = LOWER(?)", q.Status)
+	}
+	var rows []ArchivedDevice
+	if err := query.Order("device_id").Find(&rows).Error; err != nil {
+		logger.Errorf("Failed to search archive: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to search archive")
+		return
+	}
+
+	results := []archivedDeviceRecord{}
+	for _, row := range rows {
+		var r archivedDeviceRecord
+		if err := json.Unmarshal([]byte(row.Record), &r); err != nil {
+			logger.Errorf("Corrupt archive record for device %d: %v", row.DeviceID, err)
+			continue
+		}
+		results = append(results, r)
+	}
+
+	paths, err := archiveFiles(config().Retention.Dir)
+	if err != nil {
+		logger.Errorf("Failed to list archive files: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to search archive")
+		return
+	}
+	for _, path := range paths {
+		err := readArchiveFile(path, func(r archivedDeviceRecord) bool {
+			if q.matches(r.Device) {
+				results = append(results, r)
+			}
+			return true
+		})
+		if err != nil {
+			logger.Errorf("Failed to read archive file: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to search archive")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"total": len(results), "devices": pageOf(results, limit, offset)})
+}
+
+func setDeviceLegalHold(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var input struct {
+		LegalHold *bool `json:"legal_hold" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := db.Model(&Device{}).Where("id = ?", idInt).Update("legal_hold", *input.LegalHold)
+	if result.Error != nil {
+		logger.Errorf("Failed to update legal hold: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update legal hold")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	logger.Infof("Legal hold on device %d set to %t", idInt, *input.LegalHold)
+	c.JSON(http.StatusOK, gin.H{"message": "Legal hold updated successfully"})
+}
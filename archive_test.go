@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test writing and reading back an archive file
+func TestArchiveFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []archivedDeviceRecord{
+		{Device: Device{ID: 3, DeviceName: "Old laptop", Status: statusDisposed}, StatusHistory: []DeviceStatusChange{{DeviceID: 3, ToStatus: statusDisposed}}, ArchivedAt: now},
+		{Device: Device{ID: 4, DeviceName: "Old phone", Status: statusRecycled}, ArchivedAt: now},
+	}
+
+	path, err := writeArchiveFile(dir, records, now)
+	assert.NoError(t, err)
+
+	paths, err := archiveFiles(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path}, paths)
+
+	var read []archivedDeviceRecord
+	assert.NoError(t, readArchiveFile(path, func(r archivedDeviceRecord) bool {
+		read = append(read, r)
+		return true
+	}))
+	assert.Len(t, read, 2)
+	assert.Equal(t, "Old laptop", read[0].Device.DeviceName)
+	assert.Equal(t, statusDisposed, read[0].StatusHistory[0].ToStatus)
+}
+
+// Test matching archived devices against a search
+func TestArchiveQueryMatches(t *testing.T) {
+	d := Device{DeviceName: "ThinkPad T480", DeviceType: "Laptop", Brand: "Lenovo", Status: statusDisposed}
+
+	assert.True(t, archiveQuery{Query: "thinkpad"}.matches(d))
+	assert.True(t, archiveQuery{Query: "lenovo", DeviceType: "laptop"}.matches(d))
+	assert.False(t, archiveQuery{Status: statusRecycled}.matches(d))
+	assert.False(t, archiveQuery{Query: "dell"}.matches(d))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const missingNotFound = "not_found"
+
+type batchGetInput struct {
+	IDs       []uint   `json:"ids"`
+	AssetTags []string `json:"asset_tags"`
+}
+
+// batchGetMiss is a requested ID or asset tag that returned no device.
+type batchGetMiss struct {
+	ID       *uint  `json:"id,omitempty"`
+	AssetTag string `json:"asset_tag,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// matchBatchKeys orders devices as they were asked for, once each, and
+// lists the keys nothing matched. An asset tag shared by several devices
+// returns all of them.
+func matchBatchKeys(input batchGetInput, devices []Device) ([]Device, []batchGetMiss) {
+	byID := make(map[uint]Device, len(devices))
+	byTag := map[string][]Device{}
+	for _, d := range devices {
+		byID[d.ID] = d
+		if d.AssetTag != "" {
+			byTag[d.AssetTag] = append(byTag[d.AssetTag], d)
+		}
+	}
+
+	found := []Device{}
+	missing := []batchGetMiss{}
+	seen := map[uint]bool{}
+	add := func(d Device) {
+		if !seen[d.ID] {
+			seen[d.ID] = true
+			found = append(found, d)
+		}
+	}
+	for _, id := range input.IDs {
+		d, ok := byID[id]
+		if !ok {
+			id := id
+			missing = append(missing, batchGetMiss{ID: &id, Reason: missingNotFound})
+			continue
+		}
+		add(d)
+	}
+	for _, tag := range input.AssetTags {
+		matches, ok := byTag[tag]
+		if !ok {
+			missing = append(missing, batchGetMiss{AssetTag: tag, Reason: missingNotFound})
+			continue
+		}
+		for _, d := range matches {
+			add(d)
+		}
+	}
+	return found, missing
+}
+
+// batchGetDevices returns many devices by ID or asset tag in one query.
+// ?fields= selects fields as in listDevices.
+func batchGetDevices(c *gin.Context) {
+	var input batchGetInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	tags := input.AssetTags[:0]
+	for _, tag := range input.AssetTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	input.AssetTags = tags
+
+	total := len(input.IDs) + len(input.AssetTags)
+	if total == 0 {
+		respondWithError(c, http.StatusBadRequest, "ids or asset_tags is required")
+		return
+	}
+	if max := config().Devices.BatchGetMax; total > max {
+		respondWithError(c, http.StatusBadRequest, fmt.Sprintf("At most %d IDs and asset tags may be requested", max))
+		return
+	}
+	fields, err := parseDeviceFields(c.Query("fields"))
+	if err != nil {
+		logger.Warnf("Invalid fields: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := db.WithContext(c.Request.Context())
+	switch {
+	case len(input.IDs) > 0 && len(input.AssetTags) > 0:
+		query = query.Where("id IN ? OR asset_tag IN ?", input.IDs, input.AssetTags)
+	case len(input.IDs) > 0:
+		query = query.Where("id IN ?", input.IDs)
+	default:
+		query = query.Where("asset_tag IN ?", input.AssetTags)
+	}
+	var devices []Device
+	if err := query.Find(&devices).Error; err != nil {
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+
+	found, missing := matchBatchKeys(input, devices)
+	result, err := selectDeviceFields(found, fields)
+	if err != nil {
+		logger.Errorf("Failed to select device fields: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+
+	logger.Infof("Devices batch retrieved: %d found, %d missing", len(found), len(missing))
+	c.JSON(http.StatusOK, gin.H{"devices": result, "missing": missing})
+}
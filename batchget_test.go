@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test ordering batch-get results and reporting keys that matched nothing
+func TestMatchBatchKeys(t *testing.T) {
+	devices := []Device{
+		{ID: 1, AssetTag: "A-1"},
+		{ID: 2, AssetTag: "A-2"},
+		{ID: 3, AssetTag: "A-2"},
+	}
+	input := batchGetInput{IDs: []uint{2, 9, 1, 2}, AssetTags: []string{"A-1", "A-2", "A-9"}}
+
+	found, missing := matchBatchKeys(input, devices)
+
+	var ids []uint
+	for _, d := range found {
+		ids = append(ids, d.ID)
+	}
+	assert.Equal(t, []uint{2, 1, 3}, ids)
+	if assert.Len(t, missing, 2) {
+		assert.Equal(t, uint(9), *missing[0].ID)
+		assert.Equal(t, missingNotFound, missing[0].Reason)
+		assert.Equal(t, "A-9", missing[1].AssetTag)
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EmissionFactor is the embodied carbon of one kind of device: what making
+// and shipping it emitted. A factor without a model covers every model of
+// its device type that has no factor of its own. Type and model are matched
+// regardless of case, so they are unique regardless of case too.
+type EmissionFactor struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	DeviceType    string    `gorm:"column:device_type;uniqueIndex:idx_emission_factors_type_model_lower,expression:lower(device_type)" json:"device_type"`
+	Model         string    `gorm:"column:model;uniqueIndex:idx_emission_factors_type_model_lower,expression:lower(model)" json:"model"`
+	EmbodiedKg    float64   `gorm:"column:embodied_kg" json:"embodied_kg"`       // kg CO2e
+	LifetimeYears float64   `gorm:"column:lifetime_years" json:"lifetime_years"` // expected years of service
+	Source        string    `gorm:"column:source" json:"source"`                 // where the figure comes from
+	UpdatedAt     time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// dropLegacyEmissionFactorIndex removes the case-sensitive type and model
+// index of databases created before it was replaced by one on lower().
+func dropLegacyEmissionFactorIndex(tx *gorm.DB) error {
+	const legacy = "idx_emission_factors_type_model"
+	if !tx.Migrator().HasIndex(&EmissionFactor{}, legacy) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(&EmissionFactor{}, legacy)
+}
+
+// emissionFactorSaveFailed reports a failed create or update, with a
+// conflict when another factor already covers the same type and model.
+func emissionFactorSaveFailed(c *gin.Context, err error, action string) {
+	if isUniqueViolation(err) {
+		respondWithError(c, http.StatusConflict, "An emission factor for this device type and model already exists")
+		return
+	}
+	logger.Errorf("Failed to %s emission factor: %v", action, err)
+	respondWithError(c, http.StatusInternalServerError, "Failed to "+action+" emission factor")
+}
+
+func (f EmissionFactor) validate() error {
+	if strings.TrimSpace(f.DeviceType) == "" {
+		return errors.New("device_type is required")
+	}
+	if f.EmbodiedKg < 0 {
+		return errors.New("embodied_kg must not be negative")
+	}
+	if f.LifetimeYears <= 0 {
+		return errors.New("lifetime_years must be positive")
+	}
+	return nil
+}
+
+// matchEmissionFactor picks the factor for a device, preferring one for its
+// exact model over one for its whole type. Matching ignores case.
+func matchEmissionFactor(factors []EmissionFactor, deviceType, model string) *EmissionFactor {
+	var typeWide *EmissionFactor
+	for i, f := range factors {
+		if !strings.EqualFold(f.DeviceType, deviceType) {
+			continue
+		}
+		if f.Model != "" && strings.EqualFold(f.Model, model) {
+			return &factors[i]
+		}
+		if f.Model == "" {
+			typeWide = &factors[i]
+		}
+	}
+	return typeWide
+}
+
+// deviceFootprint is a device's share of embodied emissions.
+type deviceFootprint struct {
+	DeviceID   uint    `json:"device_id"`
+	DeviceType string  `json:"device_type"`
+	Model      string  `json:"model"`
+	FactorID   *uint   `json:"factor_id"` // nil when no factor covers the device
+	EmbodiedKg float64 `json:"embodied_kg"`
+	UsageYears float64 `json:"usage_years"` // purchase to retirement, or to now while in service
+	// AnnualKg spreads the embodied emissions over the expected lifetime, or
+	// over the actual one once the device outlives it.
+	AnnualKg float64 `json:"annual_kg"`
+	// ExtendedLifeSavingsKg is what replacing the device at the end of its
+	// expected lifetime would have added, prorated by the extra years of use.
+	ExtendedLifeSavingsKg float64    `json:"extended_life_savings_kg"`
+	RetiredAt             *time.Time `json:"retired_at"`
+}
+
+const hoursPerYear = 365.25 * 24
+
+func computeFootprint(d Device, factor *EmissionFactor, retiredAt *time.Time, now time.Time) deviceFootprint {
+	fp := deviceFootprint{DeviceID: d.ID, DeviceType: d.DeviceType, Model: d.Model, RetiredAt: retiredAt}
+	if factor == nil {
+		return fp
+	}
+	id := factor.ID
+	fp.FactorID = &id
+	fp.EmbodiedKg = factor.EmbodiedKg
+
+	end := now
+	if retiredAt != nil {
+		end = *retiredAt
+	}
+	if purchased, err := time.Parse("2006-01-02", d.PurchaseDate); err == nil && end.After(purchased) {
+		fp.UsageYears = end.Sub(purchased).Hours() / hoursPerYear
+	}
+
+	spread := factor.LifetimeYears
+	if fp.UsageYears > spread {
+		spread = fp.UsageYears
+		fp.ExtendedLifeSavingsKg = factor.EmbodiedKg * (fp.UsageYears - factor.LifetimeYears) / factor.LifetimeYears
+	}
+	fp.AnnualKg = factor.EmbodiedKg / spread
+	return fp
+}
+
+// retirementDates returns when each of the given devices last entered a
+// terminal status.
+func retirementDates(tx *gorm.DB, deviceIDs []uint) (map[uint]time.Time, error) {
+	var rows []struct {
+		DeviceID  uint
+		RetiredAt time.Time
+	}
+	err := tx.Model(&DeviceStatusChange{}).
+		Select("device_id, MAX(changed_at) AS retired_at").
+		Where("device_id IN ? AND to_status IN ?", deviceIDs, config().Retention.TerminalStatuses).
+		Group("device_id").Scan(&rows).Error
+	dates := make(map[uint]time.Time, len(rows))
+	for _, r := range rows {
+		dates[r.DeviceID] = r.RetiredAt
+	}
+	return dates, err
+}
+
+// categoryEmissions totals the footprints of one device type.
+type categoryEmissions struct {
+	DeviceType            string  `json:"device_type"`
+	Devices               int     `json:"devices"`
+	WithoutFactor         int     `json:"without_factor"`
+	EmbodiedKg            float64 `json:"embodied_kg"`
+	AnnualKg              float64 `json:"annual_kg"`
+	ExtendedLifeSavingsKg float64 `json:"extended_life_savings_kg"`
+}
+
+// retirementRates says how retired devices left the fleet.
+type retirementRates struct {
+	Retired       int     `json:"retired"`
+	Disposed      int     `json:"disposed"`
+	Recycled      int     `json:"recycled"`
+	DisposalRate  float64 `json:"disposal_rate"`
+	RecyclingRate float64 `json:"recycling_rate"`
+}
+
+type sustainabilityReport struct {
+	GeneratedAt           time.Time           `json:"generated_at"`
+	Devices               int                 `json:"devices"`
+	WithoutFactor         int                 `json:"without_factor"`
+	EmbodiedKg            float64             `json:"embodied_kg"`
+	AnnualKg              float64             `json:"annual_kg"`
+	ExtendedLifeSavingsKg float64             `json:"extended_life_savings_kg"`
+	Categories            []categoryEmissions `json:"categories"`
+	Retirements           retirementRates     `json:"retirements"`
+}
+
+// addFootprints folds device footprints into the report's totals.
+func (r *sustainabilityReport) addFootprints(footprints []deviceFootprint) {
+	index := make(map[string]int, len(r.Categories))
+	for i, c := range r.Categories {
+		index[c.DeviceType] = i
+	}
+	for _, fp := range footprints {
+		i, ok := index[fp.DeviceType]
+		if !ok {
+			r.Categories = append(r.Categories, categoryEmissions{DeviceType: fp.DeviceType})
+			i = len(r.Categories) - 1
+			index[fp.DeviceType] = i
+		}
+		category := &r.Categories[i]
+		category.Devices++
+		r.Devices++
+		if fp.FactorID == nil {
+			category.WithoutFactor++
+			r.WithoutFactor++
+			continue
+		}
+		category.EmbodiedKg += fp.EmbodiedKg
+		category.AnnualKg += fp.AnnualKg
+		category.ExtendedLifeSavingsKg += fp.ExtendedLifeSavingsKg
+		r.EmbodiedKg += fp.EmbodiedKg
+		r.AnnualKg += fp.AnnualKg
+		r.ExtendedLifeSavingsKg += fp.ExtendedLifeSavingsKg
+	}
+}
+
+// computeRetirementRates counts devices that were disposed of or recycled
+// between from and to, from the status history. Devices already archived
+// have taken their history with them and are not counted.
+func computeRetirementRates(tx *gorm.DB, from, to time.Time) (retirementRates, error) {
+	var rows []struct {
+		ToStatus string
+		Devices  int
+	}
+	err := tx.Model(&DeviceStatusChange{}).
+		Select("to_status, COUNT(DISTINCT device_id) AS devices").
+		Where("to_status IN ? AND changed_at >= ? AND changed_at < ?", []string{statusDisposed, statusRecycled}, from, to).
+		Group("to_status").Scan(&rows).Error
+	var rates retirementRates
+	for _, r := range rows {
+		switch r.ToStatus {
+		case statusDisposed:
+			rates.Disposed = r.Devices
+		case statusRecycled:
+			rates.Recycled = r.Devices
+		}
+	}
+	rates.Retired = rates.Disposed + rates.Recycled
+	if rates.Retired > 0 {
+		rates.DisposalRate = float64(rates.Disposed) / float64(rates.Retired)
+		rates.RecyclingRate = float64(rates.Recycled) / float64(rates.Retired)
+	}
+	return rates, err
+}
+
+// footprintsFor computes the footprints of a batch of devices.
+func footprintsFor(tx *gorm.DB, devices []Device, factors []EmissionFactor, now time.Time) ([]deviceFootprint, error) {
+	terminal := config().Retention.TerminalStatuses
+	var retiredIDs []uint
+	for _, d := range devices {
+		if containsString(terminal, d.Status) {
+			retiredIDs = append(retiredIDs, d.ID)
+		}
+	}
+	retired := map[uint]time.Time{}
+	if len(retiredIDs) > 0 {
+		var err error
+		if retired, err = retirementDates(tx, retiredIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	footprints := make([]deviceFootprint, 0, len(devices))
+	for _, d := range devices {
+		var retiredAt *time.Time
+		if at, ok := retired[d.ID]; ok {
+			retiredAt = &at
+		}
+		footprints = append(footprints, computeFootprint(d, matchEmissionFactor(factors, d.DeviceType, d.Model), retiredAt, now))
+	}
+	return footprints, nil
+}
+
+func getDeviceFootprint(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var device Device
+	if err := db.First(&device, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Device not found")
+		} else {
+			logger.Errorf("Failed to retrieve device: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to compute footprint")
+		}
+		return
+	}
+	var factors []EmissionFactor
+	if err := db.Find(&factors).Error; err != nil {
+		logger.Errorf("Failed to retrieve emission factors: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to compute footprint")
+		return
+	}
+
+	footprints, err := footprintsFor(db, []Device{device}, factors, time.Now())
+	if err != nil {
+		logger.Errorf("Failed to compute footprint: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to compute footprint")
+		return
+	}
+	c.JSON(http.StatusOK, footprints[0])
+}
+
+// getSustainabilityReport covers every device in the inventory. Retirement
+// rates cover ?from= to ?to= (YYYY-MM-DD, default the last 12 months).
+func getSustainabilityReport(c *gin.Context) {
+	now := time.Now()
+	to := now
+	from := now.AddDate(-1, 0, 0)
+	if raw := c.Query("from"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "from must be a date like 2024-01-31")
+			return
+		}
+		from = parsed
+	}
+	if raw := c.Query("to"); raw != "" {
+		parsed, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "to must be a date like 2024-01-31")
+			return
+		}
+		to = parsed.AddDate(0, 0, 1) // inclusive
+	}
+
+	var factors []EmissionFactor
+	if err := db.Find(&factors).Error; err != nil {
+		logger.Errorf("Failed to retrieve emission factors: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build sustainability report")
+		return
+	}
+
+	report := sustainabilityReport{GeneratedAt: now, Categories: []categoryEmissions{}}
+	var batch []Device
+	err := db.Select("id", "device_type", "model", "purchase_date", "status").
+		FindInBatches(&batch, chunkSize, func(tx *gorm.DB, _ int) error {
+			footprints, err := footprintsFor(db, batch, factors, now)
+			if err != nil {
+				return err
+			}
+			report.addFootprints(footprints)
+			return nil
+		}).Error
+	if err == nil {
+		report.Retirements, err = computeRetirementRates(db, from, to)
+	}
+	if err != nil {
+		logger.Errorf("Failed to build sustainability report: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build sustainability report")
+		return
+	}
+	sort.Slice(report.Categories, func(i, j int) bool {
+		return report.Categories[i].EmbodiedKg > report.Categories[j].EmbodiedKg
+	})
+	c.JSON(http.StatusOK, report)
+}
+
+func createEmissionFactor(c *gin.Context) {
+	var factor EmissionFactor
+	if err := c.ShouldBindJSON(&factor); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	factor.ID = 0
+	if err := factor.validate(); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := db.Create(&factor).Error; err != nil {
+		emissionFactorSaveFailed(c, err, "create")
+		return
+	}
+	c.JSON(http.StatusCreated, factor)
+}
+
+func listEmissionFactors(c *gin.Context) {
+	var factors []EmissionFactor
+	if err := db.Order("device_type, model").Find(&factors).Error; err != nil {
+		logger.Errorf("Failed to retrieve emission factors: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve emission factors")
+		return
+	}
+	c.JSON(http.StatusOK, factors)
+}
+
+func updateEmissionFactor(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var factor EmissionFactor
+	if err := c.ShouldBindJSON(&factor); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := factor.validate(); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	factor.ID = uint(idInt)
+
+	result := db.Model(&factor).Select("device_type", "model", "embodied_kg", "lifetime_years", "source", "updated_at").Updates(&factor)
+	if result.Error != nil {
+		emissionFactorSaveFailed(c, result.Error, "update")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Emission factor not found")
+		return
+	}
+	c.JSON(http.StatusOK, factor)
+}
+
+func deleteEmissionFactor(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	result := db.Delete(&EmissionFactor{}, idInt)
+	if result.Error != nil {
+		logger.Errorf("Failed to delete emission factor: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete emission factor")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Emission factor not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Emission factor deleted successfully"})
+}
+
+// parseEmissionFactorsCSV reads factors from a CSV file with a header row
+// naming device_type, model, embodied_kg, lifetime_years and source.
+func parseEmissionFactorsCSV(r io.Reader) ([]EmissionFactor, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"device_type", "embodied_kg", "lifetime_years"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errors.New("missing " + required + " column")
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var factors []EmissionFactor
+	seen := map[[2]string]int{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		factor := EmissionFactor{
+			DeviceType: field(record, "device_type"),
+			Model:      field(record, "model"),
+			Source:     field(record, "source"),
+		}
+		if factor.EmbodiedKg, err = strconv.ParseFloat(field(record, "embodied_kg"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: embodied_kg must be a number", line)
+		}
+		if factor.LifetimeYears, err = strconv.ParseFloat(field(record, "lifetime_years"), 64); err != nil {
+			return nil, fmt.Errorf("line %d: lifetime_years must be a number", line)
+		}
+		if err := factor.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		// A later line for the same type and model replaces an earlier one.
+		key := [2]string{strings.ToLower(factor.DeviceType), strings.ToLower(factor.Model)}
+		if i, ok := seen[key]; ok {
+			factors[i] = factor
+			continue
+		}
+		seen[key] = len(factors)
+		factors = append(factors, factor)
+	}
+	return factors, nil
+}
+
+// importEmissionFactors creates or replaces factors from an uploaded CSV
+// file. A file with any invalid line is rejected as a whole.
+func importEmissionFactors(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		logger.Warnf("File upload error: %v", err)
+		respondWithError(c, http.StatusBadRequest, "File is required")
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		logger.Errorf("Failed to open file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer src.Close()
+
+	factors, err := parseEmissionFactorsCSV(src)
+	if err != nil {
+		logger.Warnf("Invalid emission factors file: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(factors) > 0 {
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "lower(device_type)", Raw: true}, {Name: "lower(model)", Raw: true}},
+			DoUpdates: clause.AssignmentColumns([]string{"embodied_kg", "lifetime_years", "source", "updated_at"}),
+		}).CreateInBatches(&factors, chunkSize).Error
+	}
+	if err != nil {
+		logger.Errorf("Failed to import emission factors: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to import emission factors")
+		return
+	}
+
+	logger.Infof("Emission factors imported: %d", len(factors))
+	c.JSON(http.StatusOK, gin.H{"message": "Emission factors imported successfully", "imported": len(factors)})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that model factors win over type-wide ones
+func TestMatchEmissionFactor(t *testing.T) {
+	factors := []EmissionFactor{
+		{ID: 1, DeviceType: "Laptop", EmbodiedKg: 300},
+		{ID: 2, DeviceType: "Laptop", Model: "XPS 13", EmbodiedKg: 250},
+		{ID: 3, DeviceType: "Phone", Model: "Pixel 8", EmbodiedKg: 70},
+	}
+
+	assert.Equal(t, uint(2), matchEmissionFactor(factors, "laptop", "xps 13").ID)
+	assert.Equal(t, uint(1), matchEmissionFactor(factors, "Laptop", "ThinkPad").ID)
+	assert.Nil(t, matchEmissionFactor(factors, "Phone", "iPhone"))
+	assert.Nil(t, matchEmissionFactor(factors, "Monitor", ""))
+}
+
+// Test prorating embodied emissions by years of use
+func TestComputeFootprint(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	factor := &EmissionFactor{ID: 7, EmbodiedKg: 400, LifetimeYears: 4}
+
+	young := computeFootprint(Device{ID: 1, PurchaseDate: "2022-01-01"}, factor, nil, now)
+	assert.InDelta(t, 2, young.UsageYears, 0.01)
+	assert.Equal(t, 100.0, young.AnnualKg)
+	assert.Zero(t, young.ExtendedLifeSavingsKg)
+
+	retired := now.AddDate(-2, 0, 0)
+	old := computeFootprint(Device{ID: 2, PurchaseDate: "2016-01-01"}, factor, &retired, now)
+	assert.InDelta(t, 6, old.UsageYears, 0.01)
+	assert.InDelta(t, 400.0/6, old.AnnualKg, 0.1)
+	assert.InDelta(t, 200, old.ExtendedLifeSavingsKg, 0.5)
+
+	undated := computeFootprint(Device{ID: 3}, factor, nil, now)
+	assert.Zero(t, undated.UsageYears)
+	assert.Equal(t, 100.0, undated.AnnualKg)
+
+	uncovered := computeFootprint(Device{ID: 4}, nil, nil, now)
+	assert.Nil(t, uncovered.FactorID)
+	assert.Zero(t, uncovered.EmbodiedKg)
+}
+
+// Test totalling footprints by device type
+func TestSustainabilityReportAddFootprints(t *testing.T) {
+	id := uint(1)
+	report := sustainabilityReport{}
+	report.addFootprints([]deviceFootprint{
+		{DeviceType: "Laptop", FactorID: &id, EmbodiedKg: 300, AnnualKg: 75},
+		{DeviceType: "Phone"},
+	})
+	report.addFootprints([]deviceFootprint{
+		{DeviceType: "Laptop", FactorID: &id, EmbodiedKg: 300, AnnualKg: 50, ExtendedLifeSavingsKg: 100},
+	})
+
+	assert.Equal(t, 3, report.Devices)
+	assert.Equal(t, 1, report.WithoutFactor)
+	assert.Equal(t, 600.0, report.EmbodiedKg)
+	assert.Equal(t, 100.0, report.ExtendedLifeSavingsKg)
+	if assert.Len(t, report.Categories, 2) {
+		assert.Equal(t, categoryEmissions{DeviceType: "Laptop", Devices: 2, EmbodiedKg: 600, AnnualKg: 125, ExtendedLifeSavingsKg: 100}, report.Categories[0])
+		assert.Equal(t, 1, report.Categories[1].WithoutFactor)
+	}
+}
+
+// Test reading emission factors from CSV
+func TestParseEmissionFactorsCSV(t *testing.T) {
+	factors, err := parseEmissionFactorsCSV(strings.NewReader(
+		"device_type,model,embodied_kg,lifetime_years,source\n" +
+			"Laptop,,300,4,vendor LCA\n" +
+			"Laptop,XPS 13,250,4,\n" +
+			"Laptop,,320,5,newer LCA\n" +
+			"laptop,xps 13,260,4,\n"))
+	assert.NoError(t, err)
+	if assert.Len(t, factors, 2) {
+		assert.Equal(t, 320.0, factors[0].EmbodiedKg)
+		assert.Equal(t, "newer LCA", factors[0].Source)
+		assert.Equal(t, 260.0, factors[1].EmbodiedKg, "case variants replace each other")
+	}
+
+	_, err = parseEmissionFactorsCSV(strings.NewReader("device_type,embodied_kg,lifetime_years\nLaptop,lots,4\n"))
+	assert.EqualError(t, err, "line 2: embodied_kg must be a number")
+	_, err = parseEmissionFactorsCSV(strings.NewReader("device_type,embodied_kg,lifetime_years\nLaptop,300,0\n"))
+	assert.Error(t, err)
+	_, err = parseEmissionFactorsCSV(strings.NewReader("device_type,model\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultConfigPath = "config.json"
+
+// Config holds the settings loaded from the JSON config file.
+type Config struct {
+	Server        ServerConfig        `json:"server"`
+	Database      DatabaseConfig      `json:"database"`
+	Logging       LoggingConfig       `json:"logging"`
+	RateLimit     RateLimitConfig     `json:"rate_limit"`
+	CORS          CORSConfig          `json:"cors"`
+	Import        ImportConfig        `json:"import"`
+	Devices       DevicesConfig       `json:"devices"`
+	Shares        SharesConfig        `json:"shares"`
+	Warranty      WarrantyConfig      `json:"warranty"`
+	Directory     DirectoryConfig     `json:"directory"`
+	SCIM          SCIMConfig          `json:"scim"`
+	Offboarding   OffboardingConfig   `json:"offboarding"`
+	Kits          KitsConfig          `json:"kits"`
+	Stock         StockConfig         `json:"stock"`
+	Leases        LeasesConfig        `json:"leases"`
+	Mobile        MobileConfig        `json:"mobile"`
+	Calendar      CalendarConfig      `json:"calendar"`
+	Searches      SearchesConfig      `json:"searches"`
+	Notifications NotificationsConfig `json:"notifications"`
+	Retention     RetentionConfig     `json:"retention"`
+	Plugins       PluginsConfig       `json:"plugins"`
+	Transforms    TransformsConfig    `json:"transforms"`
+}
+
+// ServerConfig controls the HTTP listener.
+type ServerConfig struct {
+	Addr string `json:"addr"`
+}
+
+// DatabaseConfig is the PostgreSQL connection.
+type DatabaseConfig struct {
+	DSN string `json:"dsn"`
+}
+
+// LoggingConfig controls the application log.
+type LoggingConfig struct {
+	Level string `json:"level"` // a logrus level such as "info" or "debug"
+}
+
+// RateLimitConfig limits requests per client IP.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second"` // 0 disables the limit
+	Burst             int     `json:"burst"`
+}
+
+// CORSConfig lists the browser origins allowed to call the API.
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"` // "*" allows any origin
+}
+
+// ImportConfig sizes the CSV import pipeline.
+type ImportConfig struct {
+	Workers   int `json:"workers"`    // batches inserted concurrently
+	QueueSize int `json:"queue_size"` // lines read ahead of the parser
+}
+
+// DevicesConfig limits the device API.
+type DevicesConfig struct {
+	BatchGetMax int `json:"batch_get_max"` // IDs and asset tags one batch-get may ask for
+}
+
+// SharesConfig limits device share links.
+type SharesConfig struct {
+	DefaultTTL Duration `json:"default_ttl"`
+	MaxTTL     Duration `json:"max_ttl"`
+}
+
+// WarrantyConfig controls warranty lookups with vendor APIs.
+type WarrantyConfig struct {
+	Providers  []WarrantyProviderConfig `json:"providers"` // tried in order; the first handling a brand is used
+	Interval   Duration                 `json:"interval"`
+	BatchSize  int                      `json:"batch_size"`  // devices checked per run
+	Timeout    Duration                 `json:"timeout"`     // per lookup
+	RetryAfter Duration                 `json:"retry_after"` // before a failed lookup is tried again
+}
+
+// WarrantyProviderConfig describes a vendor's JSON warranty API.
+type WarrantyProviderConfig struct {
+	Name            string            `json:"name"`
+	Brands          []string          `json:"brands"` // any brand when empty
+	URL             string            `json:"url"`    // {brand} and {serial} are substituted
+	Headers         map[string]string `json:"headers"`
+	EndField        string            `json:"end_field"`  // dotted path to the end date in the response
+	EndFormat       string            `json:"end_format"` // Go time layout; 2006-01-02 when empty
+	Confidence      float64           `json:"confidence"` // used when the response has none
+	ConfidenceField string            `json:"confidence_field"`
+}
+
+// DirectoryConfig selects the employee directory source and how often it is synced.
+type DirectoryConfig struct {
+	Source   string     `json:"source"` // "ldap", "csv" or empty to disable
+	Interval Duration   `json:"interval"`
+	CSVPath  string     `json:"csv_path"`
+	LDAP     LDAPConfig `json:"ldap"`
+}
+
+// LDAPConfig describes how to reach and read the LDAP directory.
+type LDAPConfig struct {
+	URL          string   `json:"url"`
+	BindDN       string   `json:"bind_dn"`
+	BindPassword string   `json:"bind_password"`
+	BaseDN       string   `json:"base_dn"`
+	Filter       string   `json:"filter"`
+	IDAttribute  string   `json:"id_attribute"`
+	Timeout      Duration `json:"timeout"`
+}
+
+// SCIMConfig controls the SCIM provisioning endpoint.
+type SCIMConfig struct {
+	Token       string      `json:"token"` // bearer token the identity provider sends
+	DefaultRole string      `json:"default_role"`
+	GroupRoles  []GroupRole `json:"group_roles"`
+	MaxResults  int         `json:"max_results"`
+}
+
+// GroupRole grants Role to members of Group. Earlier entries take precedence.
+type GroupRole struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// OffboardingConfig controls device return reminders for leavers.
+type OffboardingConfig struct {
+	CheckInterval Duration `json:"check_interval"`
+	ReminderEvery Duration `json:"reminder_every"`
+	ReturnWindow  Duration `json:"return_window"` // time after the last day before escalation
+}
+
+// KitsConfig controls onboarding kit provisioning.
+type KitsConfig struct {
+	CheckoutInterval Duration `json:"checkout_interval"` // how often due kits are checked out
+}
+
+// StockConfig controls spare stock monitoring.
+type StockConfig struct {
+	InStockStatuses   []string `json:"in_stock_statuses"` // statuses that count as spare stock
+	ConsumptionWindow Duration `json:"consumption_window"`
+	CoverDays         int      `json:"cover_days"` // days of consumption a suggested order should cover
+	CheckInterval     Duration `json:"check_interval"`
+}
+
+// LeasesConfig controls lease end alerts.
+type LeasesConfig struct {
+	AlertDays     int      `json:"alert_days"` // days before a lease ends to alert, unless the lease sets its own
+	CheckInterval Duration `json:"check_interval"`
+}
+
+// MobileConfig controls SIM and mobile line tracking.
+type MobileConfig struct {
+	DeviceTypes []string `json:"device_types"` // device types that can take a SIM, case-insensitive
+}
+
+// CalendarConfig controls the iCalendar feeds.
+type CalendarConfig struct {
+	HorizonDays int `json:"horizon_days"` // how far ahead feeds look
+}
+
+// SearchesConfig controls change notifications for saved searches.
+type SearchesConfig struct {
+	CheckInterval Duration `json:"check_interval"`
+}
+
+// NotificationsConfig controls delivery of notifications by email and webhook.
+type NotificationsConfig struct {
+	DeliveryInterval    Duration   `json:"delivery_interval"`
+	DigestEvery         Duration   `json:"digest_every"`
+	SMTP                SMTPConfig `json:"smtp"`
+	WebhookSecret       string     `json:"webhook_secret"` // signs webhook payloads when set
+	WebhookTimeout      Duration   `json:"webhook_timeout"`
+	WebhookAllowedHosts []string   `json:"webhook_allowed_hosts"` // may be internal; other targets must be public
+}
+
+// SMTPConfig is the mail server notifications are sent through.
+type SMTPConfig struct {
+	Addr     string `json:"addr"` // host:port
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// RetentionConfig controls archival of retired devices.
+type RetentionConfig struct {
+	TerminalStatuses []string `json:"terminal_statuses"` // statuses a device is retired in
+	Years            int      `json:"years"`             // years in a terminal status before archival; 0 disables it
+	Target           string   `json:"target"`            // "table" or "file"
+	Dir              string   `json:"dir"`               // where archive files go
+	Interval         Duration `json:"interval"`
+}
+
+// PluginsConfig limits WebAssembly plugins.
+type PluginsConfig struct {
+	DefaultMemoryPages uint32   `json:"default_memory_pages"` // 64 KiB pages
+	MaxMemoryPages     uint32   `json:"max_memory_pages"`
+	DefaultTimeout     Duration `json:"default_timeout"`
+	MaxTimeout         Duration `json:"max_timeout"`
+	MaxModuleBytes     int64    `json:"max_module_bytes"`
+	RefreshEvery       Duration `json:"refresh_every"` // how often plugins are reloaded from the database
+}
+
+// TransformsConfig limits import transform scripts.
+type TransformsConfig struct {
+	MaxSteps    uint64 `json:"max_steps"`    // Starlark execution steps per row
+	PreviewRows int    `json:"preview_rows"` // lines a preview processes
+}
+
+// Duration is a time.Duration that reads and writes as a string like "15m".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string: %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+var activeConfig atomic.Pointer[Config]
+
+func init() {
+	activeConfig.Store(defaultConfig())
+}
+
+// config returns the settings in effect. The result may be replaced by a
+// reload at any time, so callers should not keep it beyond one operation.
+func config() *Config {
+	return activeConfig.Load()
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Database: DatabaseConfig{
+			DSN: "host=db user=postgres password=Priyajit@2002 dbname=devices port=5432 sslmode=disable",
+		},
+		Logging: LoggingConfig{
+			Level: "info",
+		},
+		Import: ImportConfig{
+			Workers:   10,
+			QueueSize: 10000,
+		},
+		Devices: DevicesConfig{
+			BatchGetMax: 500,
+		},
+		Warranty: WarrantyConfig{
+			Interval:   Duration{time.Hour},
+			BatchSize:  100,
+			Timeout:    Duration{10 * time.Second},
+			RetryAfter: Duration{24 * time.Hour},
+		},
+		Shares: SharesConfig{
+			DefaultTTL: Duration{72 * time.Hour},
+			MaxTTL:     Duration{30 * 24 * time.Hour},
+		},
+		Directory: DirectoryConfig{
+			Interval: Duration{time.Hour},
+			LDAP: LDAPConfig{
+				Filter:      "(objectClass=person)",
+				IDAttribute: "uid",
+				Timeout:     Duration{30 * time.Second},
+			},
+		},
+		SCIM: SCIMConfig{
+			DefaultRole: "user",
+			MaxResults:  100,
+		},
+		Offboarding: OffboardingConfig{
+			CheckInterval: Duration{time.Hour},
+			ReminderEvery: Duration{72 * time.Hour},
+			ReturnWindow:  Duration{7 * 24 * time.Hour},
+		},
+		Kits: KitsConfig{
+			CheckoutInterval: Duration{time.Hour},
+		},
+		Stock: StockConfig{
+			InStockStatuses:   []string{statusInStock},
+			ConsumptionWindow: Duration{30 * 24 * time.Hour},
+			CoverDays:         30,
+			CheckInterval:     Duration{time.Hour},
+		},
+		Leases: LeasesConfig{
+			AlertDays:     60,
+			CheckInterval: Duration{24 * time.Hour},
+		},
+		Mobile: MobileConfig{
+			DeviceTypes: []string{"Phone", "Smartphone", "Tablet"},
+		},
+		Calendar: CalendarConfig{
+			HorizonDays: 365,
+		},
+		Searches: SearchesConfig{
+			CheckInterval: Duration{time.Hour},
+		},
+		Notifications: NotificationsConfig{
+			DeliveryInterval: Duration{time.Minute},
+			DigestEvery:      Duration{24 * time.Hour},
+			WebhookTimeout:   Duration{10 * time.Second},
+		},
+		Retention: RetentionConfig{
+			TerminalStatuses: []string{statusDisposed, statusRecycled, statusReturned},
+			Years:            7,
+			Target:           archiveToTable,
+			Dir:              "archive",
+			Interval:         Duration{24 * time.Hour},
+		},
+		Plugins: PluginsConfig{
+			DefaultMemoryPages: 256, // 16 MiB
+			MaxMemoryPages:     1024,
+			DefaultTimeout:     Duration{100 * time.Millisecond},
+			MaxTimeout:         Duration{5 * time.Second},
+			MaxModuleBytes:     10 << 20,
+			RefreshEvery:       Duration{time.Minute},
+		},
+		Transforms: TransformsConfig{
+			MaxSteps:    100000,
+			PreviewRows: 100,
+		},
+	}
+}
+
+// configPath is the config file named by DEVICES_CONFIG, or config.json.
+func configPath() string {
+	if path := os.Getenv("DEVICES_CONFIG"); path != "" {
+		return path
+	}
+	return defaultConfigPath
+}
+
+// loadConfig reads the config file at startup. A missing file is not an
+// error; the defaults are used instead.
+func loadConfig() error {
+	path := configPath()
+	cfg, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Warnf("Config file %s not found, using defaults", path)
+		cfg = defaultConfig()
+	} else if err != nil {
+		return err
+	} else {
+		logger.Infof("Config loaded from %s", path)
+	}
+
+	activeConfig.Store(cfg)
+	startupConfig = cfg
+	applyConfig(cfg)
+	recordConfigLoad(path, nil, nil)
+	return nil
+}
+
+// readConfig reads and validates a config file over the defaults.
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := defaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// validate checks settings that JSON decoding alone cannot.
+func (c *Config) validate() error {
+	if c.Server.Addr == "" {
+		return errors.New("server.addr is required")
+	}
+	if _, err := logrus.ParseLevel(c.Logging.Level); err != nil {
+		return errors.New("logging.level must be a log level such as info or debug")
+	}
+	if c.RateLimit.RequestsPerSecond < 0 {
+		return errors.New("rate_limit.requests_per_second must not be negative")
+	}
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst < 1 {
+		return errors.New("rate_limit.burst must be at least 1")
+	}
+	if c.Import.Workers < 1 {
+		return errors.New("import.workers must be at least 1")
+	}
+	if c.Import.QueueSize < 0 {
+		return errors.New("import.queue_size must not be negative")
+	}
+	if c.Devices.BatchGetMax < 1 {
+		return errors.New("devices.batch_get_max must be at least 1")
+	}
+	if c.Warranty.BatchSize < 1 {
+		return errors.New("warranty.batch_size must be at least 1")
+	}
+	for _, p := range c.Warranty.Providers {
+		if p.Name == "" || p.URL == "" || p.EndField == "" {
+			return errors.New("warranty.providers need a name, url and end_field")
+		}
+		if p.Confidence < 0 || p.Confidence > 1 {
+			return errors.New("warranty.providers confidence must be between 0 and 1")
+		}
+	}
+	if c.Leases.AlertDays < 0 {
+		return errors.New("leases.alert_days must not be negative")
+	}
+	if c.Retention.Target != archiveToTable && c.Retention.Target != archiveToFile {
+		return errors.New("retention.target must be table or file")
+	}
+	if c.Notifications.WebhookTimeout.Duration <= 0 {
+		return errors.New("notifications.webhook_timeout must be positive")
+	}
+	if c.Plugins.MaxMemoryPages < 1 {
+		return errors.New("plugins.max_memory_pages must be at least 1")
+	}
+	if c.Plugins.DefaultMemoryPages < 1 || c.Plugins.DefaultMemoryPages > c.Plugins.MaxMemoryPages {
+		return errors.New("plugins.default_memory_pages must be between 1 and plugins.max_memory_pages")
+	}
+	if c.Plugins.MaxTimeout.Duration <= 0 {
+		return errors.New("plugins.max_timeout must be positive")
+	}
+	if c.Plugins.DefaultTimeout.Duration <= 0 || c.Plugins.DefaultTimeout.Duration > c.Plugins.MaxTimeout.Duration {
+		return errors.New("plugins.default_timeout must be positive and at most plugins.max_timeout")
+	}
+	if c.Plugins.MaxModuleBytes <= 0 {
+		return errors.New("plugins.max_module_bytes must be positive")
+	}
+	if c.Plugins.RefreshEvery.Duration <= 0 {
+		return errors.New("plugins.refresh_every must be positive")
+	}
+	if c.Transforms.MaxSteps < 1 {
+		return errors.New("transforms.max_steps must be at least 1")
+	}
+	if c.Transforms.PreviewRows < 1 {
+		return errors.New("transforms.preview_rows must be at least 1")
+	}
+	if c.SCIM.MaxResults < 1 {
+		return errors.New("scim.max_results must be at least 1")
+	}
+	if c.Shares.DefaultTTL.Duration <= 0 || c.Shares.DefaultTTL.Duration > c.Shares.MaxTTL.Duration {
+		return errors.New("shares.default_ttl must be positive and at most shares.max_ttl")
+	}
+	if c.Calendar.HorizonDays < 0 {
+		return errors.New("calendar.horizon_days must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Consumable is a kind of non-serialized stock, such as a cable or a toner
+// cartridge, counted by quantity rather than tracked unit by unit. SKUs are
+// unique regardless of case.
+type Consumable struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SKU       string    `gorm:"column:sku;uniqueIndex:idx_consumables_sku_lower,expression:lower(sku)" json:"sku" binding:"required"`
+	Name      string    `gorm:"column:name" json:"name" binding:"required"`
+	Unit      string    `gorm:"column:unit" json:"unit"` // like "each" or "box of 10"
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// dropLegacyConsumableSKUIndex removes the case-sensitive SKU index of
+// databases created before it was replaced by one on lower(sku).
+func dropLegacyConsumableSKUIndex(tx *gorm.DB) error {
+	const legacy = "idx_consumables_sku"
+	if !tx.Migrator().HasIndex(&Consumable{}, legacy) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(&Consumable{}, legacy)
+}
+
+// ConsumableStock is the quantity of a consumable held at one location.
+// It is only changed together with a ledger entry.
+type ConsumableStock struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ConsumableID uint      `gorm:"column:consumable_id;uniqueIndex:idx_consumable_stock_location" json:"consumable_id"`
+	LocationID   uint      `gorm:"column:location_id;uniqueIndex:idx_consumable_stock_location" json:"location_id"`
+	Quantity     int       `gorm:"column:quantity" json:"quantity"`
+	Minimum      int       `gorm:"column:minimum" json:"minimum"` // low-stock alert below this; 0 disables it
+	UpdatedAt    time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// ConsumableEntry is one line of the consumables ledger. Entries are never
+// changed or deleted; mistakes are corrected with further movements.
+// Balance is the quantity at the location after the entry.
+type ConsumableEntry struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	ConsumableID uint      `gorm:"column:consumable_id;index:idx_consumable_entries_stock" json:"consumable_id"`
+	LocationID   uint      `gorm:"column:location_id;index:idx_consumable_entries_stock" json:"location_id"`
+	Kind         string    `gorm:"column:kind" json:"kind"`
+	Quantity     int       `gorm:"column:quantity" json:"quantity"` // signed change
+	Balance      int       `gorm:"column:balance" json:"balance"`
+	EmployeeID   *uint     `gorm:"column:employee_id;index" json:"employee_id"`     // who an issue went to
+	PeerLocation *uint     `gorm:"column:peer_location_id" json:"peer_location_id"` // the other side of a transfer
+	Reference    string    `gorm:"column:reference" json:"reference"`               // purchase order, ticket and so on
+	Note         string    `gorm:"column:note" json:"note"`
+	CreatedAt    time.Time `gorm:"column:created_at;index" json:"created_at"`
+}
+
+// Ledger entry kinds.
+const (
+	entryReceive     = "receive"
+	entryIssue       = "issue"
+	entryTransferOut = "transfer_out"
+	entryTransferIn  = "transfer_in"
+)
+
+// ConsumableAlert is raised when stock at a location drops below its
+// minimum and resolved when it recovers.
+type ConsumableAlert struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	ConsumableID uint       `gorm:"column:consumable_id;index" json:"consumable_id"`
+	LocationID   uint       `gorm:"column:location_id" json:"location_id"`
+	Quantity     int        `gorm:"column:quantity" json:"quantity"`
+	Minimum      int        `gorm:"column:minimum" json:"minimum"`
+	RaisedAt     time.Time  `gorm:"column:raised_at" json:"raised_at"`
+	ResolvedAt   *time.Time `gorm:"column:resolved_at" json:"resolved_at"`
+}
+
+// insufficientStockError is returned when a movement would take a location's
+// quantity below zero.
+type insufficientStockError struct {
+	LocationID uint
+	Available  int
+}
+
+func (e *insufficientStockError) Error() string {
+	return fmt.Sprintf("only %d available at location %d", e.Available, e.LocationID)
+}
+
+// lockConsumableStock returns the stock row for a consumable at a location,
+// creating an empty one if needed, locked until the transaction ends.
+func lockConsumableStock(tx *gorm.DB, consumableID, locationID uint) (ConsumableStock, error) {
+	stock := ConsumableStock{ConsumableID: consumableID, LocationID: locationID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&stock).Error; err != nil {
+		return stock, err
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("consumable_id = ? AND location_id = ?", consumableID, locationID).First(&stock).Error
+	return stock, err
+}
+
+// postEntry applies entry.Quantity to the locked stock row and appends the
+// entry to the ledger with the resulting balance.
+func postEntry(tx *gorm.DB, stock *ConsumableStock, entry *ConsumableEntry) error {
+	balance := stock.Quantity + entry.Quantity
+	if balance < 0 {
+		return &insufficientStockError{LocationID: stock.LocationID, Available: stock.Quantity}
+	}
+	if err := tx.Model(stock).Update("quantity", balance).Error; err != nil {
+		return err
+	}
+	stock.Quantity = balance
+	entry.ConsumableID = stock.ConsumableID
+	entry.LocationID = stock.LocationID
+	entry.Balance = balance
+	if err := tx.Create(entry).Error; err != nil {
+		return err
+	}
+	return checkConsumableAlert(tx, *stock)
+}
+
+// lowStockTransition reports whether an alert should be raised or resolved.
+func lowStockTransition(quantity, minimum int, hasOpen bool) (raise, resolve bool) {
+	low := minimum > 0 && quantity < minimum
+	return low && !hasOpen, !low && hasOpen
+}
+
+// checkConsumableAlert raises or resolves the low-stock alert for a stock row.
+func checkConsumableAlert(tx *gorm.DB, stock ConsumableStock) error {
+	var open ConsumableAlert
+	err := tx.Where("consumable_id = ? AND location_id = ? AND resolved_at IS NULL", stock.ConsumableID, stock.LocationID).
+		First(&open).Error
+	hasOpen := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	raise, resolve := lowStockTransition(stock.Quantity, stock.Minimum, hasOpen)
+	now := time.Now()
+	switch {
+	case raise:
+		alert := ConsumableAlert{
+			ConsumableID: stock.ConsumableID, LocationID: stock.LocationID,
+			Quantity: stock.Quantity, Minimum: stock.Minimum, RaisedAt: now,
+		}
+		if err := tx.Create(&alert).Error; err != nil {
+			return err
+		}
+		var consumable Consumable
+		if err := tx.First(&consumable, stock.ConsumableID).Error; err != nil {
+			return err
+		}
+		logger.Warnf("Consumable %s below minimum %d at location %d: %d left",
+			consumable.SKU, stock.Minimum, stock.LocationID, stock.Quantity)
+		return notify(tx, notificationEvent{
+			Type:    eventStockLow,
+			Subject: fmt.Sprintf("Low stock: %s (%s)", consumable.Name, consumable.SKU),
+			Body:    fmt.Sprintf("%d left at location %d, minimum %d.", stock.Quantity, stock.LocationID, stock.Minimum),
+			Key:     "consumable-alert-" + strconv.Itoa(int(alert.ID)),
+		})
+	case resolve:
+		return tx.Model(&open).Update("resolved_at", now).Error
+	}
+	return nil
+}
+
+// parseConsumableID reads :id and checks the consumable exists.
+func parseConsumableID(c *gin.Context) (Consumable, bool) {
+	var consumable Consumable
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return consumable, false
+	}
+	if err := db.First(&consumable, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Consumable not found")
+		} else {
+			logger.Errorf("Failed to retrieve consumable: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve consumable")
+		}
+		return consumable, false
+	}
+	return consumable, true
+}
+
+// locationExists reports whether a location ID is known, responding with
+// 400 if it is not.
+func locationExists(c *gin.Context, id uint) bool {
+	var count int64
+	if err := db.Model(&Location{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		logger.Errorf("Failed to retrieve location: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve location")
+		return false
+	}
+	if count == 0 {
+		respondWithError(c, http.StatusBadRequest, fmt.Sprintf("Location %d not found", id))
+		return false
+	}
+	return true
+}
+
+// respondToMovement reports the outcome of a stock movement.
+func respondToMovement(c *gin.Context, err error, entries []ConsumableEntry) {
+	var short *insufficientStockError
+	if errors.As(err, &short) {
+		respondWithError(c, http.StatusConflict, "Insufficient stock: "+short.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to record consumable movement: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to record movement")
+		return
+	}
+	c.JSON(http.StatusCreated, entries)
+}
+
+type movementInput struct {
+	LocationID uint   `json:"location_id" binding:"required"`
+	Quantity   int    `json:"quantity" binding:"required,min=1"`
+	EmployeeID *uint  `json:"employee_id"`
+	Reference  string `json:"reference"`
+	Note       string `json:"note"`
+}
+
+func receiveConsumable(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	var input movementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !locationExists(c, input.LocationID) {
+		return
+	}
+
+	entry := ConsumableEntry{Kind: entryReceive, Quantity: input.Quantity, Reference: input.Reference, Note: input.Note}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		stock, err := lockConsumableStock(tx, consumable.ID, input.LocationID)
+		if err != nil {
+			return err
+		}
+		return postEntry(tx, &stock, &entry)
+	})
+	respondToMovement(c, err, []ConsumableEntry{entry})
+}
+
+// issueConsumable takes stock out of a location, usually handing it to an
+// employee.
+func issueConsumable(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	var input movementInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !locationExists(c, input.LocationID) {
+		return
+	}
+	if input.EmployeeID != nil {
+		var employee Employee
+		if err := db.Select("id", "active").First(&employee, *input.EmployeeID).Error; err != nil || !employee.Active {
+			respondWithError(c, http.StatusBadRequest, "employee_id must be an active employee")
+			return
+		}
+	}
+
+	entry := ConsumableEntry{
+		Kind: entryIssue, Quantity: -input.Quantity, EmployeeID: input.EmployeeID,
+		Reference: input.Reference, Note: input.Note,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		stock, err := lockConsumableStock(tx, consumable.ID, input.LocationID)
+		if err != nil {
+			return err
+		}
+		return postEntry(tx, &stock, &entry)
+	})
+	respondToMovement(c, err, []ConsumableEntry{entry})
+}
+
+// transferConsumable moves stock between two locations as a pair of ledger
+// entries that are posted together or not at all.
+func transferConsumable(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		FromLocationID uint   `json:"from_location_id" binding:"required"`
+		ToLocationID   uint   `json:"to_location_id" binding:"required"`
+		Quantity       int    `json:"quantity" binding:"required,min=1"`
+		Reference      string `json:"reference"`
+		Note           string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.FromLocationID == input.ToLocationID {
+		respondWithError(c, http.StatusBadRequest, "from_location_id and to_location_id must differ")
+		return
+	}
+	if !locationExists(c, input.FromLocationID) || !locationExists(c, input.ToLocationID) {
+		return
+	}
+
+	from, to := input.FromLocationID, input.ToLocationID
+	out := ConsumableEntry{Kind: entryTransferOut, Quantity: -input.Quantity, PeerLocation: &to, Reference: input.Reference, Note: input.Note}
+	in := ConsumableEntry{Kind: entryTransferIn, Quantity: input.Quantity, PeerLocation: &from, Reference: input.Reference, Note: input.Note}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock in location order so opposite transfers cannot deadlock.
+		first, second := from, to
+		if second < first {
+			first, second = second, first
+		}
+		stocks := map[uint]*ConsumableStock{}
+		for _, locationID := range []uint{first, second} {
+			stock, err := lockConsumableStock(tx, consumable.ID, locationID)
+			if err != nil {
+				return err
+			}
+			stocks[locationID] = &stock
+		}
+		if err := postEntry(tx, stocks[from], &out); err != nil {
+			return err
+		}
+		return postEntry(tx, stocks[to], &in)
+	})
+	respondToMovement(c, err, []ConsumableEntry{out, in})
+}
+
+func createConsumable(c *gin.Context) {
+	var consumable Consumable
+	if err := c.ShouldBindJSON(&consumable); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	consumable.ID = 0
+	consumable.SKU = strings.TrimSpace(consumable.SKU)
+
+	if err := db.Create(&consumable).Error; err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "A consumable with this SKU already exists")
+			return
+		}
+		logger.Errorf("Failed to create consumable: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create consumable")
+		return
+	}
+
+	logger.Infof("Consumable created: %s", consumable.SKU)
+	c.JSON(http.StatusCreated, consumable)
+}
+
+func listConsumables(c *gin.Context) {
+	var consumables []Consumable
+	if err := db.Order("sku").Find(&consumables).Error; err != nil {
+		logger.Errorf("Failed to retrieve consumables: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve consumables")
+		return
+	}
+	c.JSON(http.StatusOK, consumables)
+}
+
+// getConsumable returns a consumable with its stock at each location.
+func getConsumable(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	var stock []ConsumableStock
+	if err := db.Where("consumable_id = ?", consumable.ID).Order("location_id").Find(&stock).Error; err != nil {
+		logger.Errorf("Failed to retrieve consumable stock: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve consumable")
+		return
+	}
+	total := 0
+	for _, s := range stock {
+		total += s.Quantity
+	}
+	c.JSON(http.StatusOK, gin.H{"consumable": consumable, "stock": stock, "total": total})
+}
+
+func updateConsumable(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+		Unit string `json:"unit"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The SKU is how the ledger is read back, so it cannot change.
+	if err := db.Model(&consumable).Updates(Consumable{Name: input.Name, Unit: input.Unit}).Error; err != nil {
+		logger.Errorf("Failed to update consumable: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update consumable")
+		return
+	}
+	c.JSON(http.StatusOK, consumable)
+}
+
+// setConsumableMinimum sets the low-stock level for a consumable at a
+// location and re-evaluates its alert.
+func setConsumableMinimum(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	locationID, err := strconv.Atoi(c.Param("location_id"))
+	if err != nil || locationID <= 0 {
+		respondWithError(c, http.StatusBadRequest, "Invalid location ID format")
+		return
+	}
+	var input struct {
+		Minimum *int `json:"minimum" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if *input.Minimum < 0 {
+		respondWithError(c, http.StatusBadRequest, "minimum must not be negative")
+		return
+	}
+	if !locationExists(c, uint(locationID)) {
+		return
+	}
+
+	var stock ConsumableStock
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if stock, err = lockConsumableStock(tx, consumable.ID, uint(locationID)); err != nil {
+			return err
+		}
+		if err := tx.Model(&stock).Update("minimum", *input.Minimum).Error; err != nil {
+			return err
+		}
+		return checkConsumableAlert(tx, stock)
+	})
+	if err != nil {
+		logger.Errorf("Failed to set consumable minimum: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to set minimum")
+		return
+	}
+	c.JSON(http.StatusOK, stock)
+}
+
+// listConsumableLedger returns a consumable's ledger, newest first,
+// optionally for one ?location_id=.
+func listConsumableLedger(c *gin.Context) {
+	consumable, ok := parseConsumableID(c)
+	if !ok {
+		return
+	}
+	locationID, err := parseOptionalUint(c.Request.URL.Query(), "location_id")
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	offset := (page - 1) * limit
+
+	query := db.Where("consumable_id = ?", consumable.ID)
+	if locationID != nil {
+		query = query.Where("location_id = ?", *locationID)
+	}
+	var entries []ConsumableEntry
+	if err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&entries).Error; err != nil {
+		logger.Errorf("Failed to retrieve consumable ledger: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve ledger")
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func listConsumableAlerts(c *gin.Context) {
+	query := db.Order("raised_at DESC")
+	if c.Query("open") == "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+	var alerts []ConsumableAlert
+	if err := query.Find(&alerts).Error; err != nil {
+		logger.Errorf("Failed to retrieve consumable alerts: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve consumable alerts")
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
+
+// listEmployeeConsumables returns what has been issued to an employee.
+func listEmployeeConsumables(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var entries []ConsumableEntry
+	if err := db.Where("employee_id = ?", idInt).Order("id DESC").Find(&entries).Error; err != nil {
+		logger.Errorf("Failed to retrieve issued consumables: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve issued consumables")
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
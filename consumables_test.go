@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test when consumable low-stock alerts are raised and resolved
+func TestLowStockTransition(t *testing.T) {
+	raise, resolve := lowStockTransition(2, 5, false)
+	assert.True(t, raise)
+	assert.False(t, resolve)
+
+	raise, resolve = lowStockTransition(2, 5, true)
+	assert.False(t, raise, "an open alert is not raised again")
+	assert.False(t, resolve)
+
+	raise, resolve = lowStockTransition(5, 5, true)
+	assert.False(t, raise)
+	assert.True(t, resolve)
+
+	raise, resolve = lowStockTransition(0, 0, false)
+	assert.False(t, raise, "a zero minimum disables alerts")
+	assert.False(t, resolve)
+}
+
+// Test the message for a movement that would overdraw a location
+func TestInsufficientStockError(t *testing.T) {
+	err := &insufficientStockError{LocationID: 3, Available: 2}
+	assert.EqualError(t, err, "only 2 available at location 3")
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"gorm.io/gorm"
+)
+
+// Employee is a person devices can be assigned to, kept in step with the directory.
+type Employee struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	ExternalID    string     `gorm:"column:external_id;uniqueIndex" json:"external_id"`
+	UserName      string     `gorm:"column:user_name;index:idx_employees_user_name_unique,unique,where:user_name <> ''" json:"user_name"` // unique when set; directory sync leaves it empty
+	Email         string     `gorm:"column:email" json:"email"`
+	FirstName     string     `gorm:"column:first_name" json:"first_name"`
+	LastName      string     `gorm:"column:last_name" json:"last_name"`
+	Department    string     `gorm:"column:department" json:"department"`
+	Title         string     `gorm:"column:title" json:"title"`
+	ManagerID     *uint      `gorm:"column:manager_id" json:"manager_id"`
+	Role          string     `gorm:"column:role" json:"role"`
+	Source        string     `gorm:"column:source" json:"source"`
+	Active        bool       `gorm:"column:active" json:"active"`
+	DeactivatedAt *time.Time `gorm:"column:deactivated_at" json:"deactivated_at"`
+	LeavingOn     *time.Time `gorm:"column:leaving_on" json:"leaving_on"` // last day once offboarding has started
+	ErasedAt      *time.Time `gorm:"column:erased_at" json:"erased_at"`   // personal data pseudonymized
+	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// dropLegacyUserNameIndex removes the plain user_name index of databases
+// created before userName had to be unique. AutoMigrate only adds the new
+// partial unique index next to it.
+func dropLegacyUserNameIndex(tx *gorm.DB) error {
+	const legacy = "idx_employees_user_name"
+	if !tx.Migrator().HasIndex(&Employee{}, legacy) {
+		return nil
+	}
+	return tx.Migrator().DropIndex(&Employee{}, legacy)
+}
+
+// DirectoryEntry is one person as reported by a directory source.
+type DirectoryEntry struct {
+	ExternalID        string
+	Email             string
+	FirstName         string
+	LastName          string
+	Department        string
+	Title             string
+	ManagerExternalID string
+}
+
+// DirectorySource is anything that can list the current employees.
+type DirectorySource interface {
+	Name() string
+	FetchEntries(ctx context.Context) ([]DirectoryEntry, error)
+}
+
+// DirectorySyncReport summarises the outcome of one sync run.
+type DirectorySyncReport struct {
+	Source         string    `json:"source"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	Created        int       `json:"created"`
+	Updated        int       `json:"updated"`
+	Deactivated    int       `json:"deactivated"`
+	FlaggedDevices []Device  `json:"flagged_devices"`
+	Error          string    `json:"error,omitempty"`
+}
+
+var (
+	directoryMu       sync.Mutex
+	lastDirectorySync *DirectorySyncReport
+)
+
+// CSVDirectorySource reads employees from a CSV file with a header row.
+// Recognised columns: employee_id, email, first_name, last_name, department,
+// title and manager_id.
+type CSVDirectorySource struct {
+	Path string
+}
+
+func (s *CSVDirectorySource) Name() string {
+	return "csv"
+}
+
+func (s *CSVDirectorySource) FetchEntries(ctx context.Context) ([]DirectoryEntry, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseDirectoryCSV(file)
+}
+
+func parseDirectoryCSV(r io.Reader) ([]DirectoryEntry, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["employee_id"]; !ok {
+		return nil, errors.New("missing employee_id column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []DirectoryEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := DirectoryEntry{
+			ExternalID:        field(record, "employee_id"),
+			Email:             field(record, "email"),
+			FirstName:         field(record, "first_name"),
+			LastName:          field(record, "last_name"),
+			Department:        field(record, "department"),
+			Title:             field(record, "title"),
+			ManagerExternalID: field(record, "manager_id"),
+		}
+		if entry.ExternalID == "" {
+			logger.Warnf("Skipping directory row without employee_id: %v", record)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// LDAPDirectorySource reads employees from an LDAP server. Pointing URL at a
+// local stand-in server (for example glauth or OpenLDAP in a container) is
+// enough to exercise it in tests.
+type LDAPDirectorySource struct {
+	Config LDAPConfig
+}
+
+func (s *LDAPDirectorySource) Name() string {
+	return "ldap"
+}
+
+func (s *LDAPDirectorySource) FetchEntries(ctx context.Context) ([]DirectoryEntry, error) {
+	cfg := s.Config
+	conn, err := ldap.DialURL(cfg.URL, ldap.DialWithDialer(&net.Dialer{Timeout: cfg.Timeout.Duration}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP: %v", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(cfg.Timeout.Duration)
+
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind to LDAP: %v", err)
+		}
+	}
+
+	idAttr := cfg.IDAttribute
+	if idAttr == "" {
+		idAttr = "uid"
+	}
+	request := ldap.NewSearchRequest(
+		cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		cfg.Filter,
+		[]string{idAttr, "mail", "givenName", "sn", "department", "title", "manager"},
+		nil,
+	)
+	result, err := conn.SearchWithPaging(request, 500)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search failed: %v", err)
+	}
+
+	// manager holds a DN, so resolve it to the manager's ID attribute.
+	idByDN := make(map[string]string)
+	for _, entry := range result.Entries {
+		idByDN[strings.ToLower(entry.DN)] = entry.GetAttributeValue(idAttr)
+	}
+
+	var entries []DirectoryEntry
+	for _, entry := range result.Entries {
+		id := entry.GetAttributeValue(idAttr)
+		if id == "" {
+			logger.Warnf("Skipping LDAP entry without %s: %s", idAttr, entry.DN)
+			continue
+		}
+		entries = append(entries, DirectoryEntry{
+			ExternalID:        id,
+			Email:             entry.GetAttributeValue("mail"),
+			FirstName:         entry.GetAttributeValue("givenName"),
+			LastName:          entry.GetAttributeValue("sn"),
+			Department:        entry.GetAttributeValue("department"),
+			Title:             entry.GetAttributeValue("title"),
+			ManagerExternalID: idByDN[strings.ToLower(entry.GetAttributeValue("manager"))],
+		})
+	}
+	return entries, nil
+}
+
+// newDirectorySource builds the source selected in the config, or nil if
+// directory sync is disabled.
+func newDirectorySource(cfg DirectoryConfig) (DirectorySource, error) {
+	switch cfg.Source {
+	case "":
+		return nil, nil
+	case "csv":
+		return &CSVDirectorySource{Path: cfg.CSVPath}, nil
+	case "ldap":
+		return &LDAPDirectorySource{Config: cfg.LDAP}, nil
+	default:
+		return nil, fmt.Errorf("unknown directory source %q", cfg.Source)
+	}
+}
+
+// directorySyncPlan lists the changes needed to bring the employee table in
+// line with a directory snapshot.
+type directorySyncPlan struct {
+	Create     []Employee
+	Update     []Employee
+	Deactivate []Employee
+}
+
+// planDirectorySync compares existing employees from one source with the
+// entries that source returned. Employees missing from the snapshot are
+// deactivated; ones that reappear are reactivated.
+func planDirectorySync(source string, existing []Employee, entries []DirectoryEntry) directorySyncPlan {
+	var plan directorySyncPlan
+
+	byExternalID := make(map[string]Employee, len(existing))
+	for _, e := range existing {
+		byExternalID[e.ExternalID] = e
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if seen[entry.ExternalID] {
+			continue
+		}
+		seen[entry.ExternalID] = true
+
+		current, ok := byExternalID[entry.ExternalID]
+		if !ok {
+			plan.Create = append(plan.Create, Employee{
+				ExternalID: entry.ExternalID,
+				Email:      entry.Email,
+				FirstName:  entry.FirstName,
+				LastName:   entry.LastName,
+				Department: entry.Department,
+				Title:      entry.Title,
+				Source:     source,
+				Active:     true,
+			})
+			continue
+		}
+
+		if current.Active && current.Email == entry.Email && current.FirstName == entry.FirstName &&
+			current.LastName == entry.LastName && current.Department == entry.Department && current.Title == entry.Title {
+			continue
+		}
+		current.Email = entry.Email
+		current.FirstName = entry.FirstName
+		current.LastName = entry.LastName
+		current.Department = entry.Department
+		current.Title = entry.Title
+		current.Active = true
+		current.DeactivatedAt = nil
+		plan.Update = append(plan.Update, current)
+	}
+
+	for _, e := range existing {
+		if e.Active && e.Source == source && !seen[e.ExternalID] {
+			plan.Deactivate = append(plan.Deactivate, e)
+		}
+	}
+	return plan
+}
+
+// syncDirectory pulls the current snapshot from source and applies it.
+func syncDirectory(ctx context.Context, source DirectorySource) (*DirectorySyncReport, error) {
+	directoryMu.Lock()
+	defer directoryMu.Unlock()
+
+	report := &DirectorySyncReport{Source: source.Name(), StartedAt: time.Now()}
+	defer func() {
+		report.FinishedAt = time.Now()
+		lastDirectorySync = report
+	}()
+
+	entries, err := source.FetchEntries(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	if len(entries) == 0 {
+		// An empty snapshot almost always means a broken source, not that
+		// everyone left; refuse rather than deactivate the whole company.
+		err := errors.New("directory source returned no entries")
+		report.Error = err.Error()
+		return report, err
+	}
+
+	var existing []Employee
+	if err := db.WithContext(ctx).Find(&existing).Error; err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	plan := planDirectorySync(source.Name(), existing, entries)
+
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(plan.Create) > 0 {
+			if err := tx.Create(&plan.Create).Error; err != nil {
+				return err
+			}
+		}
+		for _, e := range plan.Update {
+			if err := tx.Select("email", "first_name", "last_name", "department", "title", "active", "deactivated_at").
+				Updates(&e).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for _, e := range plan.Deactivate {
+			if err := tx.Model(&Employee{}).Where("id = ?", e.ID).
+				Updates(map[string]interface{}{"active": false, "deactivated_at": now}).Error; err != nil {
+				return err
+			}
+			if _, err := startOffboarding(tx, e.ID, now); err != nil {
+				return err
+			}
+		}
+		return resolveManagers(tx, entries)
+	})
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	report.Created = len(plan.Create)
+	report.Updated = len(plan.Update)
+	report.Deactivated = len(plan.Deactivate)
+
+	flagged, err := devicesAssignedToInactiveEmployees(db.WithContext(ctx))
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	report.FlaggedDevices = flagged
+	for _, device := range flagged {
+		logger.Warnf("Device %d is still assigned to deactivated employee %d", device.ID, *device.AssignedTo)
+	}
+
+	logger.Infof("Directory sync from %s: %d created, %d updated, %d deactivated, %d devices flagged",
+		report.Source, report.Created, report.Updated, report.Deactivated, len(flagged))
+	return report, nil
+}
+
+// resolveManagers points each employee at their manager once every employee
+// in the snapshot has a row.
+func resolveManagers(tx *gorm.DB, entries []DirectoryEntry) error {
+	var employees []Employee
+	if err := tx.Select("id", "external_id", "manager_id").Find(&employees).Error; err != nil {
+		return err
+	}
+	idByExternalID := make(map[string]uint, len(employees))
+	managerByID := make(map[uint]*uint, len(employees))
+	for _, e := range employees {
+		idByExternalID[e.ExternalID] = e.ID
+		managerByID[e.ID] = e.ManagerID
+	}
+
+	for _, entry := range entries {
+		id := idByExternalID[entry.ExternalID]
+		var want *uint
+		if managerID, ok := idByExternalID[entry.ManagerExternalID]; ok && managerID != id {
+			want = &managerID
+		}
+		current := managerByID[id]
+		if (current == nil && want == nil) || (current != nil && want != nil && *current == *want) {
+			continue
+		}
+		if err := tx.Model(&Employee{}).Where("id = ?", id).Update("manager_id", want).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func devicesAssignedToInactiveEmployees(tx *gorm.DB) ([]Device, error) {
+	var devices []Device
+	err := tx.Where("assigned_to IN (?)", tx.Model(&Employee{}).Select("id").Where("active = ?", false)).
+		Find(&devices).Error
+	return devices, err
+}
+
+// startDirectorySync schedules the configured directory source, if any.
+func startDirectorySync() {
+	source, err := newDirectorySource(config().Directory)
+	if err != nil {
+		logger.Errorf("Directory sync not started: %v", err)
+		return
+	}
+	if source == nil {
+		logger.Info("Directory sync disabled: no source configured")
+		return
+	}
+	startJob("directory-sync", config().Directory.Interval.Duration, func(ctx context.Context) error {
+		_, err := syncDirectory(ctx, source)
+		return err
+	})
+}
+
+func runDirectorySync(c *gin.Context) {
+	source, err := newDirectorySource(config().Directory)
+	if err != nil {
+		logger.Errorf("Invalid directory source: %v", err)
+		respondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if source == nil {
+		respondWithError(c, http.StatusConflict, "Directory sync is not configured")
+		return
+	}
+
+	report, err := syncDirectory(c.Request.Context(), source)
+	if err != nil {
+		logger.Errorf("Directory sync failed: %v", err)
+		c.JSON(http.StatusBadGateway, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func getDirectorySyncStatus(c *gin.Context) {
+	directoryMu.Lock()
+	report := lastDirectorySync
+	directoryMu.Unlock()
+
+	if report == nil {
+		respondWithError(c, http.StatusNotFound, "No directory sync has run yet")
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func listFlaggedDevices(c *gin.Context) {
+	devices, err := devicesAssignedToInactiveEmployees(db)
+	if err != nil {
+		logger.Errorf("Failed to retrieve flagged devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve flagged devices")
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+func listEmployees(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset := (page - 1) * limit
+
+	query := db.Limit(limit).Offset(offset)
+	if active := c.Query("active"); active != "" {
+		query = query.Where("active = ?", active == "true")
+	}
+
+	var employees []Employee
+	if err := query.Find(&employees).Error; err != nil {
+		logger.Errorf("Failed to retrieve employees: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve employees")
+		return
+	}
+	c.JSON(http.StatusOK, employees)
+}
+
+func getEmployeeByID(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var employee Employee
+	if err := db.First(&employee, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Employee not found")
+		} else {
+			logger.Errorf("Failed to retrieve employee: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve employee")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, employee)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test parsing a directory CSV export
+func TestParseDirectoryCSV(t *testing.T) {
+	csvData := `employee_id,email,first_name,last_name,department,title,manager_id
+E1,ada@example.com,Ada,Lovelace,Engineering,Engineer,E2
+E2,grace@example.com,Grace,Hopper,Engineering,Manager,
+,nobody@example.com,No,Id,,,`
+
+	entries, err := parseDirectoryCSV(strings.NewReader(csvData))
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "E1", entries[0].ExternalID)
+	assert.Equal(t, "E2", entries[0].ManagerExternalID)
+	assert.Equal(t, "Hopper", entries[1].LastName)
+}
+
+// Test that a CSV without an employee_id column is rejected
+func TestParseDirectoryCSVMissingID(t *testing.T) {
+	_, err := parseDirectoryCSV(strings.NewReader("email,first_name\na@example.com,A"))
+	assert.Error(t, err)
+}
+
+// Test create, update and deactivate planning
+func TestPlanDirectorySync(t *testing.T) {
+	existing := []Employee{
+		{ID: 1, ExternalID: "E1", Email: "old@example.com", Source: "csv", Active: true},
+		{ID: 2, ExternalID: "E2", Email: "grace@example.com", Source: "csv", Active: true},
+		{ID: 3, ExternalID: "E3", Source: "csv", Active: true},
+		{ID: 4, ExternalID: "E4", Source: "ldap", Active: true},
+		{ID: 5, ExternalID: "E5", Source: "csv", Active: false},
+	}
+	entries := []DirectoryEntry{
+		{ExternalID: "E1", Email: "ada@example.com"},
+		{ExternalID: "E2", Email: "grace@example.com"},
+		{ExternalID: "E5"},
+		{ExternalID: "E6", Email: "new@example.com"},
+	}
+
+	plan := planDirectorySync("csv", existing, entries)
+
+	assert.Len(t, plan.Create, 1)
+	assert.Equal(t, "E6", plan.Create[0].ExternalID)
+	assert.True(t, plan.Create[0].Active)
+
+	assert.Len(t, plan.Update, 2)
+	assert.Equal(t, "ada@example.com", plan.Update[0].Email)
+	assert.True(t, plan.Update[1].Active) // E5 reactivated
+
+	// E4 belongs to another source and is left alone
+	assert.Len(t, plan.Deactivate, 1)
+	assert.Equal(t, "E3", plan.Deactivate[0].ExternalID)
+}
+
+// Test reading from a local stand-in LDAP server, when one is available
+func TestLDAPDirectorySource(t *testing.T) {
+	url := os.Getenv("LDAP_TEST_URL")
+	if url == "" {
+		t.Skip("LDAP_TEST_URL not set")
+	}
+
+	source := &LDAPDirectorySource{Config: LDAPConfig{
+		URL:          url,
+		BindDN:       os.Getenv("LDAP_TEST_BIND_DN"),
+		BindPassword: os.Getenv("LDAP_TEST_BIND_PASSWORD"),
+		BaseDN:       os.Getenv("LDAP_TEST_BASE_DN"),
+		Filter:       "(objectClass=person)",
+		IDAttribute:  "uid",
+		Timeout:      defaultConfig().Directory.LDAP.Timeout,
+	}}
+
+	entries, err := source.FetchEntries(context.Background())
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, entries)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// deviceFilter is the set of filters and sort order accepted by listDevices.
+type deviceFilter struct {
+	Query          string // matched against name, brand and model
+	DeviceType     string
+	Brand          string
+	Model          string
+	Status         string
+	Os             string
+	LocationID     *uint
+	AssignedTo     *uint
+	WarrantyBefore string // YYYY-MM-DD, exclusive
+	WarrantyAfter  string // YYYY-MM-DD, inclusive
+	WarrantyWithin *int   // days from today, so saved searches stay current
+	Sort           string
+	Desc           bool
+	Fields         []string // JSON field names to return; all when empty
+	Geo            geoQuery
+}
+
+// deviceSortColumns are the columns devices may be sorted by.
+var deviceSortColumns = map[string]bool{
+	"id": true, "device_name": true, "asset_tag": true, "device_type": true, "brand": true, "model": true,
+	"status": true, "price": true, "purchase_date": true, "warranty_end": true,
+}
+
+func parseOptionalUint(values url.Values, key string) (*uint, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, errors.New(key + " must be a number")
+	}
+	v := uint(n)
+	return &v, nil
+}
+
+func parseDeviceFilter(values url.Values) (deviceFilter, error) {
+	f := deviceFilter{
+		Query:          strings.TrimSpace(values.Get("q")),
+		DeviceType:     values.Get("device_type"),
+		Brand:          values.Get("brand"),
+		Model:          values.Get("model"),
+		Status:         values.Get("status"),
+		Os:             values.Get("os"),
+		WarrantyBefore: values.Get("warranty_before"),
+		WarrantyAfter:  values.Get("warranty_after"),
+		Sort:           values.Get("sort"),
+		Desc:           values.Get("order") == "desc",
+	}
+	if f.Sort != "" && !deviceSortColumns[f.Sort] {
+		return f, errors.New("cannot sort by " + f.Sort)
+	}
+
+	var err error
+	if f.LocationID, err = parseOptionalUint(values, "location_id"); err != nil {
+		return f, err
+	}
+	if f.AssignedTo, err = parseOptionalUint(values, "assigned_to"); err != nil {
+		return f, err
+	}
+	if raw := values.Get("warranty_within_days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 0 {
+			return f, errors.New("warranty_within_days must be a non-negative number")
+		}
+		f.WarrantyWithin = &days
+	}
+	if f.Fields, err = parseDeviceFields(values.Get("fields")); err != nil {
+		return f, err
+	}
+	if f.Geo, err = parseGeoQuery(values); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// deviceFields are the JSON names of the Device fields, in display order.
+var deviceFields = []string{
+	"id", "device_name", "asset_tag", "serial_number", "device_type", "brand", "model", "os", "os_version", "purchase_date",
+	"warranty_end", "maintenance_due", "status", "price", "assigned_to", "location_id",
+	"latitude", "longitude", "located_at", "legal_hold",
+}
+
+// parseDeviceFields parses a comma-separated list of device fields.
+func parseDeviceFields(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if !containsString(deviceFields, field) {
+			return nil, errors.New("unknown field " + field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// selectDeviceFields returns devices reduced to the given fields, or the
+// devices unchanged when no fields are given.
+func selectDeviceFields(devices []Device, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return devices, nil
+	}
+	rows := make([]map[string]interface{}, 0, len(devices))
+	for _, d := range devices {
+		row, err := selectedFieldValues(d, fields)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// selectedFieldValues returns the given fields of a device keyed by JSON name.
+func selectedFieldValues(d Device, fields []string) (map[string]interface{}, error) {
+	row, err := deviceFieldValues(d)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		selected[field] = row[field]
+	}
+	return selected, nil
+}
+
+// deviceFieldValues returns a device's fields keyed by JSON name.
+func deviceFieldValues(d Device) (map[string]interface{}, error) {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	row := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber() // keep large prices and IDs out of float notation
+	return row, decoder.Decode(&row)
+}
+
+// apply adds the filters that run in SQL. Radius filters without PostGIS
+// are finished in Go by findDevicesWithGeo.
+func (f deviceFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Query != "" {
+		like := "%" + escapeLike(strings.ToLower(f.Query)) + "%"
+		query = query.Where("LOWER(device_name) LIKE ? OR LOWER(brand) LIKE ? OR LOWER(model) LIKE ?", like, like, like)
+	}
+	for column, value := range map[string]string{
+		"device_type": f.DeviceType, "brand": f.Brand, "model": f.Model, "status": f.Status, "os": f.Os,
+	} {
+		if value != "" {
+			query = query.Where("LOWER("+column+") = LOWER(?)", value)
+		}
+	}
+	if f.LocationID != nil {
+		query = query.Where("location_id = ?", *f.LocationID)
+	}
+	if f.AssignedTo != nil {
+		query = query.Where("assigned_to = ?", *f.AssignedTo)
+	}
+	// Dates are stored as YYYY-MM-DD strings, which sort chronologically.
+	if f.WarrantyBefore != "" {
+		query = query.Where("warranty_end <> '' AND warranty_end < ?", f.WarrantyBefore)
+	}
+	if f.WarrantyAfter != "" {
+		query = query.Where("warranty_end >= ?", f.WarrantyAfter)
+	}
+	if f.WarrantyWithin != nil {
+		today := time.Now()
+		query = query.Where("warranty_end >= ? AND warranty_end <= ?",
+			today.Format("2006-01-02"), today.AddDate(0, 0, *f.WarrantyWithin).Format("2006-01-02"))
+	}
+	if f.Sort != "" {
+		order := f.Sort
+		if f.Desc {
+			order += " DESC"
+		}
+		query = query.Order(order)
+	} else {
+		query = query.Order("id")
+	}
+	return query
+}
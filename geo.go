@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DevicePosition is one reported position of a device.
+type DevicePosition struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DeviceID   uint      `gorm:"column:device_id;index" json:"device_id"`
+	Latitude   float64   `gorm:"column:latitude" json:"latitude"`
+	Longitude  float64   `gorm:"column:longitude" json:"longitude"`
+	AccuracyM  float64   `gorm:"column:accuracy_m" json:"accuracy_m"`
+	Source     string    `gorm:"column:source" json:"source"` // "api" or "agent"
+	RecordedAt time.Time `gorm:"column:recorded_at;index" json:"recorded_at"`
+}
+
+const earthRadiusKm = 6371.0
+
+// postgisAvailable is set at startup when the PostGIS extension is installed;
+// otherwise radius queries fall back to filtering in Go.
+var postgisAvailable bool
+
+func detectPostGIS() {
+	var count int64
+	if err := db.Raw("SELECT COUNT(*) FROM pg_extension WHERE extname = 'postgis'").Scan(&count).Error; err != nil {
+		logger.Warnf("Failed to check for PostGIS: %v", err)
+		return
+	}
+	postgisAvailable = count > 0
+	logger.Infof("PostGIS available: %v", postgisAvailable)
+}
+
+// geoQuery holds the optional spatial filters of a device listing:
+// near=lat,lng with radius_km, and/or bbox=minLng,minLat,maxLng,maxLat.
+type geoQuery struct {
+	HasRadius bool
+	Lat, Lng  float64
+	RadiusKm  float64
+
+	HasBBox                        bool
+	MinLng, MinLat, MaxLng, MaxLat float64
+}
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma-separated numbers", n)
+	}
+	values := make([]float64, n)
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func validCoordinates(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+func parseGeoQuery(values url.Values) (geoQuery, error) {
+	var g geoQuery
+
+	if near := values.Get("near"); near != "" {
+		point, err := parseFloats(near, 2)
+		if err != nil || !validCoordinates(point[0], point[1]) {
+			return g, errors.New("near must be lat,lng")
+		}
+		rawRadius := values.Get("radius_km")
+		if rawRadius == "" {
+			rawRadius = "10"
+		}
+		radius, err := strconv.ParseFloat(rawRadius, 64)
+		if err != nil || radius <= 0 {
+			return g, errors.New("radius_km must be a positive number")
+		}
+		g.HasRadius, g.Lat, g.Lng, g.RadiusKm = true, point[0], point[1], radius
+	}
+
+	if bbox := values.Get("bbox"); bbox != "" {
+		box, err := parseFloats(bbox, 4)
+		if err != nil || !validCoordinates(box[1], box[0]) || !validCoordinates(box[3], box[2]) ||
+			box[1] > box[3] || box[0] > box[2] {
+			return g, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		g.HasBBox = true
+		g.MinLng, g.MinLat, g.MaxLng, g.MaxLat = box[0], box[1], box[2], box[3]
+	}
+	return g, nil
+}
+
+// radiusBounds returns a box that contains the circle, used to narrow the
+// SQL query before the exact distance check.
+func radiusBounds(lat, lng, radiusKm float64) (minLat, minLng, maxLat, maxLng float64) {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+	minLat, maxLat = math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+	cosLat := math.Cos(lat * math.Pi / 180)
+	if cosLat < 1e-9 || maxLat == 90 || minLat == -90 {
+		return minLat, -180, maxLat, 180
+	}
+	dLng := dLat / cosLat
+	if dLng >= 180 {
+		return minLat, -180, maxLat, 180
+	}
+	return minLat, lng - dLng, maxLat, lng + dLng
+}
+
+// haversineKm is the great-circle distance between two points.
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// apply adds the spatial filters that can run in SQL.
+func (g geoQuery) apply(query *gorm.DB) *gorm.DB {
+	if g.HasBBox {
+		query = query.Where("latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?", g.MinLat, g.MaxLat, g.MinLng, g.MaxLng)
+	}
+	if g.HasRadius {
+		if postgisAvailable {
+			query = query.Where("ST_DWithin(geography(ST_MakePoint(longitude, latitude)), geography(ST_MakePoint(?, ?)), ?)",
+				g.Lng, g.Lat, g.RadiusKm*1000)
+		} else {
+			minLat, minLng, maxLat, maxLng := radiusBounds(g.Lat, g.Lng, g.RadiusKm)
+			query = query.Where("latitude BETWEEN ? AND ?", minLat, maxLat)
+			if minLng > -180 && maxLng < 180 {
+				query = query.Where("longitude BETWEEN ? AND ?", minLng, maxLng)
+			}
+		}
+	}
+	return query
+}
+
+// needsGoFilter reports whether results must still be checked in Go, which
+// also means pagination has to happen after filtering.
+func (g geoQuery) needsGoFilter() bool {
+	return g.HasRadius && !postgisAvailable
+}
+
+func (g geoQuery) filter(devices []Device) []Device {
+	kept := devices[:0]
+	for _, d := range devices {
+		if g.matches(d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// matches reports whether a device is within the search radius.
+func (g geoQuery) matches(d Device) bool {
+	if d.Latitude == nil || d.Longitude == nil {
+		return false
+	}
+	return haversineKm(g.Lat, g.Lng, *d.Latitude, *d.Longitude) <= g.RadiusKm
+}
+
+// findDevicesWithGeo runs a device query with spatial filters and pagination.
+func findDevicesWithGeo(query *gorm.DB, g geoQuery, limit, offset int) ([]Device, error) {
+	query = g.apply(query)
+
+	var devices []Device
+	if !g.needsGoFilter() {
+		err := query.Limit(limit).Offset(offset).Find(&devices).Error
+		return devices, err
+	}
+
+	if err := query.Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	return pageOf(g.filter(devices), limit, offset), nil
+}
+
+// recordDevicePosition stores a new position and makes it the device's current one.
+func recordDevicePosition(tx *gorm.DB, position *DevicePosition) error {
+	if position.RecordedAt.IsZero() {
+		position.RecordedAt = time.Now()
+	}
+
+	var device Device
+	if err := tx.Select("id", "located_at").First(&device, position.DeviceID).Error; err != nil {
+		return err
+	}
+	if err := tx.Create(position).Error; err != nil {
+		return err
+	}
+	// A late report from the past goes into history but does not move the device.
+	if device.LocatedAt != nil && device.LocatedAt.After(position.RecordedAt) {
+		return nil
+	}
+	return tx.Model(&Device{}).Where("id = ?", position.DeviceID).Updates(map[string]interface{}{
+		"latitude":   position.Latitude,
+		"longitude":  position.Longitude,
+		"located_at": position.RecordedAt,
+	}).Error
+}
+
+type positionInput struct {
+	Latitude   *float64   `json:"latitude" binding:"required"`
+	Longitude  *float64   `json:"longitude" binding:"required"`
+	AccuracyM  float64    `json:"accuracy_m"`
+	RecordedAt *time.Time `json:"recorded_at"`
+}
+
+func savePosition(c *gin.Context, deviceID uint, input positionInput, source string) {
+	position := DevicePosition{
+		DeviceID:  deviceID,
+		Latitude:  *input.Latitude,
+		Longitude: *input.Longitude,
+		AccuracyM: input.AccuracyM,
+		Source:    source,
+	}
+	if input.RecordedAt != nil {
+		position.RecordedAt = *input.RecordedAt
+	}
+	if !validCoordinates(position.Latitude, position.Longitude) {
+		respondWithError(c, http.StatusBadRequest, "latitude must be within ±90 and longitude within ±180")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return recordDevicePosition(tx, &position)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Warnf("Device not found for ID: %d", deviceID)
+			respondWithError(c, http.StatusNotFound, "Device not found")
+			return
+		}
+		logger.Errorf("Failed to record device position: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to record device position")
+		return
+	}
+
+	logger.Infof("Device %d located at %f,%f via %s", deviceID, position.Latitude, position.Longitude, source)
+	c.JSON(http.StatusCreated, position)
+}
+
+func updateDevicePosition(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var input positionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	savePosition(c, uint(idInt), input, "api")
+}
+
+// agentCheckIn is called periodically by the device agent.
+func agentCheckIn(c *gin.Context) {
+	var input struct {
+		DeviceID uint `json:"device_id" binding:"required"`
+		positionInput
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	savePosition(c, input.DeviceID, input.positionInput, "agent")
+}
+
+func listDevicePositions(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	var positions []DevicePosition
+	if err := db.Where("device_id = ?", idInt).Order("recorded_at DESC").Limit(limit).Find(&positions).Error; err != nil {
+		logger.Errorf("Failed to retrieve device positions: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve device positions")
+		return
+	}
+	c.JSON(http.StatusOK, positions)
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         uint                   `json:"id"`
+	Geometry   map[string]interface{} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// exportDevicesGeoJSON returns located devices as a GeoJSON FeatureCollection
+// (RFC 7946), honouring the same filters as listDevices.
+func exportDevicesGeoJSON(c *gin.Context) {
+	filter, err := parseDeviceFilter(c.Request.URL.Query())
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := filter.apply(db.Where("latitude IS NOT NULL AND longitude IS NOT NULL"))
+	devices, err := findDevicesWithGeo(query, filter.Geo, -1, 0)
+	if err != nil {
+		logger.Errorf("Failed to export devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to export devices")
+		return
+	}
+
+	features := make([]geoJSONFeature, 0, len(devices))
+	for _, d := range devices {
+		features = append(features, geoJSONFeature{
+			Type: "Feature",
+			ID:   d.ID,
+			Geometry: map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []float64{*d.Longitude, *d.Latitude},
+			},
+			Properties: map[string]interface{}{
+				"device_name": d.DeviceName,
+				"device_type": d.DeviceType,
+				"status":      d.Status,
+				"located_at":  d.LocatedAt,
+			},
+		})
+	}
+
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, gin.H{"type": "FeatureCollection", "features": features})
+}
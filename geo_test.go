@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test great-circle distance between two known points
+func TestHaversineKm(t *testing.T) {
+	// Paris to London is about 344 km
+	assert.InDelta(t, 344, haversineKm(48.8566, 2.3522, 51.5074, -0.1278), 2)
+	assert.Zero(t, haversineKm(10, 10, 10, 10))
+}
+
+// Test that the radius bounding box contains the circle
+func TestRadiusBounds(t *testing.T) {
+	minLat, minLng, maxLat, maxLng := radiusBounds(52.52, 13.405, 10)
+
+	assert.InDelta(t, 10, haversineKm(52.52, 13.405, maxLat, 13.405), 0.01)
+	assert.Less(t, minLat, 52.52)
+	assert.LessOrEqual(t, haversineKm(52.52, 13.405, 52.52, maxLng), 10.5)
+	assert.Less(t, minLng, 13.405)
+
+	// near a pole the box covers every longitude
+	_, minLng, _, maxLng = radiusBounds(89.99, 0, 50)
+	assert.Equal(t, -180.0, minLng)
+	assert.Equal(t, 180.0, maxLng)
+}
+
+// Test the pure-Go radius filter
+func TestGeoQueryFilter(t *testing.T) {
+	lat1, lng1 := 52.52, 13.405  // Berlin
+	lat2, lng2 := 48.137, 11.575 // Munich
+	devices := []Device{
+		{ID: 1, Latitude: &lat1, Longitude: &lng1},
+		{ID: 2, Latitude: &lat2, Longitude: &lng2},
+		{ID: 3},
+	}
+
+	g := geoQuery{HasRadius: true, Lat: 52.5, Lng: 13.4, RadiusKm: 25}
+	kept := g.filter(devices)
+
+	assert.Len(t, kept, 1)
+	assert.Equal(t, uint(1), kept[0].ID)
+}
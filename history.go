@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceStatusChange records one change of a device's Status.
+type DeviceStatusChange struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DeviceID   uint      `gorm:"column:device_id;index" json:"device_id"`
+	FromStatus string    `gorm:"column:from_status" json:"from_status"`
+	ToStatus   string    `gorm:"column:to_status;index" json:"to_status"`
+	ChangedAt  time.Time `gorm:"column:changed_at;index" json:"changed_at"`
+}
+
+// recordInitialStatuses records the status new devices were created with.
+func recordInitialStatuses(tx *gorm.DB, devices []Device) error {
+	now := time.Now()
+	var changes []DeviceStatusChange
+	for _, d := range devices {
+		if d.ID != 0 && d.Status != "" {
+			changes = append(changes, DeviceStatusChange{DeviceID: d.ID, ToStatus: d.Status, ChangedAt: now})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(&changes, chunkSize).Error
+}
+
+// updateDeviceFields updates a device and, if "status" is among the fields
+// and differs from the stored one, records the change.
+func updateDeviceFields(tx *gorm.DB, deviceID uint, fields map[string]interface{}) error {
+	newStatus, changesStatus := fields["status"].(string)
+
+	var current Device
+	if changesStatus {
+		if err := tx.Select("id", "device_name", "status").First(&current, deviceID).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&Device{}).Where("id = ?", deviceID).Updates(fields).Error; err != nil {
+		return err
+	}
+	if !changesStatus || current.Status == newStatus {
+		return nil
+	}
+	return recordStatusChange(tx, current, newStatus)
+}
+
+// recordStatusChange records that device, as it was before, changed to
+// newStatus and notifies anyone watching it.
+func recordStatusChange(tx *gorm.DB, device Device, newStatus string) error {
+	change := DeviceStatusChange{
+		DeviceID:   device.ID,
+		FromStatus: device.Status,
+		ToStatus:   newStatus,
+		ChangedAt:  time.Now(),
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return err
+	}
+	return notify(tx, notificationEvent{
+		Type:     eventDeviceStatusChanged,
+		Subject:  fmt.Sprintf("%s is now %s", device.DeviceName, newStatus),
+		Body:     fmt.Sprintf("Status changed from %s to %s.", device.Status, newStatus),
+		DeviceID: &change.DeviceID,
+		Key:      "status-change-" + strconv.Itoa(int(change.ID)),
+	})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CalendarFeed is a subscribable iCalendar (RFC 5545) feed. Calendar apps
+// cannot send auth headers, so the feed URL carries a secret token; only its
+// SHA-256 hash is stored.
+type CalendarFeed struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Name       string    `gorm:"column:name" json:"name"`
+	TokenHash  string    `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	Kinds      string    `gorm:"column:kinds" json:"kinds"` // comma-separated: warranty,reservations,maintenance
+	LocationID *uint     `gorm:"column:location_id" json:"location_id"`
+	DeviceType string    `gorm:"column:device_type" json:"device_type"`
+	AssigneeID *uint     `gorm:"column:assignee_id" json:"assignee_id"`
+	CreatedBy  uint      `gorm:"column:created_by;index" json:"created_by"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+const (
+	feedWarranty     = "warranty"
+	feedReservations = "reservations"
+	feedMaintenance  = "maintenance"
+
+	icalDate = "20060102"
+)
+
+// icalEvent is an all-day calendar event. UIDs are derived from the record
+// they describe so clients update events in place instead of duplicating them.
+type icalEvent struct {
+	UID         string
+	Date        time.Time
+	Summary     string
+	Description string
+	Category    string
+}
+
+// writeICalendar writes events as a VCALENDAR with CRLF line endings and
+// lines folded at 75 octets.
+func writeICalendar(w io.Writer, name string, events []icalEvent, now time.Time) error {
+	var buf bytes.Buffer
+	line := func(s string) {
+		buf.WriteString(foldICalLine(s))
+		buf.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Devices//Device Calendar//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + escapeICalText(name))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + e.UID)
+		line("DTSTAMP:" + stamp)
+		line("DTSTART;VALUE=DATE:" + e.Date.Format(icalDate))
+		line("DTEND;VALUE=DATE:" + e.Date.AddDate(0, 0, 1).Format(icalDate))
+		line("SUMMARY:" + escapeICalText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION:" + escapeICalText(e.Description))
+		}
+		if e.Category != "" {
+			line("CATEGORIES:" + escapeICalText(e.Category))
+		}
+		line("TRANSP:TRANSPARENT")
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldICalLine splits a content line into 75-octet chunks without breaking
+// UTF-8 sequences; continuation lines start with a space.
+func foldICalLine(s string) string {
+	if len(s) <= 75 {
+		return s
+	}
+	var b strings.Builder
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74 // the leading space counts towards the next line
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (f CalendarFeed) hasKind(kind string) bool {
+	for _, k := range strings.Split(f.Kinds, ",") {
+		if strings.TrimSpace(k) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func deviceLabel(d Device) string {
+	label := d.DeviceName
+	if model := strings.TrimSpace(d.Brand + " " + d.Model); model != "" {
+		label += " (" + model + ")"
+	}
+	return label
+}
+
+// scopeFeedDevices applies a feed's location, type and assignee filters.
+func scopeFeedDevices(query *gorm.DB, f CalendarFeed) *gorm.DB {
+	if f.LocationID != nil {
+		query = query.Where("devices.location_id = ?", *f.LocationID)
+	}
+	if f.DeviceType != "" {
+		query = query.Where("LOWER(devices.device_type) = LOWER(?)", f.DeviceType)
+	}
+	return query
+}
+
+// feedEvents collects the events a feed should show between from and until.
+func feedEvents(tx *gorm.DB, f CalendarFeed, from, until time.Time) ([]icalEvent, error) {
+	var events []icalEvent
+
+	if f.hasKind(feedWarranty) || f.hasKind(feedMaintenance) {
+		query := scopeFeedDevices(tx.Model(&Device{}), f)
+		if f.AssigneeID != nil {
+			query = query.Where("devices.assigned_to = ?", *f.AssigneeID)
+		}
+		var devices []Device
+		if err := query.Where("devices.warranty_end <> '' OR devices.maintenance_due <> ''").Find(&devices).Error; err != nil {
+			return nil, err
+		}
+		for _, d := range devices {
+			if f.hasKind(feedWarranty) {
+				if date, err := time.Parse("2006-01-02", d.WarrantyEnd); err == nil && !date.Before(from) && date.Before(until) {
+					events = append(events, icalEvent{
+						UID:         fmt.Sprintf("warranty-%d@devices", d.ID),
+						Date:        date,
+						Summary:     "Warranty ends: " + deviceLabel(d),
+						Description: fmt.Sprintf("Device %d, status %s", d.ID, d.Status),
+						Category:    "Warranty",
+					})
+				}
+			}
+			if f.hasKind(feedMaintenance) {
+				if date, err := time.Parse("2006-01-02", d.MaintenanceDue); err == nil && !date.Before(from) && date.Before(until) {
+					events = append(events, icalEvent{
+						UID:         fmt.Sprintf("maintenance-%d@devices", d.ID),
+						Date:        date,
+						Summary:     "Maintenance due: " + deviceLabel(d),
+						Description: fmt.Sprintf("Device %d, status %s", d.ID, d.Status),
+						Category:    "Maintenance",
+					})
+				}
+			}
+		}
+	}
+
+	if f.hasKind(feedReservations) {
+		type reservationRow struct {
+			Reservation
+			DeviceName string
+			Brand      string
+			Model      string
+			FirstName  string
+			LastName   string
+		}
+		query := scopeFeedDevices(tx.Table("reservations"), f).
+			Select("reservations.*, devices.device_name, devices.brand, devices.model, employees.first_name, employees.last_name").
+			Joins("JOIN devices ON devices.id = reservations.device_id").
+			Joins("LEFT JOIN employees ON employees.id = reservations.employee_id").
+			// Active reservations that started earlier are still current.
+			Where("reservations.status = ? AND reservations.start_date < ?", reservationActive, until)
+		if f.AssigneeID != nil {
+			query = query.Where("reservations.employee_id = ?", *f.AssigneeID)
+		}
+		var rows []reservationRow
+		if err := query.Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			device := Device{DeviceName: r.DeviceName, Brand: r.Brand, Model: r.Model}
+			events = append(events, icalEvent{
+				UID:         fmt.Sprintf("reservation-%d@devices", r.ID),
+				Date:        r.StartDate,
+				Summary:     fmt.Sprintf("Reserved: %s for %s", deviceLabel(device), strings.TrimSpace(r.FirstName+" "+r.LastName)),
+				Description: fmt.Sprintf("Reservation %d, device %d", r.ID, r.DeviceID),
+				Category:    "Reservation",
+			})
+		}
+	}
+	return events, nil
+}
+
+// createCalendarFeed creates a feed owned by the caller. Only admins may
+// create feeds of someone else's assignments.
+func createCalendarFeed(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name       string   `json:"name" binding:"required"`
+		Kinds      []string `json:"kinds"`
+		LocationID *uint    `json:"location_id"`
+		DeviceType string   `json:"device_type"`
+		AssigneeID *uint    `json:"assignee_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.AssigneeID != nil && *input.AssigneeID != caller.ID && caller.Role != roleAdmin {
+		respondWithError(c, http.StatusForbidden, "Only admins can create feeds for other assignees")
+		return
+	}
+	if len(input.Kinds) == 0 {
+		input.Kinds = []string{feedWarranty, feedReservations, feedMaintenance}
+	}
+	for _, kind := range input.Kinds {
+		if kind != feedWarranty && kind != feedReservations && kind != feedMaintenance {
+			respondWithError(c, http.StatusBadRequest, "kinds must be warranty, reservations or maintenance")
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Errorf("Failed to generate feed token: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create calendar feed")
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	feed := CalendarFeed{
+		Name:       input.Name,
+		TokenHash:  hashFeedToken(token),
+		Kinds:      strings.Join(input.Kinds, ","),
+		LocationID: input.LocationID,
+		DeviceType: input.DeviceType,
+		AssigneeID: input.AssigneeID,
+		CreatedBy:  caller.ID,
+	}
+	if err := db.Create(&feed).Error; err != nil {
+		logger.Errorf("Failed to create calendar feed: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create calendar feed")
+		return
+	}
+
+	logger.Infof("Calendar feed created: %s (%d)", feed.Name, feed.ID)
+	// The token is only shown once.
+	c.JSON(http.StatusCreated, gin.H{"feed": feed, "url": "/ical/" + token + ".ics"})
+}
+
+// listCalendarFeeds lists the caller's feeds, or every feed for an admin.
+func listCalendarFeeds(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	query := db.Order("id")
+	if caller.Role != roleAdmin {
+		query = query.Where("created_by = ?", caller.ID)
+	}
+	var feeds []CalendarFeed
+	if err := query.Find(&feeds).Error; err != nil {
+		logger.Errorf("Failed to retrieve calendar feeds: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve calendar feeds")
+		return
+	}
+	c.JSON(http.StatusOK, feeds)
+}
+
+// deleteCalendarFeed revokes a feed the caller created, or any feed for an
+// admin.
+func deleteCalendarFeed(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var feed CalendarFeed
+	if err := db.First(&feed, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Calendar feed not found")
+		} else {
+			logger.Errorf("Failed to retrieve calendar feed: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to delete calendar feed")
+		}
+		return
+	}
+	if feed.CreatedBy != caller.ID && caller.Role != roleAdmin {
+		respondWithError(c, http.StatusForbidden, "Only the creator can revoke a calendar feed")
+		return
+	}
+	if err := db.Delete(&feed).Error; err != nil {
+		logger.Errorf("Failed to delete calendar feed: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete calendar feed")
+		return
+	}
+
+	logger.Infof("Calendar feed revoked: %d", idInt)
+	c.JSON(http.StatusOK, gin.H{"message": "Calendar feed revoked successfully"})
+}
+
+// serveCalendarFeed renders /ical/<token>.ics.
+func serveCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+
+	var feed CalendarFeed
+	if err := db.Where("token_hash = ?", hashFeedToken(token)).First(&feed).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Calendar feed not found")
+		} else {
+			logger.Errorf("Failed to retrieve calendar feed: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve calendar feed")
+		}
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	events, err := feedEvents(db, feed, today, today.AddDate(0, 0, config().Calendar.HorizonDays))
+	if err != nil {
+		logger.Errorf("Failed to build calendar feed: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build calendar feed")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeICalendar(&buf, feed.Name, events, now); err != nil {
+		logger.Errorf("Failed to render calendar feed: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to render calendar feed")
+		return
+	}
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test escaping of iCalendar text values
+func TestEscapeICalText(t *testing.T) {
+	assert.Equal(t, `a\, b\; c\\d\ne`, escapeICalText("a, b; c\\d\ne"))
+}
+
+// Test folding long lines at 75 octets without splitting runes
+func TestFoldICalLine(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 60)
+	folded := foldICalLine(line)
+
+	for _, part := range strings.Split(folded, "\r\n") {
+		assert.LessOrEqual(t, len(part), 75)
+	}
+	assert.Equal(t, line, strings.ReplaceAll(folded, "\r\n ", ""))
+}
+
+// Test rendering a calendar with a stable event UID
+func TestWriteICalendar(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	events := []icalEvent{{
+		UID:     "warranty-7@devices",
+		Date:    time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC),
+		Summary: "Warranty ends: Laptop 7",
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeICalendar(&buf, "Warranties", events, now))
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.Contains(t, out, "UID:warranty-7@devices\r\n")
+	assert.Contains(t, out, "DTSTART;VALUE=DATE:20240630\r\n")
+	assert.Contains(t, out, "DTEND;VALUE=DATE:20240701\r\n")
+	assert.Contains(t, out, "DTSTAMP:20240501T120000Z\r\n")
+	assert.True(t, strings.HasSuffix(out, "END:VCALENDAR\r\n"))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// callerHeader carries the ID of the employee making the request. It is set
+// by the authenticating proxy in front of the service.
+const callerHeader = "X-Employee-ID"
+
+// roleAdmin is the employee role allowed to use administrative endpoints.
+const roleAdmin = "admin"
+
+// currentEmployee returns the active employee making the request. It responds
+// with 401 and returns false when there is none.
+func currentEmployee(c *gin.Context) (Employee, bool) {
+	var employee Employee
+	id, err := strconv.ParseUint(c.GetHeader(callerHeader), 10, 64)
+	if err != nil {
+		respondWithError(c, http.StatusUnauthorized, callerHeader+" header is required")
+		return employee, false
+	}
+
+	if err := db.First(&employee, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusUnauthorized, "Unknown employee")
+		} else {
+			logger.Errorf("Failed to retrieve employee: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to identify caller")
+		}
+		return employee, false
+	}
+	if !employee.Active {
+		respondWithError(c, http.StatusUnauthorized, "Employee is not active")
+		return employee, false
+	}
+	return employee, true
+}
+
+// requireAdmin returns the calling employee if they are an admin. It
+// responds with 401 or 403 and returns false otherwise.
+func requireAdmin(c *gin.Context) (Employee, bool) {
+	employee, ok := currentEmployee(c)
+	if !ok {
+		return employee, false
+	}
+	if employee.Role != roleAdmin {
+		respondWithError(c, http.StatusForbidden, "Admin role required")
+		return employee, false
+	}
+	return employee, true
+}
+
+// groupIDsOf returns the IDs of the groups an employee is a member of.
+func groupIDsOf(tx *gorm.DB, employeeID uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Table("group_members").Where("employee_id = ?", employeeID).Pluck("group_id", &ids).Error
+	return ids, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxImportErrors caps how many row errors an import keeps for its report,
+// and likewise how many transformed rows.
+const maxImportErrors = 100
+
+// importJobRetention is how long finished imports stay queryable.
+const importJobRetention = time.Hour
+
+// importRowError is a problem with one line, or a range of lines when a
+// whole batch failed to insert.
+type importRowError struct {
+	Line    int    `json:"line"`
+	EndLine int    `json:"end_line,omitempty"`
+	Message string `json:"message"`
+}
+
+// importRowChange is why the import transforms changed one line.
+type importRowChange struct {
+	Line    int      `json:"line"`
+	Reasons []string `json:"reasons"`
+}
+
+// importJob tracks the progress of one CSV import.
+type importJob struct {
+	mu          sync.Mutex
+	id          string
+	fileName    string
+	totalBytes  int64
+	bytesRead   int64
+	rows        int
+	inserted    int
+	skipped     int
+	errors      []importRowError
+	moreErrors  int
+	changes     []importRowChange
+	moreChanges int
+	done        bool
+	startedAt   time.Time
+	finishedAt  *time.Time
+}
+
+// importProgress is a point-in-time copy of an importJob.
+type importProgress struct {
+	ID             string            `json:"id"`
+	FileName       string            `json:"file_name"`
+	TotalBytes     int64             `json:"total_bytes"`
+	BytesRead      int64             `json:"bytes_read"`
+	Rows           int               `json:"rows"`
+	Inserted       int               `json:"inserted"`
+	Skipped        int               `json:"skipped"`
+	Errors         []importRowError  `json:"errors"`
+	OmittedErrors  int               `json:"omitted_errors"`
+	Changes        []importRowChange `json:"changes"`
+	OmittedChanges int               `json:"omitted_changes"`
+	Done           bool              `json:"done"`
+	StartedAt      time.Time         `json:"started_at"`
+	FinishedAt     *time.Time        `json:"finished_at"`
+}
+
+func newImportJob(fileName string, size int64) *importJob {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// Fall back to a time-based ID; collisions only affect progress lookups.
+		return &importJob{id: fmt.Sprintf("%x", time.Now().UnixNano()), fileName: fileName, totalBytes: size, startedAt: time.Now()}
+	}
+	return &importJob{id: hex.EncodeToString(buf), fileName: fileName, totalBytes: size, startedAt: time.Now()}
+}
+
+func (j *importJob) addBytes(n int) {
+	j.mu.Lock()
+	j.bytesRead += int64(n)
+	j.mu.Unlock()
+}
+
+func (j *importJob) rowRead() {
+	j.mu.Lock()
+	j.rows++
+	j.mu.Unlock()
+}
+
+func (j *importJob) rowSkipped(line int, message string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.skipped++
+	j.addError(importRowError{Line: line, Message: message})
+}
+
+// rowChanged records the reasons the transforms gave for changing a line.
+func (j *importJob) rowChanged(line int, reasons []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.changes) < maxImportErrors {
+		j.changes = append(j.changes, importRowChange{Line: line, Reasons: reasons})
+	} else {
+		j.moreChanges++
+	}
+}
+
+func (j *importJob) batchInserted(n int) {
+	j.mu.Lock()
+	j.inserted += n
+	j.mu.Unlock()
+}
+
+func (j *importJob) batchFailed(firstLine, lastLine, count int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.skipped += count
+	j.addError(importRowError{Line: firstLine, EndLine: lastLine, Message: err.Error()})
+}
+
+// addError must be called with j.mu held.
+func (j *importJob) addError(e importRowError) {
+	if len(j.errors) < maxImportErrors {
+		j.errors = append(j.errors, e)
+	} else {
+		j.moreErrors++
+	}
+}
+
+func (j *importJob) finish() {
+	j.mu.Lock()
+	now := time.Now()
+	j.done = true
+	j.finishedAt = &now
+	j.mu.Unlock()
+}
+
+func (j *importJob) progress() importProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return importProgress{
+		ID:             j.id,
+		FileName:       j.fileName,
+		TotalBytes:     j.totalBytes,
+		BytesRead:      j.bytesRead,
+		Rows:           j.rows,
+		Inserted:       j.inserted,
+		Skipped:        j.skipped,
+		Errors:         append([]importRowError{}, j.errors...),
+		OmittedErrors:  j.moreErrors,
+		Changes:        append([]importRowChange{}, j.changes...),
+		OmittedChanges: j.moreChanges,
+		Done:           j.done,
+		StartedAt:      j.startedAt,
+		FinishedAt:     j.finishedAt,
+	}
+}
+
+// countingReader reports bytes read to an import job.
+type countingReader struct {
+	r   io.Reader
+	job *importJob
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.job.addBytes(n)
+	return n, err
+}
+
+var (
+	importJobsMu sync.Mutex
+	importJobs   = map[string]*importJob{}
+)
+
+// trackImportJob makes a background import visible to findImportJob and
+// drops imports that finished more than importJobRetention ago.
+func trackImportJob(job *importJob) {
+	importJobsMu.Lock()
+	defer importJobsMu.Unlock()
+	cutoff := time.Now().Add(-importJobRetention)
+	for id, j := range importJobs {
+		if p := j.progress(); p.Done && p.FinishedAt.Before(cutoff) {
+			delete(importJobs, id)
+		}
+	}
+	importJobs[job.id] = job
+}
+
+func findImportJob(id string) (*importJob, bool) {
+	importJobsMu.Lock()
+	defer importJobsMu.Unlock()
+	job, ok := importJobs[id]
+	return job, ok
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// startJob runs fn every interval in the background until the process exits.
+// A non-positive interval disables the job.
+func startJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	if interval <= 0 {
+		logger.Infof("Job %s disabled", name)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := fn(ctx); err != nil {
+				logger.Errorf("Job %s failed: %v", name, err)
+			}
+			cancel()
+		}
+	}()
+
+	logger.Infof("Job %s scheduled every %s", name, interval)
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// KitTemplate is the standard set of devices for a role, e.g.
+// "Engineer: laptop + monitor + phone".
+type KitTemplate struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;uniqueIndex" json:"name"`
+	Role      string    `gorm:"column:role;index" json:"role"`
+	Items     []KitItem `json:"items"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// KitItem asks for Quantity in-stock devices of DeviceType, optionally
+// restricted to a Brand and Model.
+type KitItem struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	KitTemplateID uint   `gorm:"column:kit_template_id;index" json:"kit_template_id"`
+	DeviceType    string `gorm:"column:device_type" json:"device_type"`
+	Brand         string `gorm:"column:brand" json:"brand"`
+	Model         string `gorm:"column:model" json:"model"`
+	Quantity      int    `gorm:"column:quantity" json:"quantity"`
+}
+
+// Reservation holds a device for an employee until a start date.
+type Reservation struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	DeviceID       uint      `gorm:"column:device_id;index" json:"device_id"`
+	EmployeeID     uint      `gorm:"column:employee_id;index" json:"employee_id"`
+	ProvisioningID *uint     `gorm:"column:provisioning_id;index" json:"provisioning_id"`
+	KitItemID      *uint     `gorm:"column:kit_item_id" json:"kit_item_id"`
+	StartDate      time.Time `gorm:"column:start_date" json:"start_date"`
+	Status         string    `gorm:"column:status;index" json:"status"`
+	CreatedAt      time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// Provisioning is a kit being prepared for one new employee.
+type Provisioning struct {
+	ID            uint          `gorm:"primaryKey" json:"id"`
+	KitTemplateID uint          `gorm:"column:kit_template_id" json:"kit_template_id"`
+	EmployeeID    uint          `gorm:"column:employee_id;index" json:"employee_id"`
+	StartDate     time.Time     `gorm:"column:start_date" json:"start_date"`
+	Status        string        `gorm:"column:status;index" json:"status"`
+	CheckedOutAt  *time.Time    `gorm:"column:checked_out_at" json:"checked_out_at"`
+	Reservations  []Reservation `json:"reservations"`
+	Shortages     []KitShortage `gorm:"-" json:"shortages"`
+	CreatedAt     time.Time     `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt     time.Time     `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// KitShortage reports how many devices of a kit item could not be reserved.
+type KitShortage struct {
+	KitItemID  uint   `json:"kit_item_id"`
+	DeviceType string `json:"device_type"`
+	Brand      string `json:"brand,omitempty"`
+	Model      string `json:"model,omitempty"`
+	Requested  int    `json:"requested"`
+	Reserved   int    `json:"reserved"`
+	Missing    int    `json:"missing"`
+}
+
+const (
+	reservationActive    = "active"
+	reservationFulfilled = "fulfilled"
+	reservationCancelled = "cancelled"
+
+	provisioningReserved   = "reserved"
+	provisioningShort      = "short" // some kit items could not be reserved
+	provisioningCheckedOut = "checked_out"
+	provisioningCancelled  = "cancelled"
+)
+
+// kitShortages compares what a kit asks for with what is reserved per item.
+func kitShortages(items []KitItem, reserved map[uint]int) []KitShortage {
+	shortages := []KitShortage{}
+	for _, item := range items {
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		if got := reserved[item.ID]; got < quantity {
+			shortages = append(shortages, KitShortage{
+				KitItemID:  item.ID,
+				DeviceType: item.DeviceType,
+				Brand:      item.Brand,
+				Model:      item.Model,
+				Requested:  quantity,
+				Reserved:   got,
+				Missing:    quantity - got,
+			})
+		}
+	}
+	return shortages
+}
+
+func reservedPerItem(reservations []Reservation) map[uint]int {
+	counts := make(map[uint]int)
+	for _, r := range reservations {
+		if r.KitItemID != nil && r.Status != reservationCancelled {
+			counts[*r.KitItemID]++
+		}
+	}
+	return counts
+}
+
+// reserveKitItems reserves in-stock devices for whatever the provisioning is
+// still missing. Matching rows are locked and skipped if another request has
+// them, so two provisionings never get the same device.
+func reserveKitItems(tx *gorm.DB, p *Provisioning, items []KitItem) error {
+	for _, shortage := range kitShortages(items, reservedPerItem(p.Reservations)) {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("LOWER(device_type) = LOWER(?) AND status = ? AND assigned_to IS NULL", shortage.DeviceType, statusInStock)
+		if shortage.Brand != "" {
+			query = query.Where("LOWER(brand) = LOWER(?)", shortage.Brand)
+		}
+		if shortage.Model != "" {
+			query = query.Where("LOWER(model) = LOWER(?)", shortage.Model)
+		}
+
+		var devices []Device
+		if err := query.Order("id").Limit(shortage.Missing).Find(&devices).Error; err != nil {
+			return err
+		}
+		for _, device := range devices {
+			itemID := shortage.KitItemID
+			reservation := Reservation{
+				DeviceID:       device.ID,
+				EmployeeID:     p.EmployeeID,
+				ProvisioningID: &p.ID,
+				KitItemID:      &itemID,
+				StartDate:      p.StartDate,
+				Status:         reservationActive,
+			}
+			if err := tx.Create(&reservation).Error; err != nil {
+				return err
+			}
+			if err := updateDeviceFields(tx, device.ID, map[string]interface{}{"status": statusReserved}); err != nil {
+				return err
+			}
+			p.Reservations = append(p.Reservations, reservation)
+		}
+	}
+
+	p.Shortages = kitShortages(items, reservedPerItem(p.Reservations))
+	p.Status = provisioningReserved
+	if len(p.Shortages) > 0 {
+		p.Status = provisioningShort
+	}
+	return tx.Model(p).Update("status", p.Status).Error
+}
+
+// checkoutProvisioning assigns every reserved device to the employee at once.
+// It fails if any of them is no longer reserved, e.g. because it was
+// assigned to someone else or deleted meanwhile.
+func checkoutProvisioning(tx *gorm.DB, p *Provisioning) error {
+	now := time.Now()
+	for i := range p.Reservations {
+		r := &p.Reservations[i]
+		if r.Status != reservationActive {
+			continue
+		}
+		var device Device
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status").First(&device, r.DeviceID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && device.Status != statusReserved) {
+			return provisioningConflict(fmt.Sprintf("Device %d is no longer reserved", r.DeviceID))
+		}
+		if err != nil {
+			return err
+		}
+		if err := updateDeviceFields(tx, r.DeviceID, map[string]interface{}{"assigned_to": p.EmployeeID, "status": statusAssigned}); err != nil {
+			return err
+		}
+		r.Status = reservationFulfilled
+		if err := tx.Model(r).Update("status", r.Status).Error; err != nil {
+			return err
+		}
+	}
+	p.Status = provisioningCheckedOut
+	p.CheckedOutAt = &now
+	return tx.Model(p).Updates(map[string]interface{}{"status": p.Status, "checked_out_at": now}).Error
+}
+
+// releaseReservations puts reserved devices back in stock.
+func releaseReservations(tx *gorm.DB, p *Provisioning) error {
+	for i := range p.Reservations {
+		r := &p.Reservations[i]
+		if r.Status != reservationActive {
+			continue
+		}
+		var device Device
+		if err := tx.Select("id", "status").First(&device, r.DeviceID).Error; err != nil {
+			return err
+		}
+		if device.Status == statusReserved {
+			if err := updateDeviceFields(tx, r.DeviceID, map[string]interface{}{"status": statusInStock}); err != nil {
+				return err
+			}
+		}
+		r.Status = reservationCancelled
+		if err := tx.Model(r).Update("status", r.Status).Error; err != nil {
+			return err
+		}
+	}
+	p.Status = provisioningCancelled
+	return tx.Model(p).Update("status", p.Status).Error
+}
+
+// loadProvisioning locks a provisioning for the rest of tx and loads it with
+// its reservations and kit items.
+func loadProvisioning(tx *gorm.DB, id uint) (*Provisioning, []KitItem, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&Provisioning{}, id).Error; err != nil {
+		return nil, nil, err
+	}
+	var p Provisioning
+	if err := tx.Preload("Reservations").First(&p, id).Error; err != nil {
+		return nil, nil, err
+	}
+	var items []KitItem
+	if err := tx.Where("kit_template_id = ?", p.KitTemplateID).Find(&items).Error; err != nil {
+		return nil, nil, err
+	}
+	p.Shortages = kitShortages(items, reservedPerItem(p.Reservations))
+	return &p, items, nil
+}
+
+// checkoutDueProvisionings checks out every provisioning whose start date has come.
+func checkoutDueProvisionings(ctx context.Context) error {
+	var due []Provisioning
+	if err := db.WithContext(ctx).Where("status IN ? AND start_date <= ?",
+		[]string{provisioningReserved, provisioningShort}, time.Now()).Find(&due).Error; err != nil {
+		return err
+	}
+	for _, d := range due {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			p, _, err := loadProvisioning(tx, d.ID)
+			if err != nil {
+				return err
+			}
+			// It may have been checked out or cancelled by hand since it was listed.
+			if (p.Status != provisioningReserved && p.Status != provisioningShort) || time.Now().Before(p.StartDate) {
+				return nil
+			}
+			return checkoutProvisioning(tx, p)
+		})
+		if err != nil {
+			logger.Errorf("Failed to check out provisioning %d: %v", d.ID, err)
+			continue
+		}
+		logger.Infof("Provisioning %d checked out to employee %d", d.ID, d.EmployeeID)
+	}
+	return nil
+}
+
+func startKitCheckoutJob() {
+	startJob("kit-checkout", config().Kits.CheckoutInterval.Duration, checkoutDueProvisionings)
+}
+
+func validateKitTemplate(kit *KitTemplate) error {
+	if strings.TrimSpace(kit.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(kit.Items) == 0 {
+		return errors.New("a kit needs at least one item")
+	}
+	for i := range kit.Items {
+		if kit.Items[i].DeviceType == "" {
+			return errors.New("every item needs a device_type")
+		}
+		if kit.Items[i].Quantity <= 0 {
+			kit.Items[i].Quantity = 1
+		}
+	}
+	return nil
+}
+
+func createKitTemplate(c *gin.Context) {
+	var kit KitTemplate
+	if err := c.ShouldBindJSON(&kit); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateKitTemplate(&kit); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.Create(&kit).Error; err != nil {
+		logger.Errorf("Failed to create kit template: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create kit template")
+		return
+	}
+
+	logger.Infof("Kit template created: %s", kit.Name)
+	c.JSON(http.StatusCreated, kit)
+}
+
+func listKitTemplates(c *gin.Context) {
+	var kits []KitTemplate
+	if err := db.Preload("Items").Order("name").Find(&kits).Error; err != nil {
+		logger.Errorf("Failed to retrieve kit templates: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve kit templates")
+		return
+	}
+	c.JSON(http.StatusOK, kits)
+}
+
+func getKitTemplate(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var kit KitTemplate
+	if err := db.Preload("Items").First(&kit, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Kit template not found")
+		} else {
+			logger.Errorf("Failed to retrieve kit template: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve kit template")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, kit)
+}
+
+var (
+	errUnknownKitItem  = errors.New("items may only reference this kit's item ids")
+	errKitItemReserved = errors.New("cannot remove an item with active reservations")
+	errKitTemplateUsed = errors.New("kit template is used by active provisionings")
+)
+
+// saveKitItems updates a template's items in place. Items with an ID keep
+// it, so reservations made against them still count; items without one
+// are added and items left out are removed unless something is reserved
+// against them.
+func saveKitItems(tx *gorm.DB, kitID uint, items []KitItem) error {
+	var existing []KitItem
+	if err := tx.Where("kit_template_id = ?", kitID).Find(&existing).Error; err != nil {
+		return err
+	}
+	removed := make(map[uint]bool, len(existing))
+	for _, item := range existing {
+		removed[item.ID] = true
+	}
+
+	for i := range items {
+		item := &items[i]
+		item.KitTemplateID = kitID
+		if item.ID == 0 {
+			if err := tx.Create(item).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if !removed[item.ID] {
+			return errUnknownKitItem
+		}
+		delete(removed, item.ID)
+		if err := tx.Select("device_type", "brand", "model", "quantity").Updates(item).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(removed))
+	for id := range removed {
+		ids = append(ids, id)
+	}
+	var reserved int64
+	if err := tx.Model(&Reservation{}).Where("kit_item_id IN ? AND status = ?", ids, reservationActive).
+		Count(&reserved).Error; err != nil {
+		return err
+	}
+	if reserved > 0 {
+		return errKitItemReserved
+	}
+	return tx.Delete(&KitItem{}, ids).Error
+}
+
+// updateKitTemplate replaces a template's name, role and items.
+func updateKitTemplate(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var kit KitTemplate
+	if err := c.ShouldBindJSON(&kit); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateKitTemplate(&kit); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing KitTemplate
+		if err := tx.First(&existing, idInt).Error; err != nil {
+			return err
+		}
+		kit.ID = existing.ID
+		kit.CreatedAt = existing.CreatedAt
+		if err := tx.Select("name", "role").Updates(&kit).Error; err != nil {
+			return err
+		}
+		return saveKitItems(tx, kit.ID, kit.Items)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Kit template not found")
+			return
+		}
+		if errors.Is(err, errUnknownKitItem) {
+			respondWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, errKitItemReserved) {
+			respondWithError(c, http.StatusConflict, err.Error())
+			return
+		}
+		logger.Errorf("Failed to update kit template: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update kit template")
+		return
+	}
+
+	logger.Infof("Kit template updated: %s", kit.Name)
+	c.JSON(http.StatusOK, kit)
+}
+
+func deleteKitTemplate(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var result *gorm.DB
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Find(&KitTemplate{}, idInt).Error; err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&Provisioning{}).Where("kit_template_id = ? AND status IN ?", idInt,
+			[]string{provisioningReserved, provisioningShort}).Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return errKitTemplateUsed
+		}
+		result = tx.Delete(&KitTemplate{}, idInt)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Where("kit_template_id = ?", idInt).Delete(&KitItem{}).Error
+	})
+	if errors.Is(err, errKitTemplateUsed) {
+		respondWithError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to delete kit template: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete kit template")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Kit template not found")
+		return
+	}
+
+	logger.Infof("Kit template deleted with ID: %d", idInt)
+	c.JSON(http.StatusOK, gin.H{"message": "Kit template deleted successfully"})
+}
+
+// createProvisioning reserves a kit for a new employee. Without kit_id the
+// kit whose role matches the employee's role or title is used.
+func createProvisioning(c *gin.Context) {
+	var input struct {
+		EmployeeID uint   `json:"employee_id" binding:"required"`
+		KitID      uint   `json:"kit_id"`
+		StartDate  string `json:"start_date" binding:"required"` // YYYY-MM-DD
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	startDate, err := time.Parse("2006-01-02", input.StartDate)
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "start_date must be YYYY-MM-DD")
+		return
+	}
+
+	var p *Provisioning
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var employee Employee
+		if err := tx.First(&employee, input.EmployeeID).Error; err != nil {
+			return err
+		}
+
+		var kit KitTemplate
+		// Shared lock: the kit cannot be deleted while it is being provisioned.
+		query := tx.Clauses(clause.Locking{Strength: "SHARE"}).Preload("Items")
+		if input.KitID != 0 {
+			query = query.Where("id = ?", input.KitID)
+		} else {
+			query = query.Where("LOWER(role) IN ?", []string{strings.ToLower(employee.Role), strings.ToLower(employee.Title)})
+		}
+		if err := query.First(&kit).Error; err != nil {
+			return err
+		}
+
+		p = &Provisioning{
+			KitTemplateID: kit.ID,
+			EmployeeID:    employee.ID,
+			StartDate:     startDate,
+			Status:        provisioningShort,
+		}
+		if err := tx.Create(p).Error; err != nil {
+			return err
+		}
+		return reserveKitItems(tx, p, kit.Items)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Employee or matching kit not found")
+			return
+		}
+		logger.Errorf("Failed to provision kit: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to provision kit")
+		return
+	}
+
+	logger.Infof("Provisioning %d: %d devices reserved, %d shortages", p.ID, len(p.Reservations), len(p.Shortages))
+	c.JSON(http.StatusCreated, p)
+}
+
+func getProvisioning(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	p, _, err := loadProvisioning(db, uint(idInt))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Provisioning not found")
+		} else {
+			logger.Errorf("Failed to retrieve provisioning: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve provisioning")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// provisioningConflict is an error reported to the client as 409 Conflict.
+type provisioningConflict string
+
+func (e provisioningConflict) Error() string {
+	return string(e)
+}
+
+// changeProvisioning loads a provisioning in a transaction, checks it is
+// still open and applies action to it.
+func changeProvisioning(c *gin.Context, action string, apply func(tx *gorm.DB, p *Provisioning, items []KitItem) error) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var p *Provisioning
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var items []KitItem
+		var err error
+		if p, items, err = loadProvisioning(tx, uint(idInt)); err != nil {
+			return err
+		}
+		if p.Status == provisioningCheckedOut || p.Status == provisioningCancelled {
+			return provisioningConflict("Provisioning is already " + strings.ReplaceAll(p.Status, "_", " "))
+		}
+		return apply(tx, p, items)
+	})
+	if err != nil {
+		var conflict provisioningConflict
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(c, http.StatusNotFound, "Provisioning not found")
+		case errors.As(err, &conflict):
+			respondWithError(c, http.StatusConflict, conflict.Error())
+		default:
+			logger.Errorf("Failed to %s provisioning: %v", action, err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to "+action+" provisioning")
+		}
+		return
+	}
+
+	logger.Infof("Provisioning %d: %s", p.ID, action)
+	c.JSON(http.StatusOK, p)
+}
+
+// retryProvisioning tries again to reserve devices for reported shortages.
+func retryProvisioning(c *gin.Context) {
+	changeProvisioning(c, "reserve", reserveKitItems)
+}
+
+// checkoutProvisioningNow checks out a kit. Before the start date this needs
+// ?force=true.
+func checkoutProvisioningNow(c *gin.Context) {
+	force := c.Query("force") == "true"
+	changeProvisioning(c, "check out", func(tx *gorm.DB, p *Provisioning, items []KitItem) error {
+		if !force && time.Now().Before(p.StartDate) {
+			return provisioningConflict("Start date not reached; use force=true to check out early")
+		}
+		return checkoutProvisioning(tx, p)
+	})
+}
+
+func cancelProvisioning(c *gin.Context) {
+	changeProvisioning(c, "cancel", func(tx *gorm.DB, p *Provisioning, items []KitItem) error {
+		return releaseReservations(tx, p)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test shortage reporting for partially reserved kits
+func TestKitShortages(t *testing.T) {
+	items := []KitItem{
+		{ID: 1, DeviceType: "Laptop", Quantity: 1},
+		{ID: 2, DeviceType: "Monitor", Quantity: 2},
+		{ID: 3, DeviceType: "Phone", Model: "Pixel 8"},
+	}
+	one, two := uint(1), uint(2)
+	reservations := []Reservation{
+		{KitItemID: &one, Status: reservationActive},
+		{KitItemID: &two, Status: reservationActive},
+		{KitItemID: &two, Status: reservationCancelled},
+	}
+
+	shortages := kitShortages(items, reservedPerItem(reservations))
+
+	assert.Len(t, shortages, 2)
+	assert.Equal(t, KitShortage{KitItemID: 2, DeviceType: "Monitor", Requested: 2, Reserved: 1, Missing: 1}, shortages[0])
+	assert.Equal(t, "Pixel 8", shortages[1].Model)
+	assert.Equal(t, 1, shortages[1].Missing)
+}
+
+// Test that templates need items with a device type
+func TestValidateKitTemplate(t *testing.T) {
+	assert.Error(t, validateKitTemplate(&KitTemplate{Name: "Engineer"}))
+	assert.Error(t, validateKitTemplate(&KitTemplate{Name: "Engineer", Items: []KitItem{{Model: "X"}}}))
+
+	kit := KitTemplate{Name: "Engineer", Items: []KitItem{{DeviceType: "Laptop"}}}
+	assert.NoError(t, validateKitTemplate(&kit))
+	assert.Equal(t, 1, kit.Items[0].Quantity)
+}
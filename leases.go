@@ -0,0 +1,734 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Lease is a lease or rental contract covering one or more devices.
+type Lease struct {
+	ID             uint          `gorm:"primaryKey" json:"id"`
+	Lessor         string        `gorm:"column:lessor" json:"lessor" binding:"required"`
+	ContractNumber string        `gorm:"column:contract_number" json:"contract_number"`
+	StartDate      string        `gorm:"column:start_date" json:"start_date" binding:"required"` // YYYY-MM-DD
+	EndDate        string        `gorm:"column:end_date;index" json:"end_date" binding:"required"`
+	NoticeDays     int           `gorm:"column:notice_days" json:"notice_days"` // alert this long before the end; leases.alert_days when 0
+	AlertedAt      *time.Time    `gorm:"column:alerted_at" json:"alerted_at"`
+	Devices        []LeaseDevice `gorm:"foreignKey:LeaseID" json:"devices"`
+	CreatedAt      time.Time     `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time     `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// LeaseDevice is a device on a lease with what it costs. Before the lease
+// ends someone decides whether to return it or buy it out; completing that
+// decision closes the item.
+type LeaseDevice struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	LeaseID     uint       `gorm:"column:lease_id;index" json:"lease_id"`
+	DeviceID    uint       `gorm:"column:device_id;index;index:idx_lease_devices_open,unique,where:closed_at IS NULL" json:"device_id"` // on one open lease at a time
+	MonthlyCost uint       `gorm:"column:monthly_cost" json:"monthly_cost"`
+	BuyoutPrice uint       `gorm:"column:buyout_price" json:"buyout_price"`
+	Decision    string     `gorm:"column:decision" json:"decision"` // "return", "buyout" or empty while undecided
+	DecidedBy   *uint      `gorm:"column:decided_by" json:"decided_by"`
+	DecidedAt   *time.Time `gorm:"column:decided_at" json:"decided_at"`
+	ClosedAt    *time.Time `gorm:"column:closed_at" json:"closed_at"` // returned or bought out
+}
+
+// Lease end decisions.
+const (
+	leaseReturn = "return"
+	leaseBuyout = "buyout"
+)
+
+const dateLayout = "2006-01-02"
+
+func (l Lease) validate() error {
+	start, err := time.Parse(dateLayout, l.StartDate)
+	if err != nil {
+		return errors.New("start_date must be a date like 2024-01-31")
+	}
+	end, err := time.Parse(dateLayout, l.EndDate)
+	if err != nil {
+		return errors.New("end_date must be a date like 2024-01-31")
+	}
+	if !end.After(start) {
+		return errors.New("end_date must be after start_date")
+	}
+	if l.NoticeDays < 0 {
+		return errors.New("notice_days must not be negative")
+	}
+	return nil
+}
+
+// leaseAlertDue reports whether a lease ending at end should be alerted on
+// at now, noticeDays ahead.
+func leaseAlertDue(end time.Time, noticeDays int, now time.Time) bool {
+	return !now.Before(end.AddDate(0, 0, -noticeDays)) && now.Before(end.AddDate(0, 0, 1))
+}
+
+// leaseCostInMonth prorates an item's monthly cost by the days of month it
+// was on lease: from the lease start to the earlier of its end and the day
+// the item closed.
+func leaseCostInMonth(item LeaseDevice, start, end time.Time, month time.Time) float64 {
+	monthStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+	until := end.AddDate(0, 0, 1) // the end date is the last day on lease
+	if item.ClosedAt != nil && item.ClosedAt.Before(until) {
+		until = *item.ClosedAt
+	}
+
+	from := start
+	if monthStart.After(from) {
+		from = monthStart
+	}
+	if monthEnd.Before(until) {
+		until = monthEnd
+	}
+	if !until.After(from) {
+		return 0
+	}
+	days := until.Sub(from).Hours() / 24
+	monthDays := monthEnd.Sub(monthStart).Hours() / 24
+	return float64(item.MonthlyCost) * days / monthDays
+}
+
+// remainingLeaseMonths is how many monthly payments are left after now,
+// counting a part month as a whole one.
+func remainingLeaseMonths(end, now time.Time) int {
+	if !now.Before(end) {
+		return 0
+	}
+	months := (end.Year()-now.Year())*12 + int(end.Month()-now.Month())
+	if end.Day() > now.Day() {
+		months++
+	}
+	if months < 1 {
+		months = 1
+	}
+	return months
+}
+
+func parseLeaseDates(l Lease) (start, end time.Time) {
+	start, _ = time.Parse(dateLayout, l.StartDate)
+	end, _ = time.Parse(dateLayout, l.EndDate)
+	return start, end
+}
+
+// checkLeaseEnds notifies about leases nearing their end that still have
+// devices without a decision, once per lease.
+func checkLeaseEnds(ctx context.Context) error {
+	var leases []Lease
+	err := db.WithContext(ctx).Preload("Devices").Where("alerted_at IS NULL").Find(&leases).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, lease := range leases {
+		_, end := parseLeaseDates(lease)
+		notice := lease.NoticeDays
+		if notice == 0 {
+			notice = config().Leases.AlertDays
+		}
+		if !leaseAlertDue(end, notice, now) {
+			continue
+		}
+		undecided := 0
+		for _, item := range lease.Devices {
+			if item.Decision == "" && item.ClosedAt == nil {
+				undecided++
+			}
+		}
+		if undecided == 0 {
+			continue
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&lease).Update("alerted_at", now).Error; err != nil {
+				return err
+			}
+			return notify(tx, notificationEvent{
+				Type:    eventLeaseEnding,
+				Subject: fmt.Sprintf("Lease %s with %s ends %s", lease.ContractNumber, lease.Lessor, lease.EndDate),
+				Body:    fmt.Sprintf("%d devices still need a return or buyout decision.", undecided),
+				Key:     "lease-ending-" + strconv.Itoa(int(lease.ID)),
+			})
+		})
+		if err != nil {
+			return err
+		}
+		logger.Warnf("Lease %d ends %s with %d undecided devices", lease.ID, lease.EndDate, undecided)
+	}
+	return nil
+}
+
+func startLeaseJob() {
+	startJob("lease-ends", config().Leases.CheckInterval.Duration, checkLeaseEnds)
+}
+
+type leaseDeviceInput struct {
+	DeviceID    uint `json:"device_id" binding:"required"`
+	MonthlyCost uint `json:"monthly_cost"`
+	BuyoutPrice uint `json:"buyout_price"`
+}
+
+// leaseInputError is a request problem found while adding lease devices.
+type leaseInputError string
+
+func (e leaseInputError) Error() string {
+	return string(e)
+}
+
+// openLeaseDeviceIDs returns which of the given devices are on a lease item
+// that has not been closed.
+func openLeaseDeviceIDs(tx *gorm.DB, deviceIDs []uint) ([]uint, error) {
+	var ids []uint
+	err := tx.Model(&LeaseDevice{}).Where("device_id IN ? AND closed_at IS NULL", deviceIDs).Pluck("device_id", &ids).Error
+	return ids, err
+}
+
+// addLeaseDevices puts devices on a lease. A device can only be on one open
+// lease at a time.
+func addLeaseDevices(tx *gorm.DB, leaseID uint, inputs []leaseDeviceInput) ([]LeaseDevice, error) {
+	ids := make([]uint, len(inputs))
+	for i, in := range inputs {
+		ids[i] = in.DeviceID
+	}
+	if len(uniqueUints(ids)) != len(ids) {
+		return nil, leaseInputError("each device_id may be listed only once")
+	}
+	var found int64
+	if err := tx.Model(&Device{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+		return nil, err
+	}
+	if int(found) != len(ids) {
+		return nil, leaseInputError("every device_id must be an existing device")
+	}
+	leased, err := openLeaseDeviceIDs(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(leased) > 0 {
+		return nil, leaseInputError(fmt.Sprintf("devices already on an open lease: %v", leased))
+	}
+
+	items := make([]LeaseDevice, len(inputs))
+	for i, in := range inputs {
+		items[i] = LeaseDevice{LeaseID: leaseID, DeviceID: in.DeviceID, MonthlyCost: in.MonthlyCost, BuyoutPrice: in.BuyoutPrice}
+	}
+	if err := tx.Create(&items).Error; err != nil {
+		if isUniqueViolation(err) {
+			// Another request leased one of the devices since the check above.
+			return nil, leaseInputError("devices already on an open lease")
+		}
+		return nil, err
+	}
+	return items, nil
+}
+
+func createLease(c *gin.Context) {
+	var input struct {
+		Lease
+		Devices []leaseDeviceInput `json:"devices"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	lease := input.Lease
+	lease.ID = 0
+	lease.AlertedAt = nil
+	lease.Devices = nil
+	if err := lease.validate(); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&lease).Error; err != nil {
+			return err
+		}
+		if len(input.Devices) == 0 {
+			return nil
+		}
+		items, err := addLeaseDevices(tx, lease.ID, input.Devices)
+		lease.Devices = items
+		return err
+	})
+	var invalid leaseInputError
+	if errors.As(err, &invalid) {
+		respondWithError(c, http.StatusBadRequest, invalid.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to create lease: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create lease")
+		return
+	}
+
+	logger.Infof("Lease created: %d with %s", lease.ID, lease.Lessor)
+	c.JSON(http.StatusCreated, lease)
+}
+
+func findLease(c *gin.Context) (Lease, bool) {
+	var lease Lease
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return lease, false
+	}
+	if err := db.Preload("Devices").First(&lease, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Lease not found")
+		} else {
+			logger.Errorf("Failed to retrieve lease: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve lease")
+		}
+		return lease, false
+	}
+	return lease, true
+}
+
+func getLease(c *gin.Context) {
+	if lease, ok := findLease(c); ok {
+		c.JSON(http.StatusOK, lease)
+	}
+}
+
+// listLeases returns leases by end date; ?ending_within_days= keeps those
+// ending soon.
+func listLeases(c *gin.Context) {
+	query := db.Preload("Devices").Order("end_date, id")
+	if raw := c.Query("ending_within_days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 0 {
+			respondWithError(c, http.StatusBadRequest, "ending_within_days must be a non-negative number")
+			return
+		}
+		today := time.Now()
+		query = query.Where("end_date >= ? AND end_date <= ?",
+			today.Format(dateLayout), today.AddDate(0, 0, days).Format(dateLayout))
+	}
+	var leases []Lease
+	if err := query.Find(&leases).Error; err != nil {
+		logger.Errorf("Failed to retrieve leases: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve leases")
+		return
+	}
+	c.JSON(http.StatusOK, leases)
+}
+
+func updateLease(c *gin.Context) {
+	lease, ok := findLease(c)
+	if !ok {
+		return
+	}
+	var input Lease
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := input.validate(); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updates := map[string]interface{}{
+		"lessor": input.Lessor, "contract_number": input.ContractNumber,
+		"start_date": input.StartDate, "end_date": input.EndDate, "notice_days": input.NoticeDays,
+	}
+	if input.EndDate != lease.EndDate || input.NoticeDays != lease.NoticeDays {
+		updates["alerted_at"] = nil // an extension or new notice period warrants a fresh alert
+	}
+	if err := db.Model(&lease).Updates(updates).Error; err != nil {
+		logger.Errorf("Failed to update lease: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update lease")
+		return
+	}
+	if err := db.Preload("Devices").First(&lease, lease.ID).Error; err != nil {
+		logger.Errorf("Failed to retrieve lease: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve lease")
+		return
+	}
+	c.JSON(http.StatusOK, lease)
+}
+
+func addDevicesToLease(c *gin.Context) {
+	lease, ok := findLease(c)
+	if !ok {
+		return
+	}
+	var inputs []leaseDeviceInput
+	if err := c.ShouldBindJSON(&inputs); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(inputs) == 0 {
+		respondWithError(c, http.StatusBadRequest, "at least one device is required")
+		return
+	}
+
+	var items []LeaseDevice
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		items, err = addLeaseDevices(tx, lease.ID, inputs)
+		return err
+	})
+	var invalid leaseInputError
+	if errors.As(err, &invalid) {
+		respondWithError(c, http.StatusBadRequest, invalid.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to add devices to lease: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to add devices to lease")
+		return
+	}
+	c.JSON(http.StatusCreated, items)
+}
+
+// findLeaseDevice loads the lease item for :id and :device_id.
+func findLeaseDevice(c *gin.Context) (LeaseDevice, bool) {
+	var item LeaseDevice
+	leaseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return item, false
+	}
+	deviceID, err := strconv.Atoi(c.Param("device_id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid device ID format")
+		return item, false
+	}
+	if err := db.Where("lease_id = ? AND device_id = ?", leaseID, deviceID).First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Device is not on this lease")
+		} else {
+			logger.Errorf("Failed to retrieve lease device: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve lease device")
+		}
+		return item, false
+	}
+	return item, true
+}
+
+// decideLeaseDevice records whether a leased device will be returned or
+// bought out. The decision can change until it is completed.
+func decideLeaseDevice(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	item, ok := findLeaseDevice(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Decision string `json:"decision" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Decision != leaseReturn && input.Decision != leaseBuyout {
+		respondWithError(c, http.StatusBadRequest, "decision must be return or buyout")
+		return
+	}
+	if item.ClosedAt != nil {
+		respondWithError(c, http.StatusConflict, "Lease device is already closed")
+		return
+	}
+
+	now := time.Now()
+	if err := db.Model(&item).Updates(map[string]interface{}{
+		"decision": input.Decision, "decided_by": caller.ID, "decided_at": now,
+	}).Error; err != nil {
+		logger.Errorf("Failed to record lease decision: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to record decision")
+		return
+	}
+	item.Decision, item.DecidedBy, item.DecidedAt = input.Decision, &caller.ID, &now
+	c.JSON(http.StatusOK, item)
+}
+
+// completeLeaseDevice carries out the decision: a returned device leaves
+// the inventory as Returned, a bought-out one stays with the buyout price
+// as its price.
+var errLeaseDeviceClosed = errors.New("lease device is already closed")
+
+func completeLeaseDevice(c *gin.Context) {
+	if _, ok := currentEmployee(c); !ok {
+		return
+	}
+	item, ok := findLeaseDevice(c)
+	if !ok {
+		return
+	}
+	if item.ClosedAt != nil {
+		respondWithError(c, http.StatusConflict, "Lease device is already closed")
+		return
+	}
+	if item.Decision == "" {
+		respondWithError(c, http.StatusConflict, "Decide on return or buyout first")
+		return
+	}
+
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Only the first of concurrent calls closes the device.
+		result := tx.Model(&LeaseDevice{}).Where("id = ? AND closed_at IS NULL", item.ID).Update("closed_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errLeaseDeviceClosed
+		}
+		fields := map[string]interface{}{"status": statusReturned, "assigned_to": nil}
+		if item.Decision == leaseBuyout {
+			fields = map[string]interface{}{"price": item.BuyoutPrice}
+		}
+		return updateDeviceFields(tx, item.DeviceID, fields)
+	})
+	if errors.Is(err, errLeaseDeviceClosed) {
+		respondWithError(c, http.StatusConflict, "Lease device is already closed")
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(c, http.StatusNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to complete lease decision: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to complete decision")
+		return
+	}
+	item.ClosedAt = &now
+
+	logger.Infof("Lease %d device %d closed by %s", item.LeaseID, item.DeviceID, item.Decision)
+	c.JSON(http.StatusOK, item)
+}
+
+// leaseObligation is what is still owed on one lease.
+type leaseObligation struct {
+	LeaseID         uint    `json:"lease_id"`
+	Lessor          string  `json:"lessor"`
+	ContractNumber  string  `json:"contract_number"`
+	EndDate         string  `json:"end_date"`
+	DaysToEnd       int     `json:"days_to_end"`
+	OpenDevices     int     `json:"open_devices"`
+	Undecided       int     `json:"undecided"`
+	MonthlyCost     uint    `json:"monthly_cost"`
+	RemainingMonths int     `json:"remaining_months"`
+	RemainingCost   float64 `json:"remaining_cost"`
+	BuyoutCost      uint    `json:"buyout_cost"` // for devices marked for buyout
+}
+
+func computeLeaseObligation(lease Lease, now time.Time) leaseObligation {
+	_, end := parseLeaseDates(lease)
+	o := leaseObligation{
+		LeaseID:         lease.ID,
+		Lessor:          lease.Lessor,
+		ContractNumber:  lease.ContractNumber,
+		EndDate:         lease.EndDate,
+		DaysToEnd:       int(math.Ceil(end.Sub(now).Hours() / 24)),
+		RemainingMonths: remainingLeaseMonths(end.AddDate(0, 0, 1), now),
+	}
+	for _, item := range lease.Devices {
+		if item.ClosedAt != nil {
+			continue
+		}
+		o.OpenDevices++
+		o.MonthlyCost += item.MonthlyCost
+		if item.Decision == "" {
+			o.Undecided++
+		}
+		if item.Decision == leaseBuyout {
+			o.BuyoutCost += item.BuyoutPrice
+		}
+	}
+	o.RemainingCost = float64(o.MonthlyCost) * float64(o.RemainingMonths)
+	return o
+}
+
+// getLeaseObligations reports leases with open devices ending within
+// ?within_days= (default 365), soonest first.
+func getLeaseObligations(c *gin.Context) {
+	within, err := strconv.Atoi(c.DefaultQuery("within_days", "365"))
+	if err != nil || within < 0 {
+		respondWithError(c, http.StatusBadRequest, "within_days must be a non-negative number")
+		return
+	}
+	now := time.Now()
+
+	var leases []Lease
+	if err := db.Preload("Devices").Where("end_date <= ?", now.AddDate(0, 0, within).Format(dateLayout)).
+		Order("end_date, id").Find(&leases).Error; err != nil {
+		logger.Errorf("Failed to retrieve leases: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build lease obligations report")
+		return
+	}
+
+	obligations := []leaseObligation{}
+	var totalRemaining float64
+	var totalBuyout uint
+	for _, lease := range leases {
+		o := computeLeaseObligation(lease, now)
+		if o.OpenDevices == 0 {
+			continue
+		}
+		obligations = append(obligations, o)
+		totalRemaining += o.RemainingCost
+		totalBuyout += o.BuyoutCost
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"leases":               obligations,
+		"total_remaining_cost": totalRemaining,
+		"total_buyout_cost":    totalBuyout,
+	})
+}
+
+// departmentCharge is what one department's devices cost in a month.
+type departmentCharge struct {
+	Department string  `json:"department"` // empty for unassigned devices
+	Devices    int     `json:"devices"`
+	LeaseCost  float64 `json:"lease_cost"`
+}
+
+// getChargeback charges each department for the leased devices assigned to
+// its employees during ?month= (YYYY-MM, default this month). Owned devices
+// carry no running cost in the inventory, so only leases are charged.
+func getChargeback(c *gin.Context) {
+	month := time.Now().UTC()
+	if raw := c.Query("month"); raw != "" {
+		parsed, err := time.Parse("2006-01", raw)
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "month must look like 2024-05")
+			return
+		}
+		month = parsed
+	}
+	monthStart := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	var leases []Lease
+	err := db.Preload("Devices").
+		Where("start_date < ? AND end_date >= ?", monthEnd.Format(dateLayout), monthStart.Format(dateLayout)).
+		Find(&leases).Error
+	if err != nil {
+		logger.Errorf("Failed to retrieve leases: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build chargeback report")
+		return
+	}
+
+	var deviceIDs []uint
+	for _, lease := range leases {
+		for _, item := range lease.Devices {
+			deviceIDs = append(deviceIDs, item.DeviceID)
+		}
+	}
+	departments := map[uint]string{}
+	if len(deviceIDs) > 0 {
+		var rows []struct {
+			ID         uint
+			Department string
+		}
+		if err := db.Table("devices").Select("devices.id, employees.department").
+			Joins("LEFT JOIN employees ON employees.id = devices.assigned_to").
+			Where("devices.id IN ?", deviceIDs).Scan(&rows).Error; err != nil {
+			logger.Errorf("Failed to retrieve device assignments: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to build chargeback report")
+			return
+		}
+		for _, r := range rows {
+			departments[r.ID] = r.Department
+		}
+	}
+
+	charges := map[string]*departmentCharge{}
+	for _, lease := range leases {
+		start, end := parseLeaseDates(lease)
+		for _, item := range lease.Devices {
+			cost := leaseCostInMonth(item, start, end, monthStart)
+			if cost == 0 {
+				continue
+			}
+			department := departments[item.DeviceID]
+			charge, ok := charges[department]
+			if !ok {
+				charge = &departmentCharge{Department: department}
+				charges[department] = charge
+			}
+			charge.Devices++
+			charge.LeaseCost += cost
+		}
+	}
+
+	result := make([]departmentCharge, 0, len(charges))
+	var total float64
+	for _, charge := range charges {
+		charge.LeaseCost = math.Round(charge.LeaseCost*100) / 100
+		total += charge.LeaseCost
+		result = append(result, *charge)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Department < result[j].Department })
+	c.JSON(http.StatusOK, gin.H{"month": monthStart.Format("2006-01"), "departments": result, "total": total})
+}
+
+// getValuation values the fleet: owned devices at their price, leased ones
+// by what is still owed on them. Retired devices are left out.
+func getValuation(c *gin.Context) {
+	now := time.Now()
+	terminal := config().Retention.TerminalStatuses
+	leased := db.Model(&LeaseDevice{}).Select("device_id").Where("closed_at IS NULL")
+
+	var owned struct {
+		Devices int64
+		Value   uint64
+	}
+	if err := db.Model(&Device{}).Select("COUNT(*) AS devices, COALESCE(SUM(price), 0) AS value").
+		Where("status NOT IN ? AND id NOT IN (?)", terminal, leased).Scan(&owned).Error; err != nil {
+		logger.Errorf("Failed to value owned devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build valuation")
+		return
+	}
+
+	var leases []Lease
+	if err := db.Preload("Devices", "closed_at IS NULL").Find(&leases).Error; err != nil {
+		logger.Errorf("Failed to retrieve leases: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build valuation")
+		return
+	}
+	var leasedDevices int
+	var remaining float64
+	var buyout uint
+	for _, lease := range leases {
+		o := computeLeaseObligation(lease, now)
+		leasedDevices += o.OpenDevices
+		remaining += o.RemainingCost
+		for _, item := range lease.Devices {
+			buyout += item.BuyoutPrice
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"owned_devices":         owned.Devices,
+		"owned_value":           owned.Value,
+		"leased_devices":        leasedDevices,
+		"lease_remaining_cost":  remaining,
+		"lease_buyout_exposure": buyout, // cost of buying out every open leased device
+	})
+}
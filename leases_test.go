@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func leaseDate(s string) time.Time {
+	t, _ := time.Parse(dateLayout, s)
+	return t
+}
+
+// Test lease date validation
+func TestLeaseValidate(t *testing.T) {
+	assert.NoError(t, Lease{Lessor: "Acme", StartDate: "2024-01-01", EndDate: "2026-12-31"}.validate())
+	assert.Error(t, Lease{Lessor: "Acme", StartDate: "2024-01-01", EndDate: "2023-12-31"}.validate())
+	assert.Error(t, Lease{Lessor: "Acme", StartDate: "01/01/2024", EndDate: "2026-12-31"}.validate())
+	assert.Error(t, Lease{Lessor: "Acme", StartDate: "2024-01-01", EndDate: "2026-12-31", NoticeDays: -1}.validate())
+}
+
+// Test the window in which a lease end is alerted on
+func TestLeaseAlertDue(t *testing.T) {
+	end := leaseDate("2025-06-30")
+	assert.False(t, leaseAlertDue(end, 30, leaseDate("2025-05-30")))
+	assert.True(t, leaseAlertDue(end, 30, leaseDate("2025-05-31")))
+	assert.True(t, leaseAlertDue(end, 30, leaseDate("2025-06-30").Add(12*time.Hour)), "the last day still alerts")
+	assert.False(t, leaseAlertDue(end, 30, leaseDate("2025-07-01")), "ended leases are not alerted")
+}
+
+// Test proration of lease cost within a month
+func TestLeaseCostInMonth(t *testing.T) {
+	item := LeaseDevice{MonthlyCost: 300}
+	start, end := leaseDate("2025-04-16"), leaseDate("2027-04-15")
+
+	assert.Equal(t, 0.0, leaseCostInMonth(item, start, end, leaseDate("2025-03-01")))
+	assert.InDelta(t, 150, leaseCostInMonth(item, start, end, leaseDate("2025-04-01")), 0.01, "half of April")
+	assert.InDelta(t, 300, leaseCostInMonth(item, start, end, leaseDate("2026-02-01")), 0.01)
+	assert.InDelta(t, 150, leaseCostInMonth(item, start, end, leaseDate("2027-04-01")), 0.01, "through the 15th")
+
+	closed := leaseDate("2025-05-11")
+	item.ClosedAt = &closed
+	assert.InDelta(t, 300*10.0/31, leaseCostInMonth(item, start, end, leaseDate("2025-05-01")), 0.01, "until returned")
+	assert.Equal(t, 0.0, leaseCostInMonth(item, start, end, leaseDate("2025-06-01")))
+}
+
+// Test counting the monthly payments left on a lease
+func TestRemainingLeaseMonths(t *testing.T) {
+	assert.Equal(t, 3, remainingLeaseMonths(leaseDate("2025-01-01"), leaseDate("2024-10-16")))
+	assert.Equal(t, 2, remainingLeaseMonths(leaseDate("2024-12-16"), leaseDate("2024-10-16")))
+	assert.Equal(t, 1, remainingLeaseMonths(leaseDate("2024-10-20"), leaseDate("2024-10-16")))
+	assert.Equal(t, 0, remainingLeaseMonths(leaseDate("2024-10-16"), leaseDate("2024-10-16")))
+}
+
+// Test what is still owed on a lease
+func TestComputeLeaseObligation(t *testing.T) {
+	closed := leaseDate("2024-09-01")
+	lease := Lease{ID: 1, Lessor: "Acme", StartDate: "2023-01-01", EndDate: "2024-12-31", Devices: []LeaseDevice{
+		{DeviceID: 1, MonthlyCost: 50, BuyoutPrice: 200},
+		{DeviceID: 2, MonthlyCost: 40, BuyoutPrice: 150, Decision: leaseBuyout},
+		{DeviceID: 3, MonthlyCost: 30, BuyoutPrice: 100, Decision: leaseReturn},
+		{DeviceID: 4, MonthlyCost: 99, BuyoutPrice: 500, Decision: leaseReturn, ClosedAt: &closed},
+	}}
+
+	o := computeLeaseObligation(lease, leaseDate("2024-10-16"))
+	assert.Equal(t, 3, o.OpenDevices)
+	assert.Equal(t, 1, o.Undecided)
+	assert.Equal(t, uint(120), o.MonthlyCost)
+	assert.Equal(t, 3, o.RemainingMonths)
+	assert.Equal(t, 360.0, o.RemainingCost)
+	assert.Equal(t, uint(150), o.BuyoutCost)
+	assert.Equal(t, 76, o.DaysToEnd)
+}
+
+// Test that a device cannot be listed twice when adding lease devices
+func TestAddLeaseDevicesDuplicates(t *testing.T) {
+	_, err := addLeaseDevices(nil, 1, []leaseDeviceInput{{DeviceID: 4}, {DeviceID: 5}, {DeviceID: 4}})
+	assert.EqualError(t, err, "each device_id may be listed only once")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Location is a site or stock room devices can be kept at.
+type Location struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;uniqueIndex" json:"name" binding:"required"`
+	Address   string    `gorm:"column:address" json:"address"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func createLocation(c *gin.Context) {
+	var location Location
+	if err := c.ShouldBindJSON(&location); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := db.Create(&location).Error; err != nil {
+		logger.Errorf("Failed to create location: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create location")
+		return
+	}
+
+	logger.Infof("Location created: %s", location.Name)
+	c.JSON(http.StatusCreated, location)
+}
+
+func listLocations(c *gin.Context) {
+	var locations []Location
+	if err := db.Order("name").Find(&locations).Error; err != nil {
+		logger.Errorf("Failed to retrieve locations: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve locations")
+		return
+	}
+	c.JSON(http.StatusOK, locations)
+}
+
+func updateLocation(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var location Location
+	if err := c.ShouldBindJSON(&location); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := db.Model(&Location{}).Where("id = ?", idInt).
+		Updates(map[string]interface{}{"name": location.Name, "address": location.Address})
+	if result.Error != nil {
+		logger.Errorf("Failed to update location: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update location")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Location not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Location updated successfully"})
+}
+
+func getLocationByID(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var location Location
+	if err := db.First(&location, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Location not found")
+		} else {
+			logger.Errorf("Failed to retrieve location: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve location")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, location)
+}
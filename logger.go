@@ -1,18 +1,76 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
+const logFilePath = "app.log"
+
+// maxLogLines caps how many entries one log request returns.
+const maxLogLines = 1000
+
 func setupLogger() {
-	file, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err == nil {
 		logger.SetOutput(file)
 	} else {
 		logger.Warn("Failed to log to file, using default stderr")
 	}
 	logger.SetFormatter(&logrus.JSONFormatter{})
-	logger.SetLevel(logrus.InfoLevel)
+	logger.SetLevel(logrus.InfoLevel) // until the config is loaded
+}
+
+// tailLogFile returns up to n of the last JSON log entries in path, oldest
+// first, optionally keeping only one level. Lines that are not JSON are
+// returned as a message with no level. A missing file has no entries.
+func tailLogFile(path string, n int, level string) ([]map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return tailLogEntries(bufio.NewScanner(file), n, level)
+}
+
+func tailLogEntries(scanner *bufio.Scanner, n int, level string) ([]map[string]interface{}, error) {
+	if n > maxLogLines {
+		n = maxLogLines
+	}
+	ring := make([]map[string]interface{}, 0, n)
+	next := 0 // oldest entry once the ring is full
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			entry = map[string]interface{}{"msg": line}
+		}
+		if level != "" && !strings.EqualFold(stringField(entry, "level"), level) {
+			continue
+		}
+		if len(ring) < n {
+			ring = append(ring, entry)
+			continue
+		}
+		ring[next] = entry
+		next = (next + 1) % n
+	}
+	entries := make([]map[string]interface{}, 0, len(ring))
+	entries = append(entries, ring[next:]...)
+	return append(entries, ring[:next]...), scanner.Err()
+}
+
+func stringField(entry map[string]interface{}, key string) string {
+	s, _ := entry[key].(string)
+	return s
 }
@@ -2,14 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const chunkSize = 1000
@@ -22,7 +28,12 @@ func registerDevice(c *gin.Context) {
 		return
 	}
 
-	if err := db.Create(&device).Error; err != nil {
+	var rejection *pluginRejection
+	if err := createDevice(c.Request.Context(), &device); errors.As(err, &rejection) {
+		logger.Warnf("Device rejected: %v", err)
+		respondWithError(c, http.StatusUnprocessableEntity, rejection.Error())
+		return
+	} else if err != nil {
 		logger.Errorf("Failed to register device: %v", err)
 		respondWithError(c, http.StatusInternalServerError, "Failed to register device")
 		return
@@ -41,44 +52,204 @@ func updateDevice(c *gin.Context) {
 		return
 	}
 
-	var device Device
-	if err := c.ShouldBindJSON(&device); err != nil {
-		logger.Warnf("Invalid input: %v", err)
-		respondWithError(c, http.StatusBadRequest, err.Error())
+	var stored Device
+	err = db.WithContext(c.Request.Context()).First(&stored, idInt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Warnf("Device not found for ID: %d", idInt)
+		respondWithError(c, http.StatusNotFound, "Device not found")
 		return
 	}
-
-	result := db.Model(&Device{}).Where("id = ?", idInt).Updates(device)
-	if result.Error != nil {
-		logger.Errorf("Failed to update device: %v", result.Error)
+	if err != nil {
+		logger.Errorf("Failed to retrieve device: %v", err)
 		respondWithError(c, http.StatusInternalServerError, "Failed to update device")
 		return
 	}
 
-	if result.RowsAffected == 0 {
+	// Fields left out of the body keep their stored values.
+	device := stored
+	if err := c.ShouldBindJSON(&device); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = saveDeviceChanges(c.Request.Context(), stored, device)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		logger.Warnf("Device not found for ID: %d", idInt)
 		respondWithError(c, http.StatusNotFound, "Device not found")
 		return
 	}
+	var rejection *pluginRejection
+	if errors.As(err, &rejection) {
+		logger.Warnf("Device update rejected: %v", err)
+		respondWithError(c, http.StatusUnprocessableEntity, rejection.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to update device: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update device")
+		return
+	}
 
 	logger.Infof("Device updated: %v", device)
 	c.JSON(http.StatusOK, gin.H{"message": "Device updated successfully"})
 }
 
-func listDevices(c *gin.Context) {
+// createDevice runs the before_create plugins, then inserts the device and
+// records its initial status.
+func createDevice(ctx context.Context, device *Device) error {
+	if err := runDeviceHook(ctx, hookBeforeCreate, 0, 0, device); err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(device).Error; err != nil {
+			return err
+		}
+		return recordInitialStatuses(tx, []Device{*device})
+	})
+}
+
+// editableDeviceColumns are the columns device updates may write. Legal
+// holds and positions have their own endpoints, which check the caller and
+// keep history.
+var editableDeviceColumns = []string{
+	"device_name", "asset_tag", "serial_number", "device_type", "brand", "model", "os", "os_version",
+	"purchase_date", "warranty_end", "maintenance_due", "status", "price", "assigned_to", "location_id",
+}
+
+// saveDeviceChanges runs the before_update plugins on device, the stored
+// device with the caller's changes applied, then saves every editable column
+// that now differs from stored, recording a status change if there is one. Columns
+// nobody changed are left alone, so concurrent edits to them survive. It
+// returns gorm.ErrRecordNotFound if the device has been deleted meanwhile.
+func saveDeviceChanges(ctx context.Context, stored, device Device) error {
+	device.ID = stored.ID
+	if err := runDeviceHook(ctx, hookBeforeUpdate, stored.ID, 0, &device); err != nil {
+		return err
+	}
+	columns, err := changedDeviceColumns(stored, device)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var previous Device
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "device_name", "status").
+			First(&previous, stored.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&device).Select(columns).Updates(&device).Error; err != nil {
+			return err
+		}
+		if !containsString(columns, "status") || device.Status == previous.Status {
+			return nil
+		}
+		previous.DeviceName = device.DeviceName
+		return recordStatusChange(tx, previous, device.Status)
+	})
+}
+
+// changedDeviceColumns lists the editable columns whose values differ
+// between before and after.
+func changedDeviceColumns(before, after Device) ([]string, error) {
+	old, err := deviceFieldValues(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := deviceFieldValues(after)
+	if err != nil {
+		return nil, err
+	}
+	var columns []string
+	for _, column := range editableDeviceColumns {
+		if !reflect.DeepEqual(old[column], updated[column]) {
+			columns = append(columns, column)
+		}
+	}
+	return columns, nil
+}
+
+// maxPageSize caps the limit of a paged list.
+const maxPageSize = 1000
+
+// pageParams reads the page and limit query parameters. The limit is kept
+// between 1 and maxPageSize, falling back to defaultLimit if it is missing
+// or below 1.
+func pageParams(c *gin.Context, defaultLimit int) (limit, offset int) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
+	limit, _ = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return limit, pageOffset(page, limit)
+}
+
+// pageOffset returns the offset of a page of limit items, counting pages
+// from 1. Pages so far out that the offset would overflow start past the
+// end of any list instead.
+func pageOffset(page, limit int) int {
+	if page < 1 || limit < 1 {
+		return 0
+	}
+	if page-1 > math.MaxInt32/limit {
+		return math.MaxInt32
+	}
+	return (page - 1) * limit
+}
+
+// pageOf returns limit items from offset on; a negative limit means all of
+// them.
+func pageOf[T any](items []T, limit, offset int) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(items) {
+		return []T{}
+	}
+	end := len(items)
+	if limit >= 0 && limit < end-offset {
+		end = offset + limit
+	}
+	return items[offset:end]
+}
+
+func listDevices(c *gin.Context) {
+	limit, offset := pageParams(c, 10)
+
+	filter, err := parseDeviceFilter(c.Request.URL.Query())
+	if err != nil {
+		logger.Warnf("Invalid filter: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	var devices []Device
-	if err := db.Limit(limit).Offset(offset).Find(&devices).Error; err != nil {
+	if wantsNDJSON(c) {
+		// Streams are unbounded unless the caller asks for a page.
+		if _, ok := c.GetQuery("limit"); !ok {
+			limit, offset = -1, 0
+		}
+		streamDevices(c, filter, limit, offset)
+		return
+	}
+
+	devices, err := findDevicesWithGeo(filter.apply(db), filter.Geo, limit, offset)
+	if err != nil {
 		logger.Errorf("Failed to retrieve devices: %v", err)
 		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
 		return
 	}
 
+	result, err := selectDeviceFields(devices, filter.Fields)
+	if err != nil {
+		logger.Errorf("Failed to select device fields: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+
 	logger.Infof("Devices retrieved: %d", len(devices))
-	c.JSON(http.StatusOK, devices)
+	c.JSON(http.StatusOK, result)
 }
 
 func getDeviceByID(c *gin.Context) {
@@ -106,6 +277,8 @@ func getDeviceByID(c *gin.Context) {
 	c.JSON(http.StatusOK, device)
 }
 
+var errDeviceOnTransfer = errors.New("device is on an open transfer")
+
 func deleteDevice(c *gin.Context) {
 	id := c.Param("id")
 	idInt, err := strconv.Atoi(id)
@@ -115,9 +288,32 @@ func deleteDevice(c *gin.Context) {
 		return
 	}
 
-	result := db.Delete(&Device{}, idInt)
-	if result.Error != nil {
-		logger.Errorf("Failed to delete device: %v", result.Error)
+	var result *gorm.DB
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Deleting a device mid-transfer would leave the transfer unable to finish.
+		moving, err := onOpenTransfer(tx, uint(idInt))
+		if err != nil {
+			return err
+		}
+		if moving {
+			return errDeviceOnTransfer
+		}
+		// Free the device's addresses and SIMs for reuse.
+		if err := tx.Where("device_id = ?", idInt).Delete(&NetworkInterface{}).Error; err != nil {
+			return err
+		}
+		if err := detachDeviceSIMs(tx, []uint{uint(idInt)}); err != nil {
+			return err
+		}
+		result = tx.Delete(&Device{}, idInt)
+		return result.Error
+	})
+	if errors.Is(err, errDeviceOnTransfer) {
+		respondWithError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to delete device: %v", err)
 		respondWithError(c, http.StatusInternalServerError, "Failed to delete device")
 		return
 	}
@@ -148,19 +344,52 @@ func uploadCSV(c *gin.Context) {
 	}
 	defer src.Close()
 
+	job := newImportJob(file.Filename, file.Size)
+	importCSV(c.Request.Context(), src, job)
+	progress := job.progress()
+
+	logger.Info("CSV uploaded and processed successfully")
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "CSV uploaded and processed successfully",
+		"rows":     progress.Rows,
+		"inserted": progress.Inserted,
+		"skipped":  progress.Skipped,
+		"errors":   progress.Errors,
+	})
+}
+
+// importCSV runs the CSV import pipeline over src, reporting progress to job.
+// Each row passes through the import transform scripts and then the
+// import_row_transform plugins before insertion.
+func importCSV(ctx context.Context, src io.Reader, job *importJob) {
+	defer job.finish()
+
+	transforms, err := loadImportTransforms(ctx)
+	if err != nil {
+		logger.Errorf("Failed to load import transforms: %v", err)
+		job.rowSkipped(0, "Failed to load import transforms: "+err.Error())
+		return
+	}
+
 	var wg sync.WaitGroup
-	recordChannel := make(chan string, 10000) // Channel to hold raw CSV lines
-	batchChannel := make(chan []Device, 100)  // Channel to hold processed Device batches
+	settings := config().Import
+	recordChannel := make(chan csvLine, settings.QueueSize) // Channel to hold raw CSV lines
+	batchChannel := make(chan deviceBatch, 100)             // Channel to hold processed Device batches
 
 	// Worker pool for processing batches
-	numWorkers := 10 // Number of workers for batch processing
+	numWorkers := settings.Workers // Number of workers for batch processing
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for batch := range batchChannel {
-				if len(batch) > 0 {
-					processBatch(batch)
+				if len(batch.devices) == 0 {
+					continue
+				}
+				if err := processBatch(batch.devices); err != nil {
+					job.batchFailed(batch.firstLine, batch.lastLine, len(batch.devices), err)
+				} else {
+					job.batchInserted(len(batch.devices))
 				}
 			}
 		}()
@@ -168,63 +397,94 @@ func uploadCSV(c *gin.Context) {
 
 	// Goroutine to read file and feed records to the recordChannel
 	go func() {
-		scanner := bufio.NewScanner(src)
+		scanner := bufio.NewScanner(&countingReader{r: src, job: job})
+		line := 0
 		for scanner.Scan() {
-			recordChannel <- scanner.Text()
+			line++
+			recordChannel <- csvLine{number: line, text: scanner.Text()}
 		}
 		close(recordChannel)
 		if err := scanner.Err(); err != nil {
 			logger.Errorf("Error reading file: %v", err)
+			job.rowSkipped(line+1, "Error reading file: "+err.Error())
 		}
 	}()
 
 	// Goroutine to group records into batches and send to batchChannel
 	go func() {
-		var batch []Device
+		var batch deviceBatch
 		for record := range recordChannel {
-			data := strings.Split(record, ",")
-			if len(data) < 10 {
-				logger.Warnf("Skipping invalid record: %s", record)
+			job.rowRead()
+			data := strings.Split(record.text, ",")
+			// Transforms may reshape vendor files, so only untransformed rows need every column.
+			if len(transforms) == 0 && len(data) < 10 {
+				logger.Warnf("Skipping invalid record: %s", record.text)
+				job.rowSkipped(record.number, "expected 10 columns")
+				continue
+			}
+			row, reasons, dropped, err := transforms.apply(rowFromRecord(data), data)
+			if err != nil {
+				logger.Warnf("Skipping record on line %d: %v", record.number, err)
+				job.rowSkipped(record.number, err.Error())
+				continue
+			}
+			if dropped != "" {
+				job.rowSkipped(record.number, "dropped: "+dropped)
+				continue
+			}
+			if len(reasons) > 0 {
+				job.rowChanged(record.number, reasons)
+			}
+			device := deviceFromRow(row)
+			if err := runDeviceHook(ctx, hookImportRow, 0, record.number, &device); err != nil {
+				logger.Warnf("Skipping record on line %d: %v", record.number, err)
+				job.rowSkipped(record.number, err.Error())
 				continue
 			}
-			device := Device{
-				DeviceName:   data[0],
-				DeviceType:   data[1],
-				Brand:        data[2],
-				Model:        data[3],
-				Os:           data[4],
-				OsVersion:    data[5],
-				PurchaseDate: data[6],
-				WarrantyEnd:  data[7],
-				Status:       data[8],
-				Price:        uint(atoiSafe(data[9])),
+			if len(batch.devices) == 0 {
+				batch.firstLine = record.number
 			}
-			batch = append(batch, device)
+			batch.devices = append(batch.devices, device)
+			batch.lastLine = record.number
 
-			if len(batch) >= chunkSize {
+			if len(batch.devices) >= chunkSize {
 				batchChannel <- batch
-				batch = nil // Reset batch
+				batch = deviceBatch{} // Reset batch
 			}
 		}
 
 		// Send remaining batch if any
-		if len(batch) > 0 {
+		if len(batch.devices) > 0 {
 			batchChannel <- batch
 		}
 		close(batchChannel)
 	}()
 
 	wg.Wait()
+}
 
-	logger.Info("CSV uploaded and processed successfully")
-	c.JSON(http.StatusOK, gin.H{"message": "CSV uploaded and processed successfully"})
+type csvLine struct {
+	number int
+	text   string
 }
 
-func processBatch(batch []Device) {
+type deviceBatch struct {
+	devices             []Device
+	firstLine, lastLine int
+}
+
+func processBatch(batch []Device) error {
 	// Bulk insert for efficiency
-	if err := db.Create(&batch).Error; err != nil {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		return recordInitialStatuses(tx, batch)
+	})
+	if err != nil {
 		logger.Errorf("Error inserting batch: %v", err)
 	}
+	return err
 }
 
 func atoiSafe(str string) int {
@@ -236,7 +496,22 @@ func respondWithError(c *gin.Context, code int, message string) {
 	c.JSON(code, gin.H{"error": message})
 }
 
+// getLogs returns the most recent entries of the log file, newest last.
+// ?lines= limits how many (default 200) and ?level= keeps one level only.
 func getLogs(c *gin.Context) {
 	logger.Info("Log retrieval endpoint hit")
-	c.JSON(http.StatusOK, gin.H{"message": "Logs endpoint under construction"})
+
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", "200"))
+	if err != nil || lines <= 0 || lines > maxLogLines {
+		respondWithError(c, http.StatusBadRequest, fmt.Sprintf("lines must be between 1 and %d", maxLogLines))
+		return
+	}
+
+	entries, err := tailLogFile(logFilePath, lines, c.Query("level"))
+	if err != nil {
+		logger.Errorf("Failed to read log file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to read logs")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
 }
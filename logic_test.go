@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test finding the columns an update may write, including cleared ones
+func TestChangedDeviceColumns(t *testing.T) {
+	location := uint(3)
+	lat := 51.5
+	before := Device{ID: 1, DeviceName: "Lab PC", Status: "Active", Price: 900, LocationID: &location, LegalHold: true}
+	after := before
+	after.Status = "Retired"
+	after.Price = 0
+	after.LocationID = nil
+	after.LegalHold = false
+	after.Latitude = &lat
+
+	columns, err := changedDeviceColumns(before, after)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"status", "price", "location_id"}, columns)
+
+	columns, err = changedDeviceColumns(before, before)
+	assert.NoError(t, err)
+	assert.Empty(t, columns)
+}
+
+// Test paging a list, including out-of-range and overflowing offsets
+func TestPageOf(t *testing.T) {
+	devices := []Device{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	assert.Equal(t, []Device{{ID: 2}}, pageOf(devices, 1, 1))
+	assert.Equal(t, devices, pageOf(devices, -1, 0))
+	assert.Equal(t, []Device{{ID: 1}, {ID: 2}}, pageOf(devices, 2, -2), "a negative offset starts at the beginning")
+	assert.Empty(t, pageOf(devices, 10, 3))
+	assert.Equal(t, []Device{{ID: 3}}, pageOf(devices, math.MaxInt, 2), "offset+limit would overflow")
+}
+
+// Test page offsets, which must not overflow however far out the page is
+func TestPageOffset(t *testing.T) {
+	assert.Equal(t, 0, pageOffset(1, 10))
+	assert.Equal(t, 20, pageOffset(3, 10))
+	assert.Equal(t, 0, pageOffset(-5, 10))
+	assert.Equal(t, math.MaxInt32, pageOffset(math.MaxInt-1, maxPageSize))
+	assert.Positive(t, pageOffset(math.MaxInt, 25)+26)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
@@ -12,46 +14,241 @@ var logger = logrus.New()
 
 func initializeDB() {
 	var err error
-	dsn := "host=db user=postgres password=Priyajit@2002 dbname=devices port=5432 sslmode=disable"
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	dsn := config().Database.DSN
+	// History tables keep pointing at devices after they are deleted, so
+	// relations are not enforced with foreign keys.
+	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
 	if err != nil {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
-	if err := db.AutoMigrate(&Device{}); err != nil {
+	if err := db.AutoMigrate(&Device{}, &Employee{}, &Group{}, &Offboarding{}, &OffboardingItem{},
+		&KitTemplate{}, &KitItem{}, &Provisioning{}, &Reservation{},
+		&Location{}, &DeviceStatusChange{}, &StockThreshold{}, &StockAlert{}, &CalendarFeed{}, &DevicePosition{},
+		&SavedSearch{}, &SavedSearchMatch{}, &SavedSearchChange{},
+		&Watch{}, &NotificationPreference{}, &Notification{}, &ArchivedDevice{}, &Plugin{}, &ImportTransform{},
+		&DeviceShare{}, &DeviceShareAccess{}, &WarrantyCheck{}, &EmissionFactor{},
+		&Consumable{}, &ConsumableStock{}, &ConsumableEntry{}, &ConsumableAlert{},
+		&Lease{}, &LeaseDevice{}, &NetworkInterface{},
+		&SIM{}, &MobileLine{}, &SIMAttachment{},
+		&Transfer{}, &TransferItem{}, &TransferDiscrepancy{}); err != nil {
+		logger.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := dropLegacyUserNameIndex(db); err != nil {
+		logger.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := dropLegacyEmissionFactorIndex(db); err != nil {
 		logger.Fatalf("Failed to migrate database: %v", err)
 	}
+	if err := dropLegacyConsumableSKUIndex(db); err != nil {
+		logger.Fatalf("Failed to migrate database: %v", err)
+	}
+	detectPostGIS()
 }
 
 type Device struct {
-	ID           uint   `gorm:"primaryKey" json:"id"`
-	DeviceName   string `gorm:"column:device_name" json:"device_name"`
-	DeviceType   string `gorm:"column:device_type" json:"device_type"`
-	Brand        string `gorm:"column:brand" json:"brand"`
-	Model        string `gorm:"column:model" json:"model"`
-	Os           string `gorm:"column:os" json:"os"`
-	OsVersion    string `gorm:"column:os_version" json:"os_version"`
-	PurchaseDate string `gorm:"column:purchase_date" json:"purchase_date"`
-	WarrantyEnd  string `gorm:"column:warranty_end" json:"warranty_end"`
-	Status       string `gorm:"column:status" json:"status"`
-	Price        uint   `gorm:"column:price" json:"price"`
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	DeviceName     string     `gorm:"column:device_name" json:"device_name"`
+	AssetTag       string     `gorm:"column:asset_tag;index" json:"asset_tag"`
+	SerialNumber   string     `gorm:"column:serial_number;index" json:"serial_number"`
+	DeviceType     string     `gorm:"column:device_type" json:"device_type"`
+	Brand          string     `gorm:"column:brand" json:"brand"`
+	Model          string     `gorm:"column:model" json:"model"`
+	Os             string     `gorm:"column:os" json:"os"`
+	OsVersion      string     `gorm:"column:os_version" json:"os_version"`
+	PurchaseDate   string     `gorm:"column:purchase_date" json:"purchase_date"`
+	WarrantyEnd    string     `gorm:"column:warranty_end" json:"warranty_end"`
+	MaintenanceDue string     `gorm:"column:maintenance_due" json:"maintenance_due"`
+	Status         string     `gorm:"column:status" json:"status"`
+	Price          uint       `gorm:"column:price" json:"price"`
+	AssignedTo     *uint      `gorm:"column:assigned_to;index" json:"assigned_to"`
+	LocationID     *uint      `gorm:"column:location_id;index" json:"location_id"`
+	Latitude       *float64   `gorm:"column:latitude;index:idx_devices_lat_lng" json:"latitude"`
+	Longitude      *float64   `gorm:"column:longitude;index:idx_devices_lat_lng" json:"longitude"`
+	LocatedAt      *time.Time `gorm:"column:located_at" json:"located_at"`
+	LegalHold      bool       `gorm:"column:legal_hold" json:"legal_hold"` // exempt from archival
 }
 
+// Device statuses set by the application itself. Imports may use other values.
+const (
+	statusInStock   = "In Stock"
+	statusReserved  = "Reserved"
+	statusAssigned  = "Assigned"
+	statusLost      = "Lost"
+	statusInTransit = "In Transit" // shipped between locations
+	statusDisposed  = "Disposed"
+	statusRecycled  = "Recycled"
+	statusReturned  = "Returned" // to the lessor at the end of a lease
+)
+
 func main() {
 	setupRouter()
 	setupLogger() // Initialize the logger
+	if err := loadConfig(); err != nil {
+		logger.Fatalf("Failed to load config: %v", err)
+	}
+	watchConfig()
 	initializeDB()
+	startDirectorySync()
+	startOffboardingJob()
+	startKitCheckoutJob()
+	startStockJob()
+	startSavedSearchJob()
+	startNotificationJob()
+	startArchiveJob()
+	startWarrantyJob()
+	startLeaseJob()
 
 	r := gin.Default()
+	r.Use(corsMiddleware(), rateLimitMiddleware())
 	r.POST("/device", registerDevice)
 	r.PUT("/device/:id", updateDevice)
 	r.GET("/device", listDevices)
+	r.POST("/device/batch-get", batchGetDevices)
 	r.GET("/device/:id", getDeviceByID)
 	r.DELETE("/device/:id", deleteDevice)
+	r.PUT("/device/:id/position", updateDevicePosition)
+	r.GET("/device/:id/positions", listDevicePositions)
+	r.POST("/agent/checkin", agentCheckIn)
+	r.GET("/geo/devices", exportDevicesGeoJSON)
+	r.PUT("/device/:id/legal-hold", setDeviceLegalHold)
+	r.POST("/device/:id/warranty/lookup", lookupDeviceWarranty)
+	r.GET("/warranty/checks", listWarrantyChecks)
+	r.GET("/device/:id/footprint", getDeviceFootprint)
+	r.POST("/emission-factors", createEmissionFactor)
+	r.GET("/emission-factors", listEmissionFactors)
+	r.PUT("/emission-factors/:id", updateEmissionFactor)
+	r.DELETE("/emission-factors/:id", deleteEmissionFactor)
+	r.POST("/emission-factors/import", importEmissionFactors)
+	r.GET("/reports/sustainability", getSustainabilityReport)
+	r.GET("/reports/lease-obligations", getLeaseObligations)
+	r.GET("/reports/chargeback", getChargeback)
+	r.GET("/reports/valuation", getValuation)
+	r.GET("/reports/mobile-costs", getMobileCostReport)
+	r.POST("/device/:id/shares", createDeviceShare)
+	r.GET("/device/:id/shares", listDeviceShares)
+	r.POST("/shares/:id/revoke", revokeDeviceShare)
+	r.GET("/shares/:id/accesses", listDeviceShareAccesses)
+	r.GET("/shared/:token", openDeviceShare)
+	r.POST("/archive/run", runArchive)
+	r.GET("/archive/devices", searchArchivedDevices)
+	r.POST("/plugins", createPlugin)
+	r.GET("/plugins", listPlugins)
+	r.PUT("/plugins/:id", updatePlugin)
+	r.DELETE("/plugins/:id", deletePlugin)
+	r.POST("/import-transforms", createImportTransform)
+	r.GET("/import-transforms", listImportTransforms)
+	r.PUT("/import-transforms/:id", updateImportTransform)
+	r.DELETE("/import-transforms/:id", deleteImportTransform)
+	r.POST("/import-transforms/preview", previewImportTransforms)
 	r.POST("/upload", uploadCSV)
 	r.GET("/logs", getLogs)
+	r.GET("/employees", listEmployees)
+	r.GET("/employees/:id", getEmployeeByID)
+	r.POST("/employees/:id/offboarding", startEmployeeOffboarding)
+	r.GET("/employees/:id/consumables", listEmployeeConsumables)
+	r.GET("/employees/:id/mobile-lines", listEmployeeMobileLines)
+	r.GET("/employees/:id/personal-data", exportPersonalData)
+	r.POST("/employees/:id/erasure", erasePersonalData)
+	r.GET("/offboardings", listOffboardings)
+	r.GET("/offboardings/:id", getOffboarding)
+	r.PUT("/offboardings/:id/items/:item_id", updateOffboardingItem)
+	r.GET("/offboardings/:id/report", getOffboardingReport)
+	r.POST("/kits", createKitTemplate)
+	r.GET("/kits", listKitTemplates)
+	r.GET("/kits/:id", getKitTemplate)
+	r.PUT("/kits/:id", updateKitTemplate)
+	r.DELETE("/kits/:id", deleteKitTemplate)
+	r.POST("/provisionings", createProvisioning)
+	r.GET("/provisionings/:id", getProvisioning)
+	r.POST("/provisionings/:id/reserve", retryProvisioning)
+	r.POST("/provisionings/:id/checkout", checkoutProvisioningNow)
+	r.POST("/provisionings/:id/cancel", cancelProvisioning)
+	r.POST("/locations", createLocation)
+	r.GET("/locations", listLocations)
+	r.GET("/locations/:id", getLocationByID)
+	r.PUT("/locations/:id", updateLocation)
+	r.POST("/transfers", createTransfer)
+	r.GET("/transfers", listTransfers)
+	r.GET("/transfers/discrepancies", listTransferDiscrepancies)
+	r.POST("/transfers/discrepancies/:id/resolve", resolveTransferDiscrepancy)
+	r.GET("/transfers/:id", getTransfer)
+	r.POST("/transfers/:id/ship", shipTransfer)
+	r.POST("/transfers/:id/receive", receiveTransfer)
+	r.POST("/transfers/:id/close", closeTransfer)
+	r.POST("/transfers/:id/cancel", cancelTransfer)
+	r.POST("/transfers/:id/discrepancies", reportTransferDiscrepancy)
+	r.GET("/device/:id/movements", getDeviceMovements)
+	r.POST("/stock/thresholds", createStockThreshold)
+	r.GET("/stock/thresholds", listStockThresholds)
+	r.PUT("/stock/thresholds/:id", updateStockThreshold)
+	r.DELETE("/stock/thresholds/:id", deleteStockThreshold)
+	r.GET("/stock/levels", getStockLevels)
+	r.GET("/stock/alerts", listStockAlerts)
+	r.POST("/consumables", createConsumable)
+	r.GET("/consumables", listConsumables)
+	r.GET("/consumables/alerts", listConsumableAlerts)
+	r.GET("/consumables/:id", getConsumable)
+	r.PUT("/consumables/:id", updateConsumable)
+	r.PUT("/consumables/:id/stock/:location_id", setConsumableMinimum)
+	r.POST("/consumables/:id/receive", receiveConsumable)
+	r.POST("/consumables/:id/issue", issueConsumable)
+	r.POST("/consumables/:id/transfer", transferConsumable)
+	r.GET("/consumables/:id/ledger", listConsumableLedger)
+	r.POST("/device/:id/interfaces", createInterface)
+	r.GET("/device/:id/interfaces", listDeviceInterfaces)
+	r.PUT("/interfaces/:id", updateInterface)
+	r.DELETE("/interfaces/:id", deleteInterface)
+	r.GET("/interfaces/lookup", lookupInterface)
+	r.GET("/interfaces/export", exportInterfaces)
+	r.GET("/device/:id/sims", getDeviceSIMs)
+	r.POST("/sims", createSIM)
+	r.GET("/sims", listSIMs)
+	r.POST("/sims/:id/attach", attachSIM)
+	r.POST("/sims/:id/detach", detachSIM)
+	r.GET("/sims/:id/history", getSIMHistory)
+	r.POST("/mobile-lines", createMobileLine)
+	r.GET("/mobile-lines", listMobileLines)
+	r.PUT("/mobile-lines/:id", updateMobileLine)
+	r.POST("/mobile-lines/:id/cancel", cancelMobileLine)
+	r.POST("/leases", createLease)
+	r.GET("/leases", listLeases)
+	r.GET("/leases/:id", getLease)
+	r.PUT("/leases/:id", updateLease)
+	r.POST("/leases/:id/devices", addDevicesToLease)
+	r.POST("/leases/:id/devices/:device_id/decision", decideLeaseDevice)
+	r.POST("/leases/:id/devices/:device_id/complete", completeLeaseDevice)
+	r.POST("/calendar/feeds", createCalendarFeed)
+	r.GET("/calendar/feeds", listCalendarFeeds)
+	r.DELETE("/calendar/feeds/:id", deleteCalendarFeed)
+	r.GET("/ical/:token", serveCalendarFeed)
+	r.POST("/searches", createSavedSearch)
+	r.GET("/searches", listSavedSearches)
+	r.GET("/searches/:id", getSavedSearch)
+	r.PUT("/searches/:id", updateSavedSearch)
+	r.DELETE("/searches/:id", deleteSavedSearch)
+	r.GET("/searches/:id/run", runSavedSearch)
+	r.GET("/searches/:id/export", exportSavedSearch)
+	r.POST("/searches/:id/subscription", subscribeSavedSearch)
+	r.DELETE("/searches/:id/subscription", unsubscribeSavedSearch)
+	r.GET("/searches/:id/changes", listSavedSearchChanges)
+	r.POST("/watches", createWatch)
+	r.GET("/watches", listWatches)
+	r.DELETE("/watches/:id", deleteWatch)
+	r.GET("/notifications", listNotifications)
+	r.POST("/notifications/read-all", markAllNotificationsRead)
+	r.POST("/notifications/:id/read", markNotification(true))
+	r.POST("/notifications/:id/unread", markNotification(false))
+	r.GET("/notification-preferences", getNotificationPreference)
+	r.PUT("/notification-preferences", updateNotificationPreference)
+	r.POST("/directory/sync", runDirectorySync)
+	r.GET("/directory/status", getDirectorySyncStatus)
+	r.GET("/directory/flagged-devices", listFlaggedDevices)
+	registerSCIMRoutes(r)
+	registerAdminRoutes(r)
 
-	logger.Info("Starting server on port 8080")
-	if err := r.Run(":8080"); err != nil {
+	addr := config().Server.Addr
+	logger.Infof("Starting server on %s", addr)
+	if err := r.Run(addr); err != nil {
 		logger.Fatalf("Failed to start server: %v", err)
 	}
 }
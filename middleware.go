@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// corsMiddleware allows the configured browser origins to call the API and
+// answers their preflight requests.
+func corsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !originAllowed(config().CORS.AllowedOrigins, origin) {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, "+callerHeader)
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+func originAllowed(allowed []string, origin string) bool {
+	return containsString(allowed, "*") || containsString(allowed, origin)
+}
+
+// clientLimiter is one client's token bucket.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimits tracks a token bucket per client IP. Buckets idle for longer
+// than limiterIdle are dropped.
+var rateLimits = struct {
+	sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}{clients: map[string]*clientLimiter{}}
+
+const limiterIdle = 10 * time.Minute
+
+// allowRequest takes a token from the client's bucket, resizing the bucket
+// if the configured limit has changed since it was made.
+func allowRequest(client string, limit RateLimitConfig, now time.Time) bool {
+	rateLimits.Lock()
+	defer rateLimits.Unlock()
+
+	if now.Sub(rateLimits.lastSweep) > limiterIdle {
+		for key, cl := range rateLimits.clients {
+			if now.Sub(cl.lastSeen) > limiterIdle {
+				delete(rateLimits.clients, key)
+			}
+		}
+		rateLimits.lastSweep = now
+	}
+
+	cl, ok := rateLimits.clients[client]
+	if !ok {
+		cl = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), limit.Burst)}
+		rateLimits.clients[client] = cl
+	} else {
+		if cl.limiter.Limit() != rate.Limit(limit.RequestsPerSecond) {
+			cl.limiter.SetLimitAt(now, rate.Limit(limit.RequestsPerSecond))
+		}
+		if cl.limiter.Burst() != limit.Burst {
+			cl.limiter.SetBurstAt(now, limit.Burst)
+		}
+	}
+	cl.lastSeen = now
+	return cl.limiter.AllowN(now, 1)
+}
+
+// rateLimitMiddleware rejects clients that exceed the configured request rate.
+func rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := config().RateLimit
+		if limit.RequestsPerSecond <= 0 {
+			c.Next()
+			return
+		}
+		if !allowRequest(c.ClientIP(), limit, time.Now()) {
+			c.Header("Retry-After", "1")
+			respondWithError(c, http.StatusTooManyRequests, "Too many requests")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
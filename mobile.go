@@ -0,0 +1,677 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SIM is a SIM card. It sits in at most one device at a time.
+type SIM struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	ICCID      string     `gorm:"column:iccid;uniqueIndex" json:"iccid" binding:"required"`
+	Carrier    string     `gorm:"column:carrier" json:"carrier"`
+	DeviceID   *uint      `gorm:"column:device_id;index" json:"device_id"`
+	AttachedAt *time.Time `gorm:"column:attached_at" json:"attached_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+// MobileLine is a phone number and its contract. It reaches a device
+// through the SIM it is provisioned on.
+type MobileLine struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Number      string     `gorm:"column:number;uniqueIndex" json:"number" binding:"required"` // digits, with a leading + if international
+	Carrier     string     `gorm:"column:carrier" json:"carrier"`
+	Plan        string     `gorm:"column:plan" json:"plan"`
+	MonthlyCost uint       `gorm:"column:monthly_cost" json:"monthly_cost"`
+	SIMID       *uint      `gorm:"column:sim_id;index:idx_mobile_lines_sim_id,unique,where:sim_id IS NOT NULL" json:"sim_id"`
+	CancelledAt *time.Time `gorm:"column:cancelled_at" json:"cancelled_at"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// SIMAttachment records a SIM being in a device, with the line it carried
+// at the time.
+type SIMAttachment struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	SIMID      uint       `gorm:"column:sim_id;index" json:"sim_id"`
+	DeviceID   uint       `gorm:"column:device_id;index" json:"device_id"`
+	LineNumber string     `gorm:"column:line_number" json:"line_number"`
+	AttachedAt time.Time  `gorm:"column:attached_at" json:"attached_at"`
+	AttachedBy uint       `gorm:"column:attached_by" json:"attached_by"`
+	DetachedAt *time.Time `gorm:"column:detached_at" json:"detached_at"`
+	DetachedBy *uint      `gorm:"column:detached_by" json:"detached_by"`
+}
+
+// luhnValid reports whether a string of digits passes the Luhn check.
+func luhnValid(digits string) bool {
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// normalizeICCID strips spaces and hyphens from an ICCID and checks it is a
+// telecom (89) card number of 19 or 20 digits with a valid Luhn digit.
+func normalizeICCID(raw string) (string, error) {
+	iccid := strings.NewReplacer(" ", "", "-", "").Replace(raw)
+	if len(iccid) != 19 && len(iccid) != 20 {
+		return "", errors.New("iccid must be 19 or 20 digits")
+	}
+	for _, r := range iccid {
+		if r < '0' || r > '9' {
+			return "", errors.New("iccid must be 19 or 20 digits")
+		}
+	}
+	if !strings.HasPrefix(iccid, "89") {
+		return "", errors.New("iccid must start with 89")
+	}
+	if !luhnValid(iccid) {
+		return "", errors.New("iccid check digit is wrong")
+	}
+	return iccid, nil
+}
+
+// normalizePhoneNumber drops the punctuation people write numbers with,
+// keeping a leading + for international numbers.
+func normalizePhoneNumber(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	var b strings.Builder
+	for i, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case strings.ContainsRune(" -.()/", r):
+		default:
+			return "", fmt.Errorf("invalid phone number %q", raw)
+		}
+	}
+	number := b.String()
+	digits := len(strings.TrimPrefix(number, "+"))
+	if digits < 5 || digits > 15 {
+		return "", fmt.Errorf("invalid phone number %q", raw)
+	}
+	return number, nil
+}
+
+// isMobileDeviceType reports whether devices of a type can take a SIM.
+func isMobileDeviceType(deviceType string, mobileTypes []string) bool {
+	for _, t := range mobileTypes {
+		if strings.EqualFold(t, deviceType) {
+			return true
+		}
+	}
+	return false
+}
+
+func createSIM(c *gin.Context) {
+	var input SIM
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	iccid, err := normalizeICCID(input.ICCID)
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sim := SIM{ICCID: iccid, Carrier: strings.TrimSpace(input.Carrier)}
+	if err := db.Create(&sim).Error; err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "A SIM with this ICCID already exists")
+			return
+		}
+		logger.Errorf("Failed to create SIM: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create SIM")
+		return
+	}
+	logger.Infof("SIM created: %s", sim.ICCID)
+	c.JSON(http.StatusCreated, sim)
+}
+
+// listSIMs returns SIMs; ?unattached=true keeps those not in a device.
+func listSIMs(c *gin.Context) {
+	query := db.Order("id")
+	if c.Query("unattached") == "true" {
+		query = query.Where("device_id IS NULL")
+	}
+	var sims []SIM
+	if err := query.Find(&sims).Error; err != nil {
+		logger.Errorf("Failed to retrieve SIMs: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve SIMs")
+		return
+	}
+	c.JSON(http.StatusOK, sims)
+}
+
+func simID(c *gin.Context) (int, bool) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return 0, false
+	}
+	return idInt, true
+}
+
+// attachSIM puts a SIM into a mobile device. A SIM already in another
+// device must be detached first.
+func attachSIM(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, ok := simID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		DeviceID uint `json:"device_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var sim SIM
+	var status int
+	var message string
+	now := time.Now()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sim, idInt).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "SIM not found"
+				return nil
+			}
+			return err
+		}
+		if sim.DeviceID != nil {
+			status, message = http.StatusConflict, fmt.Sprintf("SIM is already in device %d", *sim.DeviceID)
+			return nil
+		}
+
+		var device Device
+		if err := tx.Select("id", "device_type").First(&device, input.DeviceID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Device not found"
+				return nil
+			}
+			return err
+		}
+		if !isMobileDeviceType(device.DeviceType, config().Mobile.DeviceTypes) {
+			status, message = http.StatusUnprocessableEntity, fmt.Sprintf("Device type %q cannot take a SIM", device.DeviceType)
+			return nil
+		}
+
+		var line MobileLine
+		if err := tx.Where("sim_id = ?", sim.ID).Limit(1).Find(&line).Error; err != nil {
+			return err
+		}
+		sim.DeviceID, sim.AttachedAt = &device.ID, &now
+		if err := tx.Model(&sim).Updates(map[string]interface{}{"device_id": device.ID, "attached_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&SIMAttachment{
+			SIMID: sim.ID, DeviceID: device.ID, LineNumber: line.Number, AttachedAt: now, AttachedBy: caller.ID,
+		}).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to attach SIM: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to attach SIM")
+		return
+	}
+	if status != 0 {
+		respondWithError(c, status, message)
+		return
+	}
+
+	logger.Infof("SIM %s attached to device %d by employee %d", sim.ICCID, *sim.DeviceID, caller.ID)
+	c.JSON(http.StatusOK, sim)
+}
+
+// detachSIM takes a SIM out of its device.
+func detachSIM(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, ok := simID(c)
+	if !ok {
+		return
+	}
+
+	var sim SIM
+	var status int
+	var message string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&sim, idInt).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "SIM not found"
+				return nil
+			}
+			return err
+		}
+		if sim.DeviceID == nil {
+			status, message = http.StatusConflict, "SIM is not in a device"
+			return nil
+		}
+
+		now := time.Now()
+		if err := tx.Model(&SIMAttachment{}).Where("sim_id = ? AND detached_at IS NULL", sim.ID).
+			Updates(map[string]interface{}{"detached_at": now, "detached_by": caller.ID}).Error; err != nil {
+			return err
+		}
+		sim.DeviceID, sim.AttachedAt = nil, nil
+		return tx.Model(&sim).Updates(map[string]interface{}{"device_id": nil, "attached_at": nil}).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to detach SIM: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to detach SIM")
+		return
+	}
+	if status != 0 {
+		respondWithError(c, status, message)
+		return
+	}
+
+	logger.Infof("SIM %s detached by employee %d", sim.ICCID, caller.ID)
+	c.JSON(http.StatusOK, sim)
+}
+
+// detachDeviceSIMs takes the SIMs out of devices that are being deleted,
+// closing their attachments so the history stays complete.
+func detachDeviceSIMs(tx *gorm.DB, deviceIDs []uint) error {
+	if err := tx.Model(&SIMAttachment{}).Where("device_id IN ? AND detached_at IS NULL", deviceIDs).
+		Update("detached_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tx.Model(&SIM{}).Where("device_id IN ?", deviceIDs).
+		Updates(map[string]interface{}{"device_id": nil, "attached_at": nil}).Error
+}
+
+// getSIMHistory returns the devices a SIM has been in, latest first.
+func getSIMHistory(c *gin.Context) {
+	idInt, ok := simID(c)
+	if !ok {
+		return
+	}
+	var history []SIMAttachment
+	if err := db.Where("sim_id = ?", idInt).Order("attached_at DESC, id DESC").Find(&history).Error; err != nil {
+		logger.Errorf("Failed to retrieve SIM history: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve SIM history")
+		return
+	}
+	c.JSON(http.StatusOK, history)
+}
+
+// getDeviceSIMs returns the SIMs in a device and the SIMs it has held.
+func getDeviceSIMs(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var sims []SIM
+	if err := db.Where("device_id = ?", idInt).Order("id").Find(&sims).Error; err != nil {
+		logger.Errorf("Failed to retrieve SIMs: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve SIMs")
+		return
+	}
+	var history []SIMAttachment
+	if err := db.Where("device_id = ?", idInt).Order("attached_at DESC, id DESC").Find(&history).Error; err != nil {
+		logger.Errorf("Failed to retrieve SIM history: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve SIMs")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sims": sims, "history": history})
+}
+
+// bindMobileLine reads and normalizes a line from the request body.
+func bindMobileLine(c *gin.Context) (MobileLine, bool) {
+	var input MobileLine
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	number, err := normalizePhoneNumber(input.Number)
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return input, false
+	}
+	if input.SIMID != nil {
+		var count int64
+		if err := db.Model(&SIM{}).Where("id = ?", *input.SIMID).Count(&count).Error; err != nil {
+			logger.Errorf("Failed to retrieve SIM: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to save mobile line")
+			return input, false
+		}
+		if count == 0 {
+			respondWithError(c, http.StatusBadRequest, "sim_id must be an existing SIM")
+			return input, false
+		}
+	}
+	return MobileLine{
+		Number: number, Carrier: strings.TrimSpace(input.Carrier), Plan: strings.TrimSpace(input.Plan),
+		MonthlyCost: input.MonthlyCost, SIMID: input.SIMID,
+	}, true
+}
+
+func lineSaveFailed(c *gin.Context, err error) {
+	if isUniqueViolation(err) {
+		respondWithError(c, http.StatusConflict, "The number or SIM is already used by another line")
+		return
+	}
+	logger.Errorf("Failed to save mobile line: %v", err)
+	respondWithError(c, http.StatusInternalServerError, "Failed to save mobile line")
+}
+
+func createMobileLine(c *gin.Context) {
+	line, ok := bindMobileLine(c)
+	if !ok {
+		return
+	}
+	if err := db.Create(&line).Error; err != nil {
+		lineSaveFailed(c, err)
+		return
+	}
+	logger.Infof("Mobile line created: %s", line.Number)
+	c.JSON(http.StatusCreated, line)
+}
+
+// listMobileLines returns lines; cancelled ones only with ?cancelled=true.
+func listMobileLines(c *gin.Context) {
+	query := db.Order("id")
+	if c.Query("cancelled") != "true" {
+		query = query.Where("cancelled_at IS NULL")
+	}
+	var lines []MobileLine
+	if err := query.Find(&lines).Error; err != nil {
+		logger.Errorf("Failed to retrieve mobile lines: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve mobile lines")
+		return
+	}
+	c.JSON(http.StatusOK, lines)
+}
+
+// lineSIMIDs returns the SIMs whose line changes when a line moves from
+// one SIM to another or is renumbered.
+func lineSIMIDs(before, after MobileLine) []uint {
+	if sameSIM(before.SIMID, after.SIMID) && before.Number == after.Number {
+		return nil
+	}
+	var ids []uint
+	if before.SIMID != nil {
+		ids = append(ids, *before.SIMID)
+	}
+	if after.SIMID != nil && !sameSIM(before.SIMID, after.SIMID) {
+		ids = append(ids, *after.SIMID)
+	}
+	return ids
+}
+
+func sameSIM(a, b *uint) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// reattachLineSIMs restarts the attachments of SIMs in devices whose line
+// changed, so the history shows which number each device carried when.
+func reattachLineSIMs(tx *gorm.DB, simIDs []uint, employeeID uint, now time.Time) error {
+	if len(simIDs) == 0 {
+		return nil
+	}
+	var sims []SIM
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ? AND device_id IS NOT NULL", simIDs).Order("id").Find(&sims).Error; err != nil {
+		return err
+	}
+	for _, sim := range sims {
+		if err := tx.Model(&SIMAttachment{}).Where("sim_id = ? AND detached_at IS NULL", sim.ID).
+			Updates(map[string]interface{}{"detached_at": now, "detached_by": employeeID}).Error; err != nil {
+			return err
+		}
+		var line MobileLine
+		if err := tx.Where("sim_id = ?", sim.ID).Limit(1).Find(&line).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&SIMAttachment{
+			SIMID: sim.ID, DeviceID: *sim.DeviceID, LineNumber: line.Number, AttachedAt: now, AttachedBy: employeeID,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMobileLine changes a line's contract or moves it to another SIM.
+// Cancelled lines cannot be changed.
+func updateMobileLine(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	line, ok := bindMobileLine(c)
+	if !ok {
+		return
+	}
+
+	var status int
+	var message string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var stored MobileLine
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, idInt).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				status, message = http.StatusNotFound, "Mobile line not found"
+				return nil
+			}
+			return err
+		}
+		if stored.CancelledAt != nil {
+			status, message = http.StatusConflict, "Mobile line is cancelled"
+			return nil
+		}
+		if err := tx.Model(&stored).Updates(map[string]interface{}{
+			"number": line.Number, "carrier": line.Carrier, "plan": line.Plan,
+			"monthly_cost": line.MonthlyCost, "sim_id": line.SIMID,
+		}).Error; err != nil {
+			return err
+		}
+		return reattachLineSIMs(tx, lineSIMIDs(stored, line), caller.ID, time.Now())
+	})
+	if err != nil {
+		lineSaveFailed(c, err)
+		return
+	}
+	if status != 0 {
+		respondWithError(c, status, message)
+		return
+	}
+	logger.Infof("Mobile line %d updated by employee %d", idInt, caller.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Mobile line updated successfully"})
+}
+
+// cancelMobileLine ends a line's contract and frees its SIM.
+func cancelMobileLine(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	found := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var stored MobileLine
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND cancelled_at IS NULL", idInt).Limit(1).Find(&stored)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		found = true
+		now := time.Now()
+		if err := tx.Model(&stored).Updates(map[string]interface{}{"cancelled_at": now, "sim_id": nil}).Error; err != nil {
+			return err
+		}
+		return reattachLineSIMs(tx, lineSIMIDs(stored, MobileLine{Number: stored.Number}), caller.ID, now)
+	})
+	if err != nil {
+		logger.Errorf("Failed to cancel mobile line: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to cancel mobile line")
+		return
+	}
+	if !found {
+		respondWithError(c, http.StatusNotFound, "Active mobile line not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Mobile line cancelled successfully"})
+}
+
+// employeeLine is an active line in a device assigned to an employee.
+type employeeLine struct {
+	EmployeeID  *uint  `json:"-"`
+	FirstName   string `json:"-"`
+	LastName    string `json:"-"`
+	Department  string `json:"-"`
+	LineID      uint   `json:"line_id"`
+	Number      string `json:"number"`
+	Carrier     string `json:"carrier"`
+	Plan        string `json:"plan"`
+	MonthlyCost uint   `json:"monthly_cost"`
+	DeviceID    *uint  `json:"device_id"`
+}
+
+// employeeMobileCost totals the lines used by one employee. Lines whose
+// SIM is not in an assigned device are grouped with no employee.
+type employeeMobileCost struct {
+	EmployeeID  *uint          `json:"employee_id"`
+	Name        string         `json:"name"`
+	Department  string         `json:"department"`
+	Lines       []employeeLine `json:"lines"`
+	MonthlyCost uint           `json:"monthly_cost"`
+}
+
+func groupMobileCosts(lines []employeeLine) []employeeMobileCost {
+	byEmployee := map[uint]*employeeMobileCost{}
+	var unassigned *employeeMobileCost
+	for _, l := range lines {
+		var cost *employeeMobileCost
+		if l.EmployeeID == nil {
+			if unassigned == nil {
+				unassigned = &employeeMobileCost{}
+			}
+			cost = unassigned
+		} else if cost = byEmployee[*l.EmployeeID]; cost == nil {
+			cost = &employeeMobileCost{
+				EmployeeID: l.EmployeeID,
+				Name:       strings.TrimSpace(l.FirstName + " " + l.LastName),
+				Department: l.Department,
+			}
+			byEmployee[*l.EmployeeID] = cost
+		}
+		cost.Lines = append(cost.Lines, l)
+		cost.MonthlyCost += l.MonthlyCost
+	}
+
+	result := make([]employeeMobileCost, 0, len(byEmployee)+1)
+	for _, cost := range byEmployee {
+		result = append(result, *cost)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MonthlyCost != result[j].MonthlyCost {
+			return result[i].MonthlyCost > result[j].MonthlyCost
+		}
+		return *result[i].EmployeeID < *result[j].EmployeeID
+	})
+	if unassigned != nil {
+		result = append(result, *unassigned)
+	}
+	return result
+}
+
+// mobileLineCosts loads active lines with the employee whose device holds
+// their SIM, if any.
+func mobileLineCosts(query *gorm.DB) ([]employeeLine, error) {
+	var lines []employeeLine
+	err := query.Table("mobile_lines").
+		Select("employees.id AS employee_id, employees.first_name, employees.last_name, employees.department, " +
+			"mobile_lines.id AS line_id, mobile_lines.number, mobile_lines.carrier, mobile_lines.plan, " +
+			"mobile_lines.monthly_cost, sims.device_id").
+		Joins("LEFT JOIN sims ON sims.id = mobile_lines.sim_id").
+		Joins("LEFT JOIN devices ON devices.id = sims.device_id").
+		Joins("LEFT JOIN employees ON employees.id = devices.assigned_to").
+		Where("mobile_lines.cancelled_at IS NULL").
+		Order("mobile_lines.id").
+		Scan(&lines).Error
+	return lines, err
+}
+
+// getMobileCostReport totals the monthly cost of active lines per employee,
+// by who the device holding each line's SIM is assigned to.
+func getMobileCostReport(c *gin.Context) {
+	lines, err := mobileLineCosts(db)
+	if err != nil {
+		logger.Errorf("Failed to retrieve mobile lines: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to build mobile cost report")
+		return
+	}
+	var total uint
+	for _, l := range lines {
+		total += l.MonthlyCost
+	}
+	c.JSON(http.StatusOK, gin.H{"employees": groupMobileCosts(lines), "total_monthly_cost": total})
+}
+
+// listEmployeeMobileLines returns the active lines in an employee's devices.
+func listEmployeeMobileLines(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	lines, err := mobileLineCosts(db.Where("devices.assigned_to = ?", idInt))
+	if err != nil {
+		logger.Errorf("Failed to retrieve mobile lines: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve mobile lines")
+		return
+	}
+	var total uint
+	for _, l := range lines {
+		total += l.MonthlyCost
+	}
+	c.JSON(http.StatusOK, gin.H{"lines": lines, "monthly_cost": total})
+}
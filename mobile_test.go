@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test ICCID normalization and check digit validation
+func TestNormalizeICCID(t *testing.T) {
+	iccid, err := normalizeICCID("8944 5001 0219 8304 826")
+	assert.NoError(t, err)
+	assert.Equal(t, "8944500102198304826", iccid)
+
+	iccid, err = normalizeICCID("89012600123456789018")
+	assert.NoError(t, err)
+	assert.Equal(t, "89012600123456789018", iccid)
+
+	_, err = normalizeICCID("8944500102198304827")
+	assert.EqualError(t, err, "iccid check digit is wrong")
+	_, err = normalizeICCID("894450010219830482")
+	assert.Error(t, err, "too short")
+	_, err = normalizeICCID("7944500102198304826")
+	assert.Error(t, err, "not a telecom card")
+	_, err = normalizeICCID("89445001021983048F6")
+	assert.Error(t, err)
+}
+
+// Test phone number normalization
+func TestNormalizePhoneNumber(t *testing.T) {
+	number, err := normalizePhoneNumber(" +44 (20) 7946-0958 ")
+	assert.NoError(t, err)
+	assert.Equal(t, "+442079460958", number)
+
+	number, err = normalizePhoneNumber("555.0100")
+	assert.NoError(t, err)
+	assert.Equal(t, "5550100", number)
+
+	for _, raw := range []string{"", "12", "44+2079460958", "call me", "+1234567890123456"} {
+		_, err := normalizePhoneNumber(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+// Test which device types can take a SIM
+func TestIsMobileDeviceType(t *testing.T) {
+	types := []string{"Phone", "Tablet"}
+	assert.True(t, isMobileDeviceType("phone", types))
+	assert.False(t, isMobileDeviceType("Laptop", types))
+}
+
+// Test which SIMs need new attachments when a line changes
+func TestLineSIMIDs(t *testing.T) {
+	one, two := uint(1), uint(2)
+	line := MobileLine{Number: "5550100", SIMID: &one}
+	assert.Empty(t, lineSIMIDs(line, MobileLine{Number: "5550100", SIMID: &one}))
+	assert.Equal(t, []uint{1, 2}, lineSIMIDs(line, MobileLine{Number: "5550100", SIMID: &two}))
+	assert.Equal(t, []uint{1}, lineSIMIDs(line, MobileLine{Number: "5550100"}))
+	assert.Equal(t, []uint{1}, lineSIMIDs(line, MobileLine{Number: "5550199", SIMID: &one}))
+	assert.Equal(t, []uint{2}, lineSIMIDs(MobileLine{Number: "5550100"}, MobileLine{Number: "5550100", SIMID: &two}))
+}
+
+// Test totalling line costs per employee
+func TestGroupMobileCosts(t *testing.T) {
+	alice, bob := uint(1), uint(2)
+	costs := groupMobileCosts([]employeeLine{
+		{EmployeeID: &alice, FirstName: "Alice", LastName: "Smith", LineID: 1, MonthlyCost: 20},
+		{LineID: 2, MonthlyCost: 15},
+		{EmployeeID: &bob, FirstName: "Bob", LineID: 3, MonthlyCost: 30},
+		{EmployeeID: &alice, FirstName: "Alice", LastName: "Smith", LineID: 4, MonthlyCost: 25},
+	})
+
+	assert.Len(t, costs, 3)
+	assert.Equal(t, "Alice Smith", costs[0].Name)
+	assert.Equal(t, uint(45), costs[0].MonthlyCost)
+	assert.Len(t, costs[0].Lines, 2)
+	assert.Equal(t, "Bob", costs[1].Name)
+	assert.Nil(t, costs[2].EmployeeID, "lines outside assigned devices come last")
+	assert.Equal(t, uint(15), costs[2].MonthlyCost)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// NetworkInterface is a network adapter of a device. MACs are unique; so
+// are IPs, when one is assigned.
+type NetworkInterface struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	DeviceID  uint      `gorm:"column:device_id;index" json:"device_id"`
+	Name      string    `gorm:"column:name" json:"name"` // e.g. "eth0" or "wifi"
+	MAC       string    `gorm:"column:mac;uniqueIndex" json:"mac" binding:"required"`
+	IP        string    `gorm:"column:ip;index:idx_network_interfaces_ip,unique,where:ip <> ''" json:"ip"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// normalizeMAC accepts a 48-bit MAC written with colons, hyphens, Cisco
+// dots or no separators and returns it as lowercase colon pairs. Group and
+// all-zero addresses cannot belong to an interface and are rejected.
+func normalizeMAC(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	var hw net.HardwareAddr
+	if len(raw) == 12 {
+		b, err := hex.DecodeString(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid MAC address %q", raw)
+		}
+		hw = b
+	} else {
+		b, err := net.ParseMAC(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid MAC address %q", raw)
+		}
+		hw = b
+	}
+	if len(hw) != 6 {
+		return "", fmt.Errorf("MAC address %q is not 48 bits", raw)
+	}
+	if hw[0]&1 == 1 {
+		return "", fmt.Errorf("MAC address %q is a multicast or broadcast address", raw)
+	}
+	if hw.String() == "00:00:00:00:00:00" {
+		return "", fmt.Errorf("MAC address %q is all zeros", raw)
+	}
+	return hw.String(), nil
+}
+
+// normalizeIP returns an IPv4 or IPv6 address in canonical form. IPv4
+// addresses mapped into IPv6 are unmapped so both spellings collide.
+func normalizeIP(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil || addr.Zone() != "" {
+		return "", fmt.Errorf("invalid IP address %q", raw)
+	}
+	addr = addr.Unmap()
+	if addr.IsUnspecified() || addr.IsMulticast() {
+		return "", fmt.Errorf("IP address %q cannot be assigned to an interface", raw)
+	}
+	return addr.String(), nil
+}
+
+func (n *NetworkInterface) normalize() error {
+	mac, err := normalizeMAC(n.MAC)
+	if err != nil {
+		return err
+	}
+	ip, err := normalizeIP(n.IP)
+	if err != nil {
+		return err
+	}
+	n.MAC, n.IP, n.Name = mac, ip, strings.TrimSpace(n.Name)
+	return nil
+}
+
+// addressConflict is another interface already holding a MAC or IP.
+type addressConflict struct {
+	Field       string `json:"field"` // "mac" or "ip"
+	Value       string `json:"value"`
+	InterfaceID uint   `json:"interface_id"`
+	DeviceID    uint   `json:"device_id"`
+}
+
+// findAddressConflicts returns the interfaces other than n that hold its
+// MAC or IP.
+func findAddressConflicts(tx *gorm.DB, n NetworkInterface) ([]addressConflict, error) {
+	query := tx.Where("id <> ?", n.ID)
+	if n.IP != "" {
+		query = query.Where(tx.Where("mac = ?", n.MAC).Or("ip = ?", n.IP))
+	} else {
+		query = query.Where("mac = ?", n.MAC)
+	}
+	var existing []NetworkInterface
+	if err := query.Find(&existing).Error; err != nil {
+		return nil, err
+	}
+
+	conflicts := []addressConflict{}
+	for _, e := range existing {
+		if e.MAC == n.MAC {
+			conflicts = append(conflicts, addressConflict{Field: "mac", Value: e.MAC, InterfaceID: e.ID, DeviceID: e.DeviceID})
+		}
+		if n.IP != "" && e.IP == n.IP {
+			conflicts = append(conflicts, addressConflict{Field: "ip", Value: e.IP, InterfaceID: e.ID, DeviceID: e.DeviceID})
+		}
+	}
+	return conflicts, nil
+}
+
+// saveInterface validates and stores an interface, responding with 409 and
+// the conflicting interfaces when its MAC or IP is taken.
+func saveInterface(c *gin.Context, n *NetworkInterface) bool {
+	if err := n.normalize(); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	var conflicts []addressConflict
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if conflicts, err = findAddressConflicts(tx, *n); err != nil || len(conflicts) > 0 {
+			return err
+		}
+		return tx.Save(n).Error
+	})
+	if err != nil && isUniqueViolation(err) {
+		// Lost a race with another request; report what it stored.
+		conflicts, err = findAddressConflicts(db, *n)
+	}
+	if err != nil {
+		logger.Errorf("Failed to save network interface: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to save network interface")
+		return false
+	}
+	if len(conflicts) > 0 {
+		logger.Warnf("Address conflict for device %d interface %s: %v", n.DeviceID, n.MAC, conflicts)
+		c.JSON(http.StatusConflict, gin.H{"error": "MAC or IP address already in use", "conflicts": conflicts})
+		return false
+	}
+	return true
+}
+
+func createInterface(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var input NetworkInterface
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var device Device
+	if err := db.Select("id").First(&device, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Device not found")
+		} else {
+			logger.Errorf("Failed to retrieve device: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to add network interface")
+		}
+		return
+	}
+
+	n := NetworkInterface{DeviceID: device.ID, Name: input.Name, MAC: input.MAC, IP: input.IP}
+	if !saveInterface(c, &n) {
+		return
+	}
+	logger.Infof("Network interface %s added to device %d", n.MAC, n.DeviceID)
+	c.JSON(http.StatusCreated, n)
+}
+
+func listDeviceInterfaces(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var interfaces []NetworkInterface
+	if err := db.Where("device_id = ?", idInt).Order("id").Find(&interfaces).Error; err != nil {
+		logger.Errorf("Failed to retrieve network interfaces: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve network interfaces")
+		return
+	}
+	c.JSON(http.StatusOK, interfaces)
+}
+
+func findInterface(c *gin.Context) (NetworkInterface, bool) {
+	var n NetworkInterface
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return n, false
+	}
+	if err := db.First(&n, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Network interface not found")
+		} else {
+			logger.Errorf("Failed to retrieve network interface: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve network interface")
+		}
+		return n, false
+	}
+	return n, true
+}
+
+func updateInterface(c *gin.Context) {
+	n, ok := findInterface(c)
+	if !ok {
+		return
+	}
+	var input NetworkInterface
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	n.Name, n.MAC, n.IP = input.Name, input.MAC, input.IP
+	if !saveInterface(c, &n) {
+		return
+	}
+	c.JSON(http.StatusOK, n)
+}
+
+func deleteInterface(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	result := db.Delete(&NetworkInterface{}, idInt)
+	if result.Error != nil {
+		logger.Errorf("Failed to delete network interface: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete network interface")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Network interface not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Network interface deleted successfully"})
+}
+
+// lookupInterface finds the device behind ?mac= or ?ip=, in any of the
+// accepted spellings.
+func lookupInterface(c *gin.Context) {
+	query := db.Model(&NetworkInterface{})
+	switch {
+	case c.Query("mac") != "":
+		mac, err := normalizeMAC(c.Query("mac"))
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		query = query.Where("mac = ?", mac)
+	case c.Query("ip") != "":
+		ip, err := normalizeIP(c.Query("ip"))
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		query = query.Where("ip = ?", ip)
+	default:
+		respondWithError(c, http.StatusBadRequest, "mac or ip is required")
+		return
+	}
+
+	var n NetworkInterface
+	if err := query.First(&n).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "No interface with that address")
+		} else {
+			logger.Errorf("Failed to look up network interface: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to look up network interface")
+		}
+		return
+	}
+	var device Device
+	if err := db.First(&device, n.DeviceID).Error; err != nil {
+		logger.Errorf("Failed to retrieve device: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to look up network interface")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"interface": n, "device": device})
+}
+
+// Export formats for network interfaces.
+const (
+	exportDnsmasq   = "dnsmasq"   // dhcp-host lines
+	exportISC       = "isc"       // ISC dhcpd host blocks
+	exportAllowlist = "allowlist" // one address per line
+	exportCSV       = "csv"
+)
+
+// exportedInterface is an interface with its device's hostname.
+type exportedInterface struct {
+	NetworkInterface
+	DeviceName string
+}
+
+// hostLabel turns a device name into a DNS label usable as a DHCP
+// hostname, falling back to the device ID.
+func hostLabel(name string, deviceID uint) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	label := strings.TrimRight(b.String(), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	if label == "" {
+		label = "device-" + strconv.Itoa(int(deviceID))
+	}
+	return label
+}
+
+// formatDHCPReservations writes reservations for interfaces with an IP in
+// dnsmasq or ISC dhcpd syntax.
+func formatDHCPReservations(format string, interfaces []exportedInterface) string {
+	var b strings.Builder
+	for _, n := range interfaces {
+		if n.IP == "" {
+			continue
+		}
+		host := hostLabel(n.DeviceName, n.DeviceID)
+		switch format {
+		case exportDnsmasq:
+			fmt.Fprintf(&b, "dhcp-host=%s,%s,%s\n", n.MAC, n.IP, host)
+		case exportISC:
+			fmt.Fprintf(&b, "host %s-%d {\n  hardware ethernet %s;\n  fixed-address %s;\n  option host-name \"%s\";\n}\n",
+				host, n.ID, n.MAC, n.IP, host)
+		}
+	}
+	return b.String()
+}
+
+// exportInterfaces writes the interfaces of devices that are not retired
+// in the ?format= a DHCP server or firewall can load: dnsmasq, isc,
+// allowlist (IPs, or MACs with ?by=mac) or csv.
+func exportInterfaces(c *gin.Context) {
+	format := c.DefaultQuery("format", exportCSV)
+	by := c.DefaultQuery("by", "ip")
+	switch format {
+	case exportDnsmasq, exportISC, exportAllowlist, exportCSV:
+	default:
+		respondWithError(c, http.StatusBadRequest, "format must be dnsmasq, isc, allowlist or csv")
+		return
+	}
+	if by != "ip" && by != "mac" {
+		respondWithError(c, http.StatusBadRequest, "by must be ip or mac")
+		return
+	}
+
+	var interfaces []exportedInterface
+	if err := db.Table("network_interfaces").
+		Select("network_interfaces.*, devices.device_name").
+		Joins("JOIN devices ON devices.id = network_interfaces.device_id").
+		Where("devices.status NOT IN ?", config().Retention.TerminalStatuses).
+		Order("network_interfaces.device_id, network_interfaces.id").
+		Scan(&interfaces).Error; err != nil {
+		logger.Errorf("Failed to retrieve network interfaces: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to export network interfaces")
+		return
+	}
+
+	switch format {
+	case exportDnsmasq, exportISC:
+		c.String(http.StatusOK, formatDHCPReservations(format, interfaces))
+	case exportAllowlist:
+		var b strings.Builder
+		for _, n := range interfaces {
+			address := n.IP
+			if by == "mac" {
+				address = n.MAC
+			}
+			if address != "" {
+				b.WriteString(address + "\n")
+			}
+		}
+		c.String(http.StatusOK, b.String())
+	case exportCSV:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "network-interfaces.csv"))
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{"device_id", "device_name", "interface", "mac", "ip"})
+		for _, n := range interfaces {
+			w.Write([]string{strconv.Itoa(int(n.DeviceID)), n.DeviceName, n.Name, n.MAC, n.IP})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			logger.Errorf("Failed to write network interface export: %v", err)
+		}
+	}
+}
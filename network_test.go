@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that the accepted MAC spellings normalize to one form
+func TestNormalizeMAC(t *testing.T) {
+	for _, raw := range []string{"AA:BB:CC:00:11:22", "aa-bb-cc-00-11-22", "aabb.cc00.1122", "AABBCC001122", " aa:bb:cc:00:11:22 "} {
+		mac, err := normalizeMAC(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, "aa:bb:cc:00:11:22", mac, raw)
+	}
+
+	for _, raw := range []string{"", "aa:bb:cc:00:11", "zzbbcc001122", "ff:ff:ff:ff:ff:ff", "01:00:5e:00:00:01", "00:00:00:00:00:00",
+		"00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"} {
+		_, err := normalizeMAC(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+// Test IP validation and canonical form
+func TestNormalizeIP(t *testing.T) {
+	ip, err := normalizeIP("10.0.0.7")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.7", ip)
+
+	ip, err = normalizeIP("::ffff:10.0.0.7")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.7", ip, "mapped IPv4 is unmapped")
+
+	ip, err = normalizeIP("2001:DB8:0:0::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", ip)
+
+	ip, err = normalizeIP("")
+	assert.NoError(t, err)
+	assert.Equal(t, "", ip)
+
+	for _, raw := range []string{"10.0.0", "0.0.0.0", "224.0.0.1", "fe80::1%eth0", "host.example"} {
+		_, err := normalizeIP(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+// Test deriving DHCP hostnames from device names
+func TestHostLabel(t *testing.T) {
+	assert.Equal(t, "alice-s-macbook-pro", hostLabel("Alice's MacBook Pro", 1))
+	assert.Equal(t, "printer-2f", hostLabel("  Printer (2F) ", 2))
+	assert.Equal(t, "device-3", hostLabel("***", 3))
+}
+
+// Test the DHCP reservation formats
+func TestFormatDHCPReservations(t *testing.T) {
+	interfaces := []exportedInterface{
+		{NetworkInterface: NetworkInterface{ID: 4, DeviceID: 1, MAC: "aa:bb:cc:00:11:22", IP: "10.0.0.7"}, DeviceName: "Lab PC"},
+		{NetworkInterface: NetworkInterface{ID: 5, DeviceID: 1, MAC: "aa:bb:cc:00:11:23"}, DeviceName: "Lab PC"},
+	}
+
+	assert.Equal(t, "dhcp-host=aa:bb:cc:00:11:22,10.0.0.7,lab-pc\n", formatDHCPReservations(exportDnsmasq, interfaces))
+	assert.Equal(t, "host lab-pc-4 {\n  hardware ethernet aa:bb:cc:00:11:22;\n  fixed-address 10.0.0.7;\n  option host-name \"lab-pc\";\n}\n",
+		formatDHCPReservations(exportISC, interfaces))
+}
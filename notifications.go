@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Event types employees can be notified about.
+const (
+	eventDeviceStatusChanged  = "device.status_changed"
+	eventOffboardingReminder  = "offboarding.reminder"
+	eventOffboardingEscalated = "offboarding.escalated"
+	eventStockLow             = "stock.low"
+	eventSearchChanged        = "search.changed"
+	eventLeaseEnding          = "lease.ending"
+)
+
+var notificationEventTypes = []string{
+	eventDeviceStatusChanged, eventOffboardingReminder, eventOffboardingEscalated, eventStockLow, eventSearchChanged,
+	eventLeaseEnding,
+}
+
+// Watch kinds.
+const (
+	watchDevice = "device" // events about one device
+	watchSearch = "search" // changes to a saved search's results
+	watchEvent  = "event"  // every event of one type
+)
+
+// Delivery frequencies.
+const (
+	frequencyImmediate = "immediate"
+	frequencyDigest    = "digest"
+)
+
+// Channels notifications are sent on besides the in-app inbox, which always
+// receives them.
+const (
+	channelEmail   = "email"
+	channelWebhook = "webhook"
+)
+
+// Watch subscribes an employee to a device, a saved search or an event type.
+type Watch struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	EmployeeID uint      `gorm:"column:employee_id;uniqueIndex:idx_watch" json:"employee_id"`
+	Kind       string    `gorm:"column:kind;uniqueIndex:idx_watch;index:idx_watch_target" json:"kind"`
+	TargetID   uint      `gorm:"column:target_id;uniqueIndex:idx_watch;index:idx_watch_target" json:"target_id"` // device or search ID
+	EventType  string    `gorm:"column:event_type;uniqueIndex:idx_watch" json:"event_type"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// NotificationPreference is how and how often an employee wants to be told.
+type NotificationPreference struct {
+	EmployeeID   uint       `gorm:"primaryKey;autoIncrement:false" json:"employee_id"`
+	Channels     string     `gorm:"column:channels" json:"channels"` // comma-separated: email,webhook
+	Frequency    string     `gorm:"column:frequency" json:"frequency"`
+	Email        string     `gorm:"column:email" json:"email"` // overrides the directory address
+	WebhookURL   string     `gorm:"column:webhook_url" json:"webhook_url"`
+	LastDigestAt *time.Time `gorm:"column:last_digest_at" json:"last_digest_at"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// Notification is one entry in an employee's inbox. DedupKey makes the same
+// event reach an employee only once however often it is raised.
+type Notification struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	EmployeeID  uint       `gorm:"column:employee_id;uniqueIndex:idx_notification_dedup;index:idx_notification_inbox" json:"employee_id"`
+	DedupKey    string     `gorm:"column:dedup_key;uniqueIndex:idx_notification_dedup" json:"-"`
+	EventType   string     `gorm:"column:event_type" json:"event_type"`
+	Subject     string     `gorm:"column:subject" json:"subject"`
+	Body        string     `gorm:"column:body" json:"body"`
+	DeviceID    *uint      `gorm:"column:device_id" json:"device_id"`
+	SearchID    *uint      `gorm:"column:search_id" json:"search_id"`
+	ReadAt      *time.Time `gorm:"column:read_at" json:"read_at"`
+	DeliveredAt *time.Time `gorm:"column:delivered_at;index" json:"delivered_at"` // sent on the external channels
+	ClaimedAt   *time.Time `gorm:"column:claimed_at" json:"-"`                    // being sent by a delivery run
+	CreatedAt   time.Time  `gorm:"column:created_at;index:idx_notification_inbox" json:"created_at"`
+}
+
+// notificationEvent is something that happened. It goes to the explicit
+// recipients and to everyone watching its type, device or search.
+type notificationEvent struct {
+	Type       string
+	Subject    string
+	Body       string
+	DeviceID   *uint
+	SearchID   *uint
+	Key        string // identifies the occurrence for de-duplication
+	Recipients []uint
+}
+
+// notify puts an event in the inbox of everyone it concerns. External
+// delivery happens in the background.
+func notify(tx *gorm.DB, ev notificationEvent) error {
+	recipients := append([]uint{}, ev.Recipients...)
+
+	query := tx.Model(&Watch{}).Where("kind = ? AND event_type = ?", watchEvent, ev.Type)
+	if ev.DeviceID != nil {
+		query = query.Or("kind = ? AND target_id = ?", watchDevice, *ev.DeviceID)
+	}
+	if ev.SearchID != nil {
+		query = query.Or("kind = ? AND target_id = ?", watchSearch, *ev.SearchID)
+	}
+	var watchers []uint
+	if err := query.Pluck("employee_id", &watchers).Error; err != nil {
+		return err
+	}
+	recipients = uniqueUints(append(recipients, watchers...))
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	key := ev.Key
+	if key == "" {
+		key = ev.Type + ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	notes := make([]Notification, len(recipients))
+	for i, employeeID := range recipients {
+		notes[i] = Notification{
+			EmployeeID: employeeID,
+			DedupKey:   key,
+			EventType:  ev.Type,
+			Subject:    ev.Subject,
+			Body:       ev.Body,
+			DeviceID:   ev.DeviceID,
+			SearchID:   ev.SearchID,
+		}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&notes).Error
+}
+
+// notifyLogged is notify for callers that carry on whether or not it works.
+func notifyLogged(tx *gorm.DB, ev notificationEvent) {
+	if err := notify(tx, ev); err != nil {
+		logger.Errorf("Failed to record %s notification: %v", ev.Type, err)
+	}
+}
+
+func splitChannels(channels string) []string {
+	var list []string
+	for _, ch := range strings.Split(channels, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			list = append(list, ch)
+		}
+	}
+	return list
+}
+
+// digestDue reports whether a digest may be sent, given when the last went out.
+func digestDue(last *time.Time, every time.Duration, now time.Time) bool {
+	return last == nil || !now.Before(last.Add(every))
+}
+
+// composeMessage turns pending notifications into one message: the
+// notification itself when there is one, otherwise a digest.
+func composeMessage(notes []Notification) (subject, body string) {
+	if len(notes) == 1 {
+		return notes[0].Subject, notes[0].Body
+	}
+	var b strings.Builder
+	for _, n := range notes {
+		fmt.Fprintf(&b, "- %s (%s)\n", n.Subject, n.CreatedAt.Format("2006-01-02 15:04"))
+		if n.Body != "" {
+			fmt.Fprintf(&b, "  %s\n", strings.ReplaceAll(n.Body, "\n", "\n  "))
+		}
+	}
+	return fmt.Sprintf("%d device notifications", len(notes)), b.String()
+}
+
+// buildEmail formats a plain text email.
+func buildEmail(from, to, subject, body string, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", strings.NewReplacer("\r", "", "\n", " ").Replace(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// signWebhook returns the value of the X-Signature header for a payload.
+func signWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendNotificationEmail(to string, notes []Notification) error {
+	smtpConfig := config().Notifications.SMTP
+	if smtpConfig.Addr == "" {
+		return errors.New("SMTP is not configured")
+	}
+	if to == "" {
+		return errors.New("no email address")
+	}
+	subject, body := composeMessage(notes)
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		host := smtpConfig.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", smtpConfig.Username, smtpConfig.Password, host)
+	}
+	return smtp.SendMail(smtpConfig.Addr, auth, smtpConfig.From, []string{to},
+		buildEmail(smtpConfig.From, to, subject, body, time.Now()))
+}
+
+func sendNotificationWebhook(ctx context.Context, url string, employeeID uint, notes []Notification) error {
+	if url == "" {
+		return errors.New("no webhook URL")
+	}
+	payload, err := json.Marshal(gin.H{"employee_id": employeeID, "notifications": notes})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config().Notifications.WebhookTimeout.Duration)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := config().Notifications.WebhookSecret; secret != "" {
+		req.Header.Set("X-Signature", signWebhook(secret, payload))
+	}
+	resp, err := webhookClient(req.URL.Hostname()).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// validateWebhookURL accepts http(s) URLs whose host is not obviously
+// internal. The dialer checks the resolved address again when sending, since
+// a public name can resolve to a private address.
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return errors.New("webhook channel needs an http(s) webhook_url")
+	}
+	host := u.Hostname()
+	if webhookHostAllowed(host) {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && internalIP(ip) {
+		return errors.New("webhook_url must not point at an internal address")
+	}
+	if host = strings.ToLower(strings.TrimSuffix(host, ".")); host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("webhook_url must not point at an internal address")
+	}
+	return nil
+}
+
+// webhookHostAllowed reports whether the configuration lets webhooks reach
+// host even when it resolves to an internal address.
+func webhookHostAllowed(host string) bool {
+	for _, allowed := range config().Notifications.WebhookAllowedHosts {
+		if strings.EqualFold(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// internalIP reports whether ip is loopback, private, link-local (which
+// includes cloud metadata services), multicast or unspecified.
+func internalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// webhookClient returns the client webhooks to host are posted with. Unless
+// host is allowed by configuration it refuses to connect to internal
+// addresses, and it never follows redirects, which could lead there instead.
+func webhookClient(host string) *http.Client {
+	dialer := &net.Dialer{}
+	if !webhookHostAllowed(host) {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			ip, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if parsed := net.ParseIP(ip); parsed == nil || internalIP(parsed) {
+				return fmt.Errorf("webhook target %s is an internal address", ip)
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// deliverNotifications sends pending notifications on each employee's
+// external channels, immediately or as a digest. An employee's batch is
+// retried later only if every channel failed, so a working channel does not
+// get duplicates.
+func deliverNotifications(ctx context.Context) error {
+	var employeeIDs []uint
+	if err := db.WithContext(ctx).Model(&Notification{}).Where("delivered_at IS NULL").
+		Distinct().Pluck("employee_id", &employeeIDs).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, employeeID := range employeeIDs {
+		if err := deliverTo(ctx, employeeID, now); err != nil {
+			logger.Errorf("Failed to deliver notifications to employee %d: %v", employeeID, err)
+		}
+	}
+	return nil
+}
+
+// notificationClaimTTL is how long a delivery run owns the notifications it
+// claimed. Claims left behind by a run that died mid-send expire after it.
+const notificationClaimTTL = 15 * time.Minute
+
+// deliverTo claims an employee's pending notifications in a short
+// transaction and sends them after it commits, so no row locks are held
+// while mail servers and webhooks answer. The claim keeps other runs off the
+// batch; it is released for a retry if every channel fails.
+func deliverTo(ctx context.Context, employeeID uint, now time.Time) error {
+	pref := NotificationPreference{EmployeeID: employeeID, Frequency: frequencyImmediate}
+	if err := db.WithContext(ctx).Where("employee_id = ?", employeeID).Limit(1).Find(&pref).Error; err != nil {
+		return err
+	}
+	channels := splitChannels(pref.Channels)
+	digest := pref.Frequency == frequencyDigest
+	if len(channels) > 0 && digest && !digestDue(pref.LastDigestAt, config().Notifications.DigestEvery.Duration, now) {
+		return nil
+	}
+
+	var notes []Notification
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("employee_id = ? AND delivered_at IS NULL", employeeID).
+			Where("claimed_at IS NULL OR claimed_at < ?", now.Add(-notificationClaimTTL)).
+			Order("created_at").Find(&notes).Error; err != nil {
+			return err
+		}
+		if len(notes) == 0 {
+			return nil
+		}
+		// Without external channels the inbox is all there is, so the
+		// notifications are delivered as they stand.
+		column := "claimed_at"
+		if len(channels) == 0 {
+			column = "delivered_at"
+		}
+		return tx.Model(&Notification{}).Where("id IN ?", notificationIDs(notes)).Update(column, now).Error
+	})
+	if err != nil || len(notes) == 0 || len(channels) == 0 {
+		return err
+	}
+	ids := notificationIDs(notes)
+
+	var employee Employee
+	if err := db.WithContext(ctx).First(&employee, employeeID).Error; err != nil {
+		releaseNotifications(ctx, ids)
+		return err
+	}
+	sent := 0
+	for _, ch := range channels {
+		var err error
+		switch ch {
+		case channelEmail:
+			to := pref.Email
+			if to == "" {
+				to = employee.Email
+			}
+			err = sendNotificationEmail(to, notes)
+		case channelWebhook:
+			err = sendNotificationWebhook(ctx, pref.WebhookURL, employeeID, notes)
+		default:
+			err = fmt.Errorf("unknown channel %q", ch)
+		}
+		if err != nil {
+			logger.Warnf("Failed to send notifications to employee %d by %s: %v", employeeID, ch, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		releaseNotifications(ctx, ids)
+		return errors.New("no channel succeeded")
+	}
+
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if digest {
+			if err := tx.Model(&NotificationPreference{}).Where("employee_id = ?", employeeID).
+				Update("last_digest_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Notification{}).Where("id IN ?", ids).Update("delivered_at", now).Error
+	})
+}
+
+// releaseNotifications drops a delivery run's claim so the next run retries.
+func releaseNotifications(ctx context.Context, ids []uint) {
+	if err := db.WithContext(ctx).Model(&Notification{}).Where("id IN ?", ids).
+		Update("claimed_at", nil).Error; err != nil {
+		logger.Errorf("Failed to release notifications: %v", err)
+	}
+}
+
+func notificationIDs(notes []Notification) []uint {
+	ids := make([]uint, len(notes))
+	for i, n := range notes {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+func startNotificationJob() {
+	startJob("notifications", config().Notifications.DeliveryInterval.Duration, deliverNotifications)
+}
+
+// validateWatch checks a watch's target exists and, for saved searches, that
+// the employee can see it. It returns the status code to respond with.
+func validateWatch(tx *gorm.DB, employeeID uint, w Watch) (int, error) {
+	switch w.Kind {
+	case watchDevice:
+		if w.TargetID == 0 || w.EventType != "" {
+			return http.StatusBadRequest, errors.New("device watches need target_id and no event_type")
+		}
+		var count int64
+		if err := tx.Model(&Device{}).Where("id = ?", w.TargetID).Count(&count).Error; err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if count == 0 {
+			return http.StatusNotFound, errors.New("Device not found")
+		}
+	case watchSearch:
+		if w.TargetID == 0 || w.EventType != "" {
+			return http.StatusBadRequest, errors.New("search watches need target_id and no event_type")
+		}
+		query, err := visibleSearches(tx.Model(&SavedSearch{}), employeeID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		var count int64
+		if err := query.Where("id = ?", w.TargetID).Count(&count).Error; err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if count == 0 {
+			return http.StatusNotFound, errors.New("Saved search not found")
+		}
+	case watchEvent:
+		if w.TargetID != 0 || !containsString(notificationEventTypes, w.EventType) {
+			return http.StatusBadRequest, errors.New("event watches need an event_type of " + strings.Join(notificationEventTypes, ", "))
+		}
+	default:
+		return http.StatusBadRequest, errors.New("kind must be device, search or event")
+	}
+	return 0, nil
+}
+
+func createWatch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Kind      string `json:"kind" binding:"required"`
+		TargetID  uint   `json:"target_id"`
+		EventType string `json:"event_type"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	watch := Watch{EmployeeID: caller.ID, Kind: input.Kind, TargetID: input.TargetID, EventType: input.EventType}
+	if code, err := validateWatch(db, caller.ID, watch); err != nil {
+		if code == http.StatusInternalServerError {
+			logger.Errorf("Failed to create watch: %v", err)
+			respondWithError(c, code, "Failed to create watch")
+		} else {
+			respondWithError(c, code, err.Error())
+		}
+		return
+	}
+
+	if err := db.Create(&watch).Error; err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "Already watching")
+			return
+		}
+		logger.Errorf("Failed to create watch: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create watch")
+		return
+	}
+	c.JSON(http.StatusCreated, watch)
+}
+
+func listWatches(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	var watches []Watch
+	if err := db.Where("employee_id = ?", caller.ID).Order("id").Find(&watches).Error; err != nil {
+		logger.Errorf("Failed to retrieve watches: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve watches")
+		return
+	}
+	c.JSON(http.StatusOK, watches)
+}
+
+func deleteWatch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	result := db.Where("id = ? AND employee_id = ?", idInt, caller.ID).Delete(&Watch{})
+	if result.Error != nil {
+		logger.Errorf("Failed to delete watch: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete watch")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Watch not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Watch deleted successfully"})
+}
+
+func getNotificationPreference(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	pref := NotificationPreference{EmployeeID: caller.ID, Frequency: frequencyImmediate}
+	if err := db.Where("employee_id = ?", caller.ID).Limit(1).Find(&pref).Error; err != nil {
+		logger.Errorf("Failed to retrieve notification preferences: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve notification preferences")
+		return
+	}
+	c.JSON(http.StatusOK, pref)
+}
+
+func updateNotificationPreference(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Channels   []string `json:"channels"`
+		Frequency  string   `json:"frequency"`
+		Email      string   `json:"email"`
+		WebhookURL string   `json:"webhook_url"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Frequency == "" {
+		input.Frequency = frequencyImmediate
+	}
+	if input.Frequency != frequencyImmediate && input.Frequency != frequencyDigest {
+		respondWithError(c, http.StatusBadRequest, "frequency must be immediate or digest")
+		return
+	}
+	for _, ch := range input.Channels {
+		switch ch {
+		case channelEmail:
+		case channelWebhook:
+			if err := validateWebhookURL(input.WebhookURL); err != nil {
+				respondWithError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		default:
+			respondWithError(c, http.StatusBadRequest, "channels must be email or webhook; the inbox is always on")
+			return
+		}
+	}
+
+	pref := NotificationPreference{
+		EmployeeID: caller.ID,
+		Channels:   strings.Join(uniqueStrings(input.Channels), ","),
+		Frequency:  input.Frequency,
+		Email:      input.Email,
+		WebhookURL: input.WebhookURL,
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "employee_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"channels", "frequency", "email", "webhook_url", "updated_at"}),
+	}).Create(&pref).Error
+	if err != nil {
+		logger.Errorf("Failed to update notification preferences: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update notification preferences")
+		return
+	}
+	c.JSON(http.StatusOK, pref)
+}
+
+func uniqueStrings(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if !containsString(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// listNotifications returns the caller's inbox, newest first; ?unread=true
+// keeps only unread notifications.
+func listNotifications(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset := (page - 1) * limit
+
+	query := db.Model(&Notification{}).Where("employee_id = ?", caller.ID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var unread int64
+	if err := db.Model(&Notification{}).Where("employee_id = ? AND read_at IS NULL", caller.ID).Count(&unread).Error; err != nil {
+		logger.Errorf("Failed to count notifications: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve notifications")
+		return
+	}
+	var notes []Notification
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&notes).Error; err != nil {
+		logger.Errorf("Failed to retrieve notifications: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve notifications")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unread, "notifications": notes})
+}
+
+func markNotification(read bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, ok := currentEmployee(c)
+		if !ok {
+			return
+		}
+		idInt, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+			return
+		}
+
+		var readAt interface{}
+		if read {
+			readAt = time.Now()
+		}
+		result := db.Model(&Notification{}).Where("id = ? AND employee_id = ?", idInt, caller.ID).Update("read_at", readAt)
+		if result.Error != nil {
+			logger.Errorf("Failed to update notification: %v", result.Error)
+			respondWithError(c, http.StatusInternalServerError, "Failed to update notification")
+			return
+		}
+		if result.RowsAffected == 0 {
+			respondWithError(c, http.StatusNotFound, "Notification not found")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Notification updated successfully"})
+	}
+}
+
+func markAllNotificationsRead(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	result := db.Model(&Notification{}).Where("employee_id = ? AND read_at IS NULL", caller.ID).Update("read_at", time.Now())
+	if result.Error != nil {
+		logger.Errorf("Failed to update notifications: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update notifications")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Notifications marked as read", "updated": result.RowsAffected})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that a single notification is sent as is and several as a digest
+func TestComposeMessage(t *testing.T) {
+	at := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	one := Notification{Subject: "Laptop is now Lost", Body: "Status changed.", CreatedAt: at}
+
+	subject, body := composeMessage([]Notification{one})
+	assert.Equal(t, "Laptop is now Lost", subject)
+	assert.Equal(t, "Status changed.", body)
+
+	subject, body = composeMessage([]Notification{one, {Subject: "Low stock: Laptop", CreatedAt: at}})
+	assert.Equal(t, "2 device notifications", subject)
+	assert.Equal(t, "- Laptop is now Lost (2024-05-01 09:30)\n  Status changed.\n- Low stock: Laptop (2024-05-01 09:30)\n", body)
+}
+
+// Test when a digest is due
+func TestDigestDue(t *testing.T) {
+	now := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	recent := now.Add(-time.Hour)
+	old := now.Add(-25 * time.Hour)
+
+	assert.True(t, digestDue(nil, 24*time.Hour, now))
+	assert.False(t, digestDue(&recent, 24*time.Hour, now))
+	assert.True(t, digestDue(&old, 24*time.Hour, now))
+}
+
+// Test that email headers cannot be injected through the subject
+func TestBuildEmail(t *testing.T) {
+	msg := string(buildEmail("devices@example.com", "a@example.com", "Hi\r\nBcc: x@example.com", "line1\nline2", time.Unix(0, 0).UTC()))
+	assert.Contains(t, msg, "Subject: Hi Bcc: x@example.com\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nline1\r\nline2"))
+}
+
+// Test webhook signatures
+func TestSignWebhook(t *testing.T) {
+	assert.Equal(t, "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13", signWebhook("secret", []byte("{}")))
+	assert.NotEqual(t, signWebhook("secret", []byte("{}")), signWebhook("other", []byte("{}")))
+}
+
+// Test that webhook URLs cannot target internal services
+func TestValidateWebhookURL(t *testing.T) {
+	for _, raw := range []string{"https://hooks.example.com/devices", "http://203.0.113.7:8080/hook"} {
+		assert.NoError(t, validateWebhookURL(raw), raw)
+	}
+	for _, raw := range []string{"", "ftp://hooks.example.com", "https://", "http://localhost:8080", "http://api.LOCALHOST.",
+		"http://127.0.0.1/", "http://10.1.2.3/", "http://169.254.169.254/latest/meta-data", "http://[::1]/", "http://[fe80::1]/",
+		"http://0.0.0.0/", "http://[::ffff:192.168.0.1]/"} {
+		assert.Error(t, validateWebhookURL(raw), raw)
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Offboarding tracks the return of a leaving employee's devices.
+type Offboarding struct {
+	ID             uint              `gorm:"primaryKey" json:"id"`
+	EmployeeID     uint              `gorm:"column:employee_id;index" json:"employee_id"`
+	LastDay        time.Time         `gorm:"column:last_day" json:"last_day"`
+	Deadline       time.Time         `gorm:"column:deadline" json:"deadline"`
+	Status         string            `gorm:"column:status;index" json:"status"`
+	RemindersSent  int               `gorm:"column:reminders_sent" json:"reminders_sent"`
+	LastReminderAt *time.Time        `gorm:"column:last_reminder_at" json:"last_reminder_at"`
+	EscalatedAt    *time.Time        `gorm:"column:escalated_at" json:"escalated_at"`
+	CompletedAt    *time.Time        `gorm:"column:completed_at" json:"completed_at"`
+	Items          []OffboardingItem `json:"items"`
+	CreatedAt      time.Time         `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time         `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// OffboardingItem is one device on an offboarding checklist.
+type OffboardingItem struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	OffboardingID uint       `gorm:"column:offboarding_id;index" json:"offboarding_id"`
+	DeviceID      uint       `gorm:"column:device_id" json:"device_id"`
+	Device        *Device    `json:"device,omitempty"`
+	Status        string     `gorm:"column:status" json:"status"`
+	PrevStatus    string     `gorm:"column:prev_status" json:"prev_status"` // device status while pending, restored if the item is reopened
+	Note          string     `gorm:"column:note" json:"note"`
+	ResolvedAt    *time.Time `gorm:"column:resolved_at" json:"resolved_at"`
+}
+
+const (
+	offboardingOpen      = "open"
+	offboardingEscalated = "escalated"
+	offboardingCompleted = "completed"
+
+	itemPending  = "pending"
+	itemReturned = "returned"
+	itemLost     = "lost"
+	itemWaived   = "waived" // employee keeps the device, e.g. bought it out
+)
+
+// OffboardingReport is the final summary of an offboarding.
+type OffboardingReport struct {
+	Offboarding      Offboarding `json:"offboarding"`
+	Employee         Employee    `json:"employee"`
+	Manager          *Employee   `json:"manager,omitempty"`
+	Total            int         `json:"total"`
+	Returned         int         `json:"returned"`
+	Lost             int         `json:"lost"`
+	Waived           int         `json:"waived"`
+	Pending          int         `json:"pending"`
+	OutstandingValue uint        `json:"outstanding_value"`
+}
+
+// startOffboarding marks an employee as leaving and opens an offboarding with
+// a checklist of the devices assigned to them. If one is already open it is
+// returned as is.
+func startOffboarding(tx *gorm.DB, employeeID uint, lastDay time.Time) (*Offboarding, error) {
+	if err := tx.Model(&Employee{}).Where("id = ?", employeeID).Update("leaving_on", lastDay).Error; err != nil {
+		return nil, err
+	}
+
+	var existing Offboarding
+	err := tx.Where("employee_id = ? AND status <> ?", employeeID, offboardingCompleted).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var devices []Device
+	if err := tx.Where("assigned_to = ?", employeeID).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+
+	offboarding := Offboarding{
+		EmployeeID: employeeID,
+		LastDay:    lastDay,
+		Deadline:   lastDay.Add(config().Offboarding.ReturnWindow.Duration),
+		Status:     offboardingOpen,
+	}
+	for _, device := range devices {
+		offboarding.Items = append(offboarding.Items, OffboardingItem{DeviceID: device.ID, Status: itemPending})
+	}
+	if len(devices) == 0 {
+		now := time.Now()
+		offboarding.Status = offboardingCompleted
+		offboarding.CompletedAt = &now
+	}
+
+	if err := tx.Create(&offboarding).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("Offboarding %d started for employee %d with %d devices", offboarding.ID, employeeID, len(devices))
+	return &offboarding, nil
+}
+
+// offboardingDeviceFields returns the device changes for an item in status.
+// Resolved devices are no longer assigned to the leaving employee; a pending
+// one is assigned to them again as it was.
+func offboardingDeviceFields(status, prevStatus string, employeeID uint) map[string]interface{} {
+	if prevStatus == "" {
+		prevStatus = statusAssigned // resolved before the status was kept
+	}
+	switch status {
+	case itemReturned:
+		return map[string]interface{}{"assigned_to": nil, "status": statusInStock}
+	case itemLost:
+		return map[string]interface{}{"assigned_to": nil, "status": statusLost}
+	case itemWaived:
+		return map[string]interface{}{"assigned_to": nil, "status": prevStatus}
+	default:
+		return map[string]interface{}{"assigned_to": employeeID, "status": prevStatus}
+	}
+}
+
+// resolveOffboardingItem records the outcome for one device and completes the
+// offboarding once nothing is pending. Any outcome can be changed later,
+// including back to pending.
+func resolveOffboardingItem(tx *gorm.DB, item *OffboardingItem, status, note string) error {
+	if item.Status == status && status == itemPending {
+		item.Note = note
+		return tx.Model(item).Update("note", note).Error
+	}
+	var offboarding Offboarding
+	if err := tx.Select("id", "employee_id").First(&offboarding, item.OffboardingID).Error; err != nil {
+		return err
+	}
+	if item.Status == itemPending {
+		var device Device
+		if err := tx.Select("id", "status").First(&device, item.DeviceID).Error; err != nil {
+			return err
+		}
+		item.PrevStatus = device.Status
+	}
+
+	now := time.Now()
+	item.Status = status
+	item.Note = note
+	item.ResolvedAt = &now
+	if status == itemPending {
+		item.ResolvedAt = nil
+	}
+	if err := tx.Save(item).Error; err != nil {
+		return err
+	}
+
+	fields := offboardingDeviceFields(status, item.PrevStatus, offboarding.EmployeeID)
+	if err := updateDeviceFields(tx, item.DeviceID, fields); err != nil {
+		return err
+	}
+
+	var pending int64
+	if err := tx.Model(&OffboardingItem{}).
+		Where("offboarding_id = ? AND status = ?", item.OffboardingID, itemPending).Count(&pending).Error; err != nil {
+		return err
+	}
+	if pending == 0 {
+		return tx.Model(&Offboarding{}).Where("id = ?", item.OffboardingID).
+			Updates(map[string]interface{}{"status": offboardingCompleted, "completed_at": now}).Error
+	}
+	// An item put back to pending reopens a completed offboarding.
+	return tx.Model(&Offboarding{}).Where("id = ? AND status = ?", item.OffboardingID, offboardingCompleted).
+		Updates(map[string]interface{}{"status": offboardingOpen, "completed_at": nil}).Error
+}
+
+// offboardingActions decides whether an open offboarding is due a reminder to
+// the employee and whether it should be escalated to their manager.
+func offboardingActions(o Offboarding, now time.Time, reminderEvery time.Duration) (remind, escalate bool) {
+	if o.Status == offboardingCompleted {
+		return false, false
+	}
+	if now.After(o.Deadline) && o.EscalatedAt == nil {
+		escalate = true
+	}
+	if now.After(o.LastDay) && (o.LastReminderAt == nil || now.Sub(*o.LastReminderAt) >= reminderEvery) {
+		remind = true
+	}
+	return remind, escalate
+}
+
+// processOffboardings sends due reminders and escalations.
+func processOffboardings(ctx context.Context) error {
+	var open []Offboarding
+	if err := db.WithContext(ctx).Preload("Items", "status = ?", itemPending).
+		Where("status <> ?", offboardingCompleted).Find(&open).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, o := range open {
+		remind, escalate := offboardingActions(o, now, config().Offboarding.ReminderEvery.Duration)
+		if !remind && !escalate {
+			continue
+		}
+
+		var employee Employee
+		if err := db.WithContext(ctx).First(&employee, o.EmployeeID).Error; err != nil {
+			logger.Errorf("Offboarding %d: failed to load employee: %v", o.ID, err)
+			continue
+		}
+
+		updates := map[string]interface{}{}
+		if remind {
+			sendOffboardingReminder(db.WithContext(ctx), employee, o)
+			updates["reminders_sent"] = o.RemindersSent + 1
+			updates["last_reminder_at"] = now
+		}
+		if escalate {
+			var manager *Employee
+			if employee.ManagerID != nil {
+				var m Employee
+				if err := db.WithContext(ctx).First(&m, *employee.ManagerID).Error; err == nil {
+					manager = &m
+				}
+			}
+			escalateOffboarding(db.WithContext(ctx), employee, manager, o)
+			updates["status"] = offboardingEscalated
+			updates["escalated_at"] = now
+		}
+		if err := db.WithContext(ctx).Model(&Offboarding{}).Where("id = ?", o.ID).Updates(updates).Error; err != nil {
+			logger.Errorf("Offboarding %d: failed to record reminder: %v", o.ID, err)
+		}
+	}
+	return nil
+}
+
+func sendOffboardingReminder(tx *gorm.DB, employee Employee, o Offboarding) {
+	logger.Infof("Offboarding reminder to %s: %d devices to return by %s",
+		employee.Email, len(o.Items), o.Deadline.Format("2006-01-02"))
+	notifyLogged(tx, notificationEvent{
+		Type:       eventOffboardingReminder,
+		Subject:    "Please return your devices",
+		Body:       fmt.Sprintf("%d devices are due back by %s.", len(o.Items), o.Deadline.Format("2006-01-02")),
+		Key:        fmt.Sprintf("offboarding-%d-reminder-%d", o.ID, o.RemindersSent+1),
+		Recipients: []uint{employee.ID},
+	})
+}
+
+func escalateOffboarding(tx *gorm.DB, employee Employee, manager *Employee, o Offboarding) {
+	ev := notificationEvent{
+		Type:    eventOffboardingEscalated,
+		Subject: fmt.Sprintf("Devices overdue from %s %s", employee.FirstName, employee.LastName),
+		Body:    fmt.Sprintf("%d devices were due back by %s.", len(o.Items), o.Deadline.Format("2006-01-02")),
+		Key:     fmt.Sprintf("offboarding-%d-escalation", o.ID),
+	}
+	if manager == nil {
+		logger.Warnf("Offboarding %d overdue for employee %d, who has no manager to escalate to", o.ID, employee.ID)
+	} else {
+		logger.Warnf("Offboarding %d overdue: escalated to %s for %d devices held by %s",
+			o.ID, manager.Email, len(o.Items), employee.Email)
+		ev.Recipients = []uint{manager.ID}
+	}
+	// Anyone watching escalations hears about it, even without a manager.
+	notifyLogged(tx, ev)
+}
+
+func startOffboardingJob() {
+	startJob("offboarding-reminders", config().Offboarding.CheckInterval.Duration, processOffboardings)
+}
+
+func buildOffboardingReport(tx *gorm.DB, id uint) (*OffboardingReport, error) {
+	var o Offboarding
+	if err := tx.Preload("Items.Device").First(&o, id).Error; err != nil {
+		return nil, err
+	}
+	report := &OffboardingReport{Offboarding: o, Total: len(o.Items)}
+	if err := tx.First(&report.Employee, o.EmployeeID).Error; err != nil {
+		return nil, err
+	}
+	if report.Employee.ManagerID != nil {
+		var manager Employee
+		if err := tx.First(&manager, *report.Employee.ManagerID).Error; err == nil {
+			report.Manager = &manager
+		}
+	}
+
+	for _, item := range o.Items {
+		switch item.Status {
+		case itemReturned:
+			report.Returned++
+		case itemLost:
+			report.Lost++
+		case itemWaived:
+			report.Waived++
+		default:
+			report.Pending++
+		}
+		if (item.Status == itemPending || item.Status == itemLost) && item.Device != nil {
+			report.OutstandingValue += item.Device.Price
+		}
+	}
+	return report, nil
+}
+
+func startEmployeeOffboarding(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var input struct {
+		LastDay string `json:"last_day"` // YYYY-MM-DD, defaults to today
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	lastDay := time.Now()
+	if input.LastDay != "" {
+		lastDay, err = time.Parse("2006-01-02", input.LastDay)
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "last_day must be YYYY-MM-DD")
+			return
+		}
+	}
+
+	var offboarding *Offboarding
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var employee Employee
+		if err := tx.First(&employee, idInt).Error; err != nil {
+			return err
+		}
+		var err error
+		offboarding, err = startOffboarding(tx, employee.ID, lastDay)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Employee not found")
+			return
+		}
+		logger.Errorf("Failed to start offboarding: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to start offboarding")
+		return
+	}
+	c.JSON(http.StatusCreated, offboarding)
+}
+
+func listOffboardings(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	offset := (page - 1) * limit
+
+	query := db.Limit(limit).Offset(offset).Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var offboardings []Offboarding
+	if err := query.Preload("Items").Find(&offboardings).Error; err != nil {
+		logger.Errorf("Failed to retrieve offboardings: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve offboardings")
+		return
+	}
+	c.JSON(http.StatusOK, offboardings)
+}
+
+func getOffboarding(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var offboarding Offboarding
+	if err := db.Preload("Items.Device").First(&offboarding, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Offboarding not found")
+		} else {
+			logger.Errorf("Failed to retrieve offboarding: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve offboarding")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, offboarding)
+}
+
+func updateOffboardingItem(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid item ID format")
+		return
+	}
+
+	var input struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch input.Status {
+	case itemPending, itemReturned, itemLost, itemWaived:
+	default:
+		respondWithError(c, http.StatusBadRequest, "status must be pending, returned, lost or waived")
+		return
+	}
+
+	var item OffboardingItem
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND offboarding_id = ?", itemID, idInt).First(&item).Error; err != nil {
+			return err
+		}
+		return resolveOffboardingItem(tx, &item, input.Status, input.Note)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Offboarding item not found")
+			return
+		}
+		logger.Errorf("Failed to update offboarding item: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update offboarding item")
+		return
+	}
+
+	logger.Infof("Offboarding %d: device %d marked %s", idInt, item.DeviceID, item.Status)
+	c.JSON(http.StatusOK, item)
+}
+
+func getOffboardingReport(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	report, err := buildOffboardingReport(db, uint(idInt))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Offboarding not found")
+		} else {
+			logger.Errorf("Failed to build offboarding report: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to build offboarding report")
+		}
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test when reminders and escalations are due
+func TestOffboardingActions(t *testing.T) {
+	lastDay := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	o := Offboarding{LastDay: lastDay, Deadline: lastDay.AddDate(0, 0, 7), Status: offboardingOpen}
+	every := 72 * time.Hour
+
+	remind, escalate := offboardingActions(o, lastDay.Add(-time.Hour), every)
+	assert.False(t, remind)
+	assert.False(t, escalate)
+
+	remind, escalate = offboardingActions(o, lastDay.Add(time.Hour), every)
+	assert.True(t, remind)
+	assert.False(t, escalate)
+
+	reminded := lastDay.Add(time.Hour)
+	o.LastReminderAt = &reminded
+	remind, _ = offboardingActions(o, lastDay.Add(24*time.Hour), every)
+	assert.False(t, remind)
+
+	remind, escalate = offboardingActions(o, lastDay.AddDate(0, 0, 8), every)
+	assert.True(t, remind)
+	assert.True(t, escalate)
+
+	escalated := lastDay.AddDate(0, 0, 8)
+	o.EscalatedAt = &escalated
+	o.Status = offboardingEscalated
+	_, escalate = offboardingActions(o, lastDay.AddDate(0, 0, 9), every)
+	assert.False(t, escalate)
+
+	o.Status = offboardingCompleted
+	remind, escalate = offboardingActions(o, lastDay.AddDate(0, 0, 20), every)
+	assert.False(t, remind)
+	assert.False(t, escalate)
+}
+
+// Test the device changes for each item outcome, including reopening
+func TestOffboardingDeviceFields(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"assigned_to": nil, "status": statusInStock}, offboardingDeviceFields(itemReturned, statusAssigned, 4))
+	assert.Equal(t, map[string]interface{}{"assigned_to": nil, "status": statusLost}, offboardingDeviceFields(itemLost, statusAssigned, 4))
+	assert.Equal(t, map[string]interface{}{"assigned_to": nil, "status": statusAssigned}, offboardingDeviceFields(itemWaived, statusAssigned, 4))
+	assert.Equal(t, map[string]interface{}{"assigned_to": uint(4), "status": statusAssigned}, offboardingDeviceFields(itemPending, statusAssigned, 4))
+	assert.Equal(t, statusAssigned, offboardingDeviceFields(itemPending, "", 4)["status"])
+}
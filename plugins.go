@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"gorm.io/gorm"
+)
+
+// Plugin hooks. Each is the name of the function a module exports to handle it.
+const (
+	hookBeforeCreate = "before_create"
+	hookBeforeUpdate = "before_update"
+	hookImportRow    = "import_row_transform"
+)
+
+var pluginHooks = []string{hookBeforeCreate, hookBeforeUpdate, hookImportRow}
+
+// pluginABIVersion is sent with every call so modules can detect changes.
+//
+// The ABI: a module exports its memory as "memory", a function
+// alloc(size i32) i32 that returns a buffer of size bytes, and a function per
+// hook taking (ptr i32, len i32) of a JSON pluginRequest and returning an i64
+// with the address of a JSON pluginResponse in the high 32 bits and its
+// length in the low 32 bits. A zero length means no change.
+const pluginABIVersion = 1
+
+// maxPluginOutput caps the size of a plugin response.
+const maxPluginOutput = 1 << 20
+
+// Plugin is an uploaded WebAssembly module run on device hooks. Plugins run
+// in ascending Position order, each seeing the previous one's changes.
+type Plugin struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	Name        string    `gorm:"column:name;uniqueIndex" json:"name"`
+	Hooks       string    `gorm:"column:hooks" json:"hooks"` // comma-separated
+	Enabled     bool      `gorm:"column:enabled" json:"enabled"`
+	Position    int       `gorm:"column:position" json:"position"`
+	MemoryPages uint32    `gorm:"column:memory_pages" json:"memory_pages"` // 64 KiB pages
+	TimeoutMs   int       `gorm:"column:timeout_ms" json:"timeout_ms"`     // per call, bounds CPU use
+	SHA256      string    `gorm:"column:sha256" json:"sha256"`
+	Wasm        []byte    `gorm:"column:wasm" json:"-"`
+	CreatedAt   time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// pluginRequest is the JSON a hook function receives.
+type pluginRequest struct {
+	ABI      int    `json:"abi"`
+	Hook     string `json:"hook"`
+	DeviceID uint   `json:"device_id,omitempty"` // before_update: the device being changed
+	Line     int    `json:"line,omitempty"`      // import_row_transform: the CSV line
+	Device   Device `json:"device"`
+}
+
+// pluginResponse is the JSON a hook function returns. Device replaces the
+// input device when set; Reject refuses it with a reason.
+type pluginResponse struct {
+	Device *Device `json:"device"`
+	Reject string  `json:"reject"`
+}
+
+// pluginRejection is returned when a plugin refuses a device.
+type pluginRejection struct {
+	Plugin string
+	Reason string
+}
+
+func (r *pluginRejection) Error() string {
+	return fmt.Sprintf("rejected by plugin %s: %s", r.Plugin, r.Reason)
+}
+
+// loadedPlugin is a compiled plugin ready to be instantiated per call.
+type loadedPlugin struct {
+	plugin   Plugin
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// compilePlugin compiles a plugin in its own runtime so its memory limit
+// applies, and checks it exports what its hooks need.
+func compilePlugin(ctx context.Context, p Plugin) (*loadedPlugin, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(p.MemoryPages).
+		WithCloseOnContextDone(true))
+	// Toolchains targeting WASI need its imports; no files, env or args are exposed.
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+	compiled, err := runtime.CompileModule(ctx, p.Wasm)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, err
+	}
+
+	exports := compiled.ExportedFunctions()
+	required := append([]string{"alloc"}, splitChannels(p.Hooks)...)
+	for _, name := range required {
+		if _, ok := exports[name]; !ok {
+			runtime.Close(ctx)
+			return nil, fmt.Errorf("module does not export %s", name)
+		}
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		runtime.Close(ctx)
+		return nil, errors.New("module does not export memory")
+	}
+	return &loadedPlugin{plugin: p, runtime: runtime, compiled: compiled}, nil
+}
+
+// call runs one hook in a fresh instance, so calls share no state.
+func (lp *loadedPlugin) call(ctx context.Context, hook string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(lp.plugin.TimeoutMs)*time.Millisecond)
+	defer cancel()
+
+	mod, err := lp.runtime.InstantiateModule(ctx, lp.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, err
+	}
+	defer mod.Close(ctx)
+
+	res, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, errors.New("alloc returned an out of range buffer")
+	}
+
+	res, err = mod.ExportedFunction(hook).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, err
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	if outLen > maxPluginOutput {
+		return nil, fmt.Errorf("response of %d bytes is too large", outLen)
+	}
+	out, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("response is out of range")
+	}
+	return append([]byte{}, out...), nil
+}
+
+// apply runs a hook on device, replacing it with the plugin's version.
+func (lp *loadedPlugin) apply(ctx context.Context, hook string, deviceID uint, line int, device *Device) error {
+	input, err := json.Marshal(pluginRequest{ABI: pluginABIVersion, Hook: hook, DeviceID: deviceID, Line: line, Device: *device})
+	if err != nil {
+		return err
+	}
+	out, err := lp.call(ctx, hook, input)
+	if err != nil {
+		return fmt.Errorf("plugin %s failed: %v", lp.plugin.Name, err)
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return fmt.Errorf("plugin %s returned invalid JSON: %v", lp.plugin.Name, err)
+	}
+	if resp.Reject != "" {
+		return &pluginRejection{Plugin: lp.plugin.Name, Reason: resp.Reject}
+	}
+	if resp.Device != nil {
+		id := device.ID
+		*device = *resp.Device
+		device.ID = id // plugins cannot renumber devices
+	}
+	return nil
+}
+
+// pluginSet caches the compiled enabled plugins. It is reloaded after
+// changes through the API and at least every config.Plugins.RefreshEvery so
+// changes made on other instances are picked up.
+var pluginSet struct {
+	sync.Mutex
+	loaded   []*loadedPlugin
+	loadedAt time.Time
+}
+
+func invalidatePlugins() {
+	pluginSet.Lock()
+	defer pluginSet.Unlock()
+	retirePlugins(pluginSet.loaded)
+	pluginSet.loaded = nil
+	pluginSet.loadedAt = time.Time{}
+}
+
+// retirePlugins closes replaced plugins once calls already holding them
+// have had time to finish.
+func retirePlugins(loaded []*loadedPlugin) {
+	if len(loaded) == 0 {
+		return
+	}
+	time.AfterFunc(time.Minute, func() {
+		for _, lp := range loaded {
+			lp.runtime.Close(context.Background())
+		}
+	})
+}
+
+func enabledPlugins(ctx context.Context) ([]*loadedPlugin, error) {
+	pluginSet.Lock()
+	defer pluginSet.Unlock()
+	if !pluginSet.loadedAt.IsZero() && time.Since(pluginSet.loadedAt) < config().Plugins.RefreshEvery.Duration {
+		return pluginSet.loaded, nil
+	}
+
+	var plugins []Plugin
+	if err := db.WithContext(ctx).Where("enabled = ?", true).Order("position, id").Find(&plugins).Error; err != nil {
+		return nil, err
+	}
+	var loaded []*loadedPlugin
+	for _, p := range plugins {
+		lp, err := compilePlugin(ctx, p)
+		if err != nil {
+			// A module that no longer compiles is skipped rather than blocking every write.
+			logger.Errorf("Failed to load plugin %s: %v", p.Name, err)
+			continue
+		}
+		loaded = append(loaded, lp)
+	}
+	retirePlugins(pluginSet.loaded)
+	pluginSet.loaded, pluginSet.loadedAt = loaded, time.Now()
+	return loaded, nil
+}
+
+// runDeviceHook passes device through every enabled plugin registered for
+// hook. It returns a *pluginRejection if a plugin refuses the device.
+func runDeviceHook(ctx context.Context, hook string, deviceID uint, line int, device *Device) error {
+	plugins, err := enabledPlugins(ctx)
+	if err != nil {
+		return err
+	}
+	for _, lp := range plugins {
+		if !containsString(splitChannels(lp.plugin.Hooks), hook) {
+			continue
+		}
+		if err := lp.apply(ctx, hook, deviceID, line, device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pluginSettings are the fields of a plugin admins can change.
+type pluginSettings struct {
+	Hooks       []string `json:"hooks"`
+	Enabled     *bool    `json:"enabled"`
+	Position    *int     `json:"position"`
+	MemoryPages *uint32  `json:"memory_pages"`
+	TimeoutMs   *int     `json:"timeout_ms"`
+}
+
+// applyTo validates the settings and copies those that are set onto p.
+func (s pluginSettings) applyTo(p *Plugin) error {
+	if s.Hooks != nil {
+		for _, hook := range s.Hooks {
+			if !containsString(pluginHooks, hook) {
+				return errors.New("hooks must be " + strings.Join(pluginHooks, ", "))
+			}
+		}
+		hooks := uniqueStrings(s.Hooks)
+		sort.Strings(hooks)
+		p.Hooks = strings.Join(hooks, ",")
+	}
+	if s.Enabled != nil {
+		p.Enabled = *s.Enabled
+	}
+	if s.Position != nil {
+		p.Position = *s.Position
+	}
+	if s.MemoryPages != nil {
+		p.MemoryPages = *s.MemoryPages
+	}
+	if s.TimeoutMs != nil {
+		p.TimeoutMs = *s.TimeoutMs
+	}
+	if p.MemoryPages == 0 || p.MemoryPages > config().Plugins.MaxMemoryPages {
+		return fmt.Errorf("memory_pages must be between 1 and %d", config().Plugins.MaxMemoryPages)
+	}
+	if max := int(config().Plugins.MaxTimeout.Milliseconds()); p.TimeoutMs <= 0 || p.TimeoutMs > max {
+		return fmt.Errorf("timeout_ms must be between 1 and %d", max)
+	}
+	return nil
+}
+
+// createPlugin uploads a module. The multipart form has the module in
+// "file", a "name", and optionally the settings as JSON in "settings".
+func createPlugin(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		respondWithError(c, http.StatusBadRequest, "name is required")
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "File is required")
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		logger.Errorf("Failed to open file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer src.Close()
+	wasm, err := io.ReadAll(io.LimitReader(src, config().Plugins.MaxModuleBytes+1))
+	if err != nil {
+		logger.Errorf("Failed to read file: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	if int64(len(wasm)) > config().Plugins.MaxModuleBytes {
+		respondWithError(c, http.StatusRequestEntityTooLarge, "Module is too large")
+		return
+	}
+
+	var settings pluginSettings
+	if raw := c.PostForm("settings"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+			respondWithError(c, http.StatusBadRequest, "settings must be JSON: "+err.Error())
+			return
+		}
+	}
+	sum := sha256.Sum256(wasm)
+	plugin := Plugin{
+		Name:        name,
+		Enabled:     true,
+		MemoryPages: config().Plugins.DefaultMemoryPages,
+		TimeoutMs:   int(config().Plugins.DefaultTimeout.Milliseconds()),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Wasm:        wasm,
+	}
+	if err := settings.applyTo(&plugin); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !savePlugin(c, &plugin, true) {
+		return
+	}
+
+	logger.Infof("Plugin %s uploaded for hooks %s", plugin.Name, plugin.Hooks)
+	c.JSON(http.StatusCreated, plugin)
+}
+
+// savePlugin checks the module compiles with its settings before storing it.
+func savePlugin(c *gin.Context, plugin *Plugin, create bool) bool {
+	lp, err := compilePlugin(c.Request.Context(), *plugin)
+	if err != nil {
+		respondWithError(c, http.StatusUnprocessableEntity, "Invalid module: "+err.Error())
+		return false
+	}
+	lp.runtime.Close(c.Request.Context())
+
+	if create {
+		err = db.Create(plugin).Error
+	} else {
+		err = db.Save(plugin).Error
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "A plugin with this name already exists")
+			return false
+		}
+		logger.Errorf("Failed to save plugin: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to save plugin")
+		return false
+	}
+	invalidatePlugins()
+	return true
+}
+
+func listPlugins(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	var plugins []Plugin
+	if err := db.Omit("wasm").Order("position, id").Find(&plugins).Error; err != nil {
+		logger.Errorf("Failed to retrieve plugins: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve plugins")
+		return
+	}
+	c.JSON(http.StatusOK, plugins)
+}
+
+func updatePlugin(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var settings pluginSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var plugin Plugin
+	if err := db.First(&plugin, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Plugin not found")
+		} else {
+			logger.Errorf("Failed to retrieve plugin: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve plugin")
+		}
+		return
+	}
+	if err := settings.applyTo(&plugin); err != nil {
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !savePlugin(c, &plugin, false) {
+		return
+	}
+	c.JSON(http.StatusOK, plugin)
+}
+
+func deletePlugin(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	result := db.Delete(&Plugin{}, idInt)
+	if result.Error != nil {
+		logger.Errorf("Failed to delete plugin: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete plugin")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Plugin not found")
+		return
+	}
+	invalidatePlugins()
+	c.JSON(http.StatusOK, gin.H{"message": "Plugin deleted successfully"})
+}
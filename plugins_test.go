@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Hand-assembled modules exporting memory, alloc and before_create:
+// echo returns its input, reject returns {"reject":"serial number required"}
+// and loop never returns.
+const (
+	echoModule   = "0061736d01000000010c0260017f017f60027f7f017e03030200010503010001072203066d656d6f7279020005616c6c6f6300000d6265666f72655f63726561746500010a140205004180080b0c002000ad4220862001ad840b"
+	rejectModule = "0061736d01000000010c0260017f017f60027f7f017e03030200010503010001072203066d656d6f7279020005616c6c6f6300000d6265666f72655f63726561746500010a120205004180080b0a0042a38080808080020b0b2a01004180100b237b2272656a656374223a2273657269616c206e756d626572207265717569726564227d"
+	loopModule   = "0061736d01000000010c0260017f017f60027f7f017e03030200010503010001072203066d656d6f7279020005616c6c6f6300000d6265666f72655f63726561746500010a110205004180080b090003400c000b42000b"
+)
+
+func loadTestPlugin(t *testing.T, module string) *loadedPlugin {
+	wasm, err := hex.DecodeString(module)
+	assert.NoError(t, err)
+	lp, err := compilePlugin(context.Background(), Plugin{Name: "test", Hooks: hookBeforeCreate, MemoryPages: 16, TimeoutMs: 100, Wasm: wasm})
+	assert.NoError(t, err)
+	t.Cleanup(func() { lp.runtime.Close(context.Background()) })
+	return lp
+}
+
+// Test that a device survives the JSON ABI round trip with its ID kept
+func TestPluginEcho(t *testing.T) {
+	lp := loadTestPlugin(t, echoModule)
+	device := Device{ID: 5, DeviceName: "Laptop", Price: 1200}
+
+	assert.NoError(t, lp.apply(context.Background(), hookBeforeCreate, 0, 0, &device))
+	assert.Equal(t, Device{ID: 5, DeviceName: "Laptop", Price: 1200}, device)
+}
+
+// Test that a plugin can reject a device with a reason
+func TestPluginReject(t *testing.T) {
+	lp := loadTestPlugin(t, rejectModule)
+	device := Device{DeviceName: "Laptop"}
+
+	err := lp.apply(context.Background(), hookBeforeCreate, 0, 0, &device)
+	var rejection *pluginRejection
+	assert.True(t, errors.As(err, &rejection))
+	assert.Equal(t, "serial number required", rejection.Reason)
+}
+
+// Test that a plugin that never returns is stopped by its timeout
+func TestPluginTimeout(t *testing.T) {
+	lp := loadTestPlugin(t, loopModule)
+	device := Device{DeviceName: "Laptop"}
+
+	err := lp.apply(context.Background(), hookBeforeCreate, 0, 0, &device)
+	assert.Error(t, err)
+	var rejection *pluginRejection
+	assert.False(t, errors.As(err, &rejection))
+}
+
+// Test that modules missing a hook export are refused
+func TestCompilePluginMissingExport(t *testing.T) {
+	wasm, _ := hex.DecodeString(echoModule)
+	_, err := compilePlugin(context.Background(), Plugin{Hooks: hookBeforeUpdate, MemoryPages: 16, TimeoutMs: 100, Wasm: wasm})
+	assert.EqualError(t, err, "module does not export before_update")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// personalData is everything stored about one employee, for data subject
+// access requests.
+type personalData struct {
+	Employee                Employee                 `json:"employee"`
+	AssignedDevices         []Device                 `json:"assigned_devices"`
+	Reservations            []Reservation            `json:"reservations"`
+	Provisionings           []Provisioning           `json:"provisionings"`
+	Offboardings            []Offboarding            `json:"offboardings"`
+	Groups                  []Group                  `json:"groups"`
+	SavedSearches           []SavedSearch            `json:"saved_searches"`
+	Watches                 []Watch                  `json:"watches"`
+	NotificationPreferences []NotificationPreference `json:"notification_preferences"`
+	Notifications           []Notification           `json:"notifications"`
+	IssuedConsumables       []ConsumableEntry        `json:"issued_consumables"`
+	CalendarFeeds           []CalendarFeed           `json:"calendar_feeds"` // feeds they created or of their assignments
+	DeviceShares            []DeviceShare            `json:"device_shares"`
+	LeaseDecisions          []LeaseDevice            `json:"lease_decisions"`
+	SIMAttachments          []SIMAttachment          `json:"sim_attachments"` // attached or detached by them
+	Transfers               []Transfer               `json:"transfers"`
+	ReceivedTransferItems   []TransferItem           `json:"received_transfer_items"`
+	TransferDiscrepancies   []TransferDiscrepancy    `json:"transfer_discrepancies"`
+}
+
+func collectPersonalData(tx *gorm.DB, employeeID uint) (*personalData, error) {
+	data := &personalData{}
+	if err := tx.First(&data.Employee, employeeID).Error; err != nil {
+		return nil, err
+	}
+
+	queries := []struct {
+		dest  interface{}
+		query *gorm.DB
+	}{
+		{&data.AssignedDevices, tx.Where("assigned_to = ?", employeeID)},
+		{&data.Reservations, tx.Where("employee_id = ?", employeeID)},
+		{&data.Provisionings, tx.Where("employee_id = ?", employeeID)},
+		{&data.Offboardings, tx.Preload("Items").Where("employee_id = ?", employeeID)},
+		{&data.Groups, tx.Where("id IN (?)", tx.Table("group_members").Select("group_id").Where("employee_id = ?", employeeID))},
+		{&data.SavedSearches, tx.Where("owner_id = ?", employeeID)},
+		{&data.Watches, tx.Where("employee_id = ?", employeeID)},
+		{&data.NotificationPreferences, tx.Where("employee_id = ?", employeeID)},
+		{&data.Notifications, tx.Where("employee_id = ?", employeeID)},
+		{&data.IssuedConsumables, tx.Where("employee_id = ?", employeeID)},
+		{&data.CalendarFeeds, tx.Where("assignee_id = ? OR created_by = ?", employeeID, employeeID)},
+		{&data.DeviceShares, tx.Where("created_by = ?", employeeID)},
+		{&data.LeaseDecisions, tx.Where("decided_by = ?", employeeID)},
+		{&data.SIMAttachments, tx.Where("attached_by = ? OR detached_by = ?", employeeID, employeeID)},
+		{&data.Transfers, tx.Where("created_by = ?", employeeID)},
+		{&data.ReceivedTransferItems, tx.Where("received_by = ?", employeeID)},
+		{&data.TransferDiscrepancies, tx.Where("reported_by = ?", employeeID)},
+	}
+	for _, q := range queries {
+		if err := q.query.Order("id").Find(q.dest).Error; err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// writePersonalDataArchive writes the data as a zip of JSON files, one per
+// kind of record, plus a manifest.
+func writePersonalDataArchive(w io.Writer, data *personalData, now time.Time) error {
+	zw := zip.NewWriter(w)
+	sections := []struct {
+		name  string
+		value interface{}
+	}{
+		{"manifest.json", gin.H{"employee_id": data.Employee.ID, "exported_at": now, "format": "one JSON document per file"}},
+		{"employee.json", data.Employee},
+		{"assigned_devices.json", data.AssignedDevices},
+		{"reservations.json", data.Reservations},
+		{"provisionings.json", data.Provisionings},
+		{"offboardings.json", data.Offboardings},
+		{"groups.json", data.Groups},
+		{"saved_searches.json", data.SavedSearches},
+		{"watches.json", data.Watches},
+		{"notification_preferences.json", data.NotificationPreferences},
+		{"notifications.json", data.Notifications},
+		{"issued_consumables.json", data.IssuedConsumables},
+		{"calendar_feeds.json", data.CalendarFeeds},
+		{"device_shares.json", data.DeviceShares},
+		{"lease_decisions.json", data.LeaseDecisions},
+		{"sim_attachments.json", data.SIMAttachments},
+		{"transfers.json", data.Transfers},
+		{"received_transfer_items.json", data.ReceivedTransferItems},
+		{"transfer_discrepancies.json", data.TransferDiscrepancies},
+	}
+	for _, section := range sections {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: section.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(section.value); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// pseudonymize replaces an employee's personal data with a pseudonym derived
+// from their ID, so records pointing at the ID stay intact.
+func pseudonymize(e *Employee, now time.Time) {
+	pseudonym := "erased-" + strconv.FormatUint(uint64(e.ID), 10)
+	e.ExternalID = pseudonym
+	e.UserName = pseudonym
+	e.Email = ""
+	e.FirstName = "Erased"
+	e.LastName = "Employee"
+	e.Department = ""
+	e.Title = ""
+	e.ManagerID = nil
+	e.ErasedAt = &now
+}
+
+var errEmployeeActive = errors.New("employee is still active")
+
+// eraseEmployee pseudonymizes an inactive employee and deletes the personal
+// settings and messages kept for them. Devices, reservations, offboardings,
+// issued consumables and status history keep pointing at the same ID.
+func eraseEmployee(tx *gorm.DB, employeeID uint, now time.Time) error {
+	var employee Employee
+	if err := tx.First(&employee, employeeID).Error; err != nil {
+		return err
+	}
+	if employee.Active {
+		return errEmployeeActive
+	}
+
+	pseudonymize(&employee, now)
+	if err := tx.Select("external_id", "user_name", "email", "first_name", "last_name",
+		"department", "title", "manager_id", "erased_at").Save(&employee).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM group_members WHERE employee_id = ?", employeeID).Error; err != nil {
+		return err
+	}
+	for _, model := range []interface{}{&Watch{}, &NotificationPreference{}, &Notification{}} {
+		if err := tx.Where("employee_id = ?", employeeID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	// Private searches are only useful to their owner; shared ones stay for the group.
+	return tx.Where("owner_id = ? AND group_id IS NULL", employeeID).Delete(&SavedSearch{}).Error
+}
+
+func exportPersonalData(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	data, err := collectPersonalData(db, uint(idInt))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Employee not found")
+		} else {
+			logger.Errorf("Failed to collect personal data: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to export personal data")
+		}
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "employee-"+strconv.Itoa(idInt)+"-personal-data.zip"))
+	if err := writePersonalDataArchive(c.Writer, data, time.Now()); err != nil {
+		logger.Errorf("Failed to write personal data archive: %v", err)
+		return
+	}
+	logger.Infof("Personal data of employee %d exported", idInt)
+}
+
+func erasePersonalData(c *gin.Context) {
+	admin, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return eraseEmployee(tx, uint(idInt), time.Now())
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(c, http.StatusNotFound, "Employee not found")
+		return
+	}
+	if errors.Is(err, errEmployeeActive) {
+		respondWithError(c, http.StatusConflict, "Deactivate the employee before erasing their data")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to erase personal data: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to erase personal data")
+		return
+	}
+
+	logger.Infof("Personal data of employee %d erased by employee %d", idInt, admin.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Personal data erased"})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that pseudonymization removes personal data but keeps the ID
+func TestPseudonymize(t *testing.T) {
+	manager := uint(2)
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	e := Employee{ID: 42, ExternalID: "jdoe", UserName: "jdoe", Email: "jdoe@example.com",
+		FirstName: "Jane", LastName: "Doe", Department: "Sales", Title: "Rep", ManagerID: &manager}
+
+	pseudonymize(&e, now)
+	assert.Equal(t, uint(42), e.ID)
+	assert.Equal(t, "erased-42", e.ExternalID)
+	assert.Equal(t, "erased-42", e.UserName)
+	assert.Empty(t, e.Email)
+	assert.Empty(t, e.Department)
+	assert.Nil(t, e.ManagerID)
+	assert.Equal(t, now, *e.ErasedAt)
+}
+
+// Test the layout of the personal data archive
+func TestWritePersonalDataArchive(t *testing.T) {
+	data := &personalData{
+		Employee:     Employee{ID: 42, Email: "jdoe@example.com"},
+		Reservations: []Reservation{{ID: 1, DeviceID: 7, EmployeeID: 42}},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, writePersonalDataArchive(&buf, data, time.Now()))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	assert.Contains(t, files, "manifest.json")
+	assert.Contains(t, files, "notifications.json")
+	assert.Contains(t, files, "transfer_discrepancies.json")
+
+	r, err := files["reservations.json"].Open()
+	assert.NoError(t, err)
+	var reservations []Reservation
+	assert.NoError(t, json.NewDecoder(r).Decode(&reservations))
+	assert.Equal(t, uint(7), reservations[0].DeviceID)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 5 * time.Second
+
+const redacted = "[redacted]"
+
+// startupConfig is the config the process started with. Settings in
+// restartSettings keep their startup values until the next restart.
+var startupConfig = defaultConfig()
+
+// restartSettings are the settings read only once at startup, by the HTTP
+// listener, the database connection and the scheduled jobs. Everything else
+// is read when it is used and so takes effect on reload.
+var restartSettings = []struct {
+	name  string
+	value func(c *Config) interface{}
+}{
+	{"server.addr", func(c *Config) interface{} { return c.Server.Addr }},
+	{"database.dsn", func(c *Config) interface{} { return c.Database.DSN }},
+	{"directory", func(c *Config) interface{} { return c.Directory }},
+	{"offboarding.check_interval", func(c *Config) interface{} { return c.Offboarding.CheckInterval }},
+	{"kits.checkout_interval", func(c *Config) interface{} { return c.Kits.CheckoutInterval }},
+	{"stock.check_interval", func(c *Config) interface{} { return c.Stock.CheckInterval }},
+	{"leases.check_interval", func(c *Config) interface{} { return c.Leases.CheckInterval }},
+	{"searches.check_interval", func(c *Config) interface{} { return c.Searches.CheckInterval }},
+	{"notifications.delivery_interval", func(c *Config) interface{} { return c.Notifications.DeliveryInterval }},
+	{"retention.interval", func(c *Config) interface{} { return c.Retention.Interval }},
+	{"warranty.interval", func(c *Config) interface{} { return c.Warranty.Interval }},
+}
+
+// restartRequired lists the restart-only settings that differ between the
+// running config and next.
+func restartRequired(running, next *Config) []string {
+	changed := []string{}
+	for _, setting := range restartSettings {
+		if !reflect.DeepEqual(setting.value(running), setting.value(next)) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// configStatus describes the most recent config load or reload.
+type configStatus struct {
+	Path            string    `json:"path"`
+	LoadedAt        time.Time `json:"loaded_at"`
+	LastAttemptAt   time.Time `json:"last_attempt_at"`
+	LastError       string    `json:"last_error,omitempty"`
+	RestartRequired []string  `json:"restart_required"` // changed settings waiting for a restart
+}
+
+var configState = struct {
+	sync.Mutex
+	status  configStatus
+	modTime time.Time
+	size    int64
+}{}
+
+func recordConfigLoad(path string, err error, restart []string) {
+	configState.Lock()
+	defer configState.Unlock()
+	now := time.Now()
+	configState.status.Path = path
+	configState.status.LastAttemptAt = now
+	if err != nil {
+		configState.status.LastError = err.Error()
+		return
+	}
+	configState.status.LoadedAt = now
+	configState.status.LastError = ""
+	if restart == nil {
+		restart = []string{}
+	}
+	configState.status.RestartRequired = restart
+	if info, err := os.Stat(path); err == nil {
+		configState.modTime, configState.size = info.ModTime(), info.Size()
+	}
+}
+
+func currentConfigStatus() configStatus {
+	configState.Lock()
+	defer configState.Unlock()
+	return configState.status
+}
+
+// applyConfig pushes settings that are not read on use into the running
+// process.
+func applyConfig(c *Config) {
+	if level, err := logrus.ParseLevel(c.Logging.Level); err == nil {
+		logger.SetLevel(level)
+	}
+}
+
+// reloadConfig rereads the config file and swaps it in if it is valid. An
+// invalid file leaves the running config untouched.
+func reloadConfig() error {
+	path := configPath()
+	next, err := readConfig(path)
+	if err != nil {
+		logger.Errorf("Config reload failed, keeping current config: %v", err)
+		recordConfigLoad(path, err, nil)
+		return err
+	}
+
+	restart := restartRequired(startupConfig, next)
+	activeConfig.Store(next)
+	applyConfig(next)
+	recordConfigLoad(path, nil, restart)
+
+	logger.Infof("Config reloaded from %s", path)
+	if len(restart) > 0 {
+		logger.Warnf("Config settings changed that need a restart: %v", restart)
+	}
+	return nil
+}
+
+// configFileChanged reports whether the config file differs from the one
+// last loaded or attempted.
+func configFileChanged(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	configState.Lock()
+	defer configState.Unlock()
+	if info.ModTime().Equal(configState.modTime) && info.Size() == configState.size {
+		return false
+	}
+	// Remember the attempt so a broken file is not reloaded on every poll.
+	configState.modTime, configState.size = info.ModTime(), info.Size()
+	return true
+}
+
+// watchConfig reloads the config on SIGHUP and whenever its file changes.
+func watchConfig() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			logger.Info("SIGHUP received, reloading config")
+			reloadConfig()
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if configFileChanged(configPath()) {
+				reloadConfig()
+			}
+		}
+	}()
+}
+
+var dsnPassword = regexp.MustCompile(`password=\S+`)
+
+// redactConfig returns a copy of c with secrets masked.
+func redactConfig(c *Config) Config {
+	r := *c
+	mask := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	mask(&r.Directory.LDAP.BindPassword)
+	mask(&r.SCIM.Token)
+	mask(&r.Notifications.SMTP.Password)
+	mask(&r.Notifications.WebhookSecret)
+
+	// Providers are copied so the running config keeps its headers.
+	r.Warranty.Providers = make([]WarrantyProviderConfig, len(c.Warranty.Providers))
+	for i, p := range c.Warranty.Providers {
+		headers := make(map[string]string, len(p.Headers))
+		for name := range p.Headers {
+			headers[name] = redacted
+		}
+		p.Headers = headers
+		r.Warranty.Providers[i] = p
+	}
+
+	if u, err := url.Parse(r.Database.DSN); err == nil && u.User != nil {
+		r.Database.DSN = u.Redacted()
+	} else {
+		r.Database.DSN = dsnPassword.ReplaceAllString(r.Database.DSN, "password="+redacted)
+	}
+	return r
+}
+
+// getEffectiveConfig returns the running config with secrets redacted.
+func getEffectiveConfig(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"config": redactConfig(config()), "status": currentConfigStatus()})
+}
+
+// reloadConfigNow rereads the config file on request.
+func reloadConfigNow(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	if err := reloadConfig(); err != nil {
+		respondWithError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"config": redactConfig(config()), "status": currentConfigStatus()})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that only restart-only settings are reported as needing a restart
+func TestRestartRequired(t *testing.T) {
+	running := defaultConfig()
+	next := defaultConfig()
+	next.Logging.Level = "debug"
+	next.RateLimit = RateLimitConfig{RequestsPerSecond: 5, Burst: 10}
+	next.Import.Workers = 4
+	assert.Empty(t, restartRequired(running, next))
+
+	next.Server.Addr = ":9090"
+	next.Stock.CheckInterval = Duration{time.Minute}
+	assert.Equal(t, []string{"server.addr", "stock.check_interval"}, restartRequired(running, next))
+}
+
+// Test that secrets are masked without touching the running config
+func TestRedactConfig(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SCIM.Token = "scim-token"
+	cfg.Notifications.SMTP.Password = "smtp-password"
+
+	redactedCfg := redactConfig(cfg)
+	assert.Equal(t, redacted, redactedCfg.SCIM.Token)
+	assert.Equal(t, redacted, redactedCfg.Notifications.SMTP.Password)
+	assert.Empty(t, redactedCfg.Notifications.WebhookSecret)
+	assert.NotContains(t, redactedCfg.Database.DSN, "Priyajit@2002")
+	assert.Contains(t, redactedCfg.Database.DSN, "host=db")
+	assert.Equal(t, "scim-token", cfg.SCIM.Token)
+
+	cfg.Warranty.Providers = []WarrantyProviderConfig{{Name: "acme", Headers: map[string]string{"X-Api-Key": "key"}}}
+	assert.Equal(t, redacted, redactConfig(cfg).Warranty.Providers[0].Headers["X-Api-Key"])
+	assert.Equal(t, "key", cfg.Warranty.Providers[0].Headers["X-Api-Key"])
+
+	cfg.Database.DSN = "postgres://app:secret@db:5432/devices"
+	assert.Equal(t, "postgres://app:xxxxx@db:5432/devices", redactConfig(cfg).Database.DSN)
+}
+
+// Test that an invalid file is rejected as a whole
+func TestReadConfigValidates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"logging": {"level": "debug"}, "import": {"workers": 2}}`), 0600))
+	cfg, err := readConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "debug", cfg.Logging.Level)
+	assert.Equal(t, 2, cfg.Import.Workers)
+	assert.Equal(t, 10000, cfg.Import.QueueSize)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"logging": {"level": "loud"}}`), 0600))
+	_, err = readConfig(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rate_limit": {"requests_per_second": 5}}`), 0600))
+	_, err = readConfig(path)
+	assert.Error(t, err)
+
+	for _, raw := range []string{`{"transforms": {"max_steps": 0}}`, `{"notifications": {"webhook_timeout": "0s"}}`,
+		`{"plugins": {"max_timeout": "-1s"}}`, `{"plugins": {"max_memory_pages": 0}}`,
+		`{"scim": {"max_results": 0}}`, `{"transforms": {"preview_rows": -1}}`, `{"plugins": {"max_module_bytes": 0}}`,
+		`{"plugins": {"default_memory_pages": 2048}}`, `{"plugins": {"default_memory_pages": 0}}`,
+		`{"plugins": {"default_timeout": "10s"}}`, `{"plugins": {"default_timeout": "0s"}}`, `{"plugins": {"refresh_every": "0s"}}`,
+		`{"shares": {"default_ttl": "0s"}}`, `{"shares": {"default_ttl": "900h"}}`, `{"calendar": {"horizon_days": -1}}`} {
+		assert.NoError(t, os.WriteFile(path, []byte(raw), 0600))
+		_, err = readConfig(path)
+		assert.Error(t, err, raw)
+	}
+}
+
+// Test the per-client rate limit and that it follows config changes
+func TestAllowRequest(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limit := RateLimitConfig{RequestsPerSecond: 1, Burst: 2}
+
+	assert.True(t, allowRequest("10.0.0.1", limit, now))
+	assert.True(t, allowRequest("10.0.0.1", limit, now))
+	assert.False(t, allowRequest("10.0.0.1", limit, now))
+	assert.True(t, allowRequest("10.0.0.2", limit, now))
+	assert.True(t, allowRequest("10.0.0.1", limit, now.Add(time.Second)))
+
+	// A larger burst fills up over time rather than at once.
+	limit.Burst = 5
+	assert.True(t, allowRequest("10.0.0.1", limit, now.Add(time.Minute)))
+	later := now.Add(2 * time.Minute)
+	for i := 0; i < 5; i++ {
+		assert.True(t, allowRequest("10.0.0.1", limit, later))
+	}
+	assert.False(t, allowRequest("10.0.0.1", limit, later))
+}
+
+// Test matching request origins against the allowed list
+func TestOriginAllowed(t *testing.T) {
+	assert.True(t, originAllowed([]string{"https://app.example.com"}, "https://app.example.com"))
+	assert.False(t, originAllowed([]string{"https://app.example.com"}, "https://evil.example.com"))
+	assert.True(t, originAllowed([]string{"*"}, "https://evil.example.com"))
+	assert.False(t, originAllowed(nil, "https://app.example.com"))
+}
@@ -6,14 +6,153 @@ import (
 
 func setupRouter() *gin.Engine {
 	r := gin.Default()
+	r.Use(corsMiddleware(), rateLimitMiddleware())
 
 	r.POST("/device", registerDevice)
 	r.PUT("/device/:id", updateDevice)
 	r.GET("/device", listDevices)
+	r.POST("/device/batch-get", batchGetDevices)
 	r.GET("/device/:id", getDeviceByID)
 	r.DELETE("/device/:id", deleteDevice)
+	r.PUT("/device/:id/position", updateDevicePosition)
+	r.GET("/device/:id/positions", listDevicePositions)
+	r.POST("/agent/checkin", agentCheckIn)
+	r.GET("/geo/devices", exportDevicesGeoJSON)
+	r.PUT("/device/:id/legal-hold", setDeviceLegalHold)
+	r.POST("/device/:id/warranty/lookup", lookupDeviceWarranty)
+	r.GET("/warranty/checks", listWarrantyChecks)
+	r.GET("/device/:id/footprint", getDeviceFootprint)
+	r.POST("/emission-factors", createEmissionFactor)
+	r.GET("/emission-factors", listEmissionFactors)
+	r.PUT("/emission-factors/:id", updateEmissionFactor)
+	r.DELETE("/emission-factors/:id", deleteEmissionFactor)
+	r.POST("/emission-factors/import", importEmissionFactors)
+	r.GET("/reports/sustainability", getSustainabilityReport)
+	r.GET("/reports/lease-obligations", getLeaseObligations)
+	r.GET("/reports/chargeback", getChargeback)
+	r.GET("/reports/valuation", getValuation)
+	r.GET("/reports/mobile-costs", getMobileCostReport)
+	r.POST("/device/:id/shares", createDeviceShare)
+	r.GET("/device/:id/shares", listDeviceShares)
+	r.POST("/shares/:id/revoke", revokeDeviceShare)
+	r.GET("/shares/:id/accesses", listDeviceShareAccesses)
+	r.GET("/shared/:token", openDeviceShare)
+	r.POST("/archive/run", runArchive)
+	r.GET("/archive/devices", searchArchivedDevices)
+	r.POST("/plugins", createPlugin)
+	r.GET("/plugins", listPlugins)
+	r.PUT("/plugins/:id", updatePlugin)
+	r.DELETE("/plugins/:id", deletePlugin)
+	r.POST("/import-transforms", createImportTransform)
+	r.GET("/import-transforms", listImportTransforms)
+	r.PUT("/import-transforms/:id", updateImportTransform)
+	r.DELETE("/import-transforms/:id", deleteImportTransform)
+	r.POST("/import-transforms/preview", previewImportTransforms)
 	r.POST("/upload", uploadCSV)
 	r.GET("/logs", getLogs)
+	r.GET("/employees", listEmployees)
+	r.GET("/employees/:id", getEmployeeByID)
+	r.POST("/employees/:id/offboarding", startEmployeeOffboarding)
+	r.GET("/employees/:id/consumables", listEmployeeConsumables)
+	r.GET("/employees/:id/mobile-lines", listEmployeeMobileLines)
+	r.GET("/employees/:id/personal-data", exportPersonalData)
+	r.POST("/employees/:id/erasure", erasePersonalData)
+	r.GET("/offboardings", listOffboardings)
+	r.GET("/offboardings/:id", getOffboarding)
+	r.PUT("/offboardings/:id/items/:item_id", updateOffboardingItem)
+	r.GET("/offboardings/:id/report", getOffboardingReport)
+	r.POST("/kits", createKitTemplate)
+	r.GET("/kits", listKitTemplates)
+	r.GET("/kits/:id", getKitTemplate)
+	r.PUT("/kits/:id", updateKitTemplate)
+	r.DELETE("/kits/:id", deleteKitTemplate)
+	r.POST("/provisionings", createProvisioning)
+	r.GET("/provisionings/:id", getProvisioning)
+	r.POST("/provisionings/:id/reserve", retryProvisioning)
+	r.POST("/provisionings/:id/checkout", checkoutProvisioningNow)
+	r.POST("/provisionings/:id/cancel", cancelProvisioning)
+	r.POST("/locations", createLocation)
+	r.GET("/locations", listLocations)
+	r.GET("/locations/:id", getLocationByID)
+	r.PUT("/locations/:id", updateLocation)
+	r.POST("/transfers", createTransfer)
+	r.GET("/transfers", listTransfers)
+	r.GET("/transfers/discrepancies", listTransferDiscrepancies)
+	r.POST("/transfers/discrepancies/:id/resolve", resolveTransferDiscrepancy)
+	r.GET("/transfers/:id", getTransfer)
+	r.POST("/transfers/:id/ship", shipTransfer)
+	r.POST("/transfers/:id/receive", receiveTransfer)
+	r.POST("/transfers/:id/close", closeTransfer)
+	r.POST("/transfers/:id/cancel", cancelTransfer)
+	r.POST("/transfers/:id/discrepancies", reportTransferDiscrepancy)
+	r.GET("/device/:id/movements", getDeviceMovements)
+	r.POST("/stock/thresholds", createStockThreshold)
+	r.GET("/stock/thresholds", listStockThresholds)
+	r.PUT("/stock/thresholds/:id", updateStockThreshold)
+	r.DELETE("/stock/thresholds/:id", deleteStockThreshold)
+	r.GET("/stock/levels", getStockLevels)
+	r.GET("/stock/alerts", listStockAlerts)
+	r.POST("/consumables", createConsumable)
+	r.GET("/consumables", listConsumables)
+	r.GET("/consumables/alerts", listConsumableAlerts)
+	r.GET("/consumables/:id", getConsumable)
+	r.PUT("/consumables/:id", updateConsumable)
+	r.PUT("/consumables/:id/stock/:location_id", setConsumableMinimum)
+	r.POST("/consumables/:id/receive", receiveConsumable)
+	r.POST("/consumables/:id/issue", issueConsumable)
+	r.POST("/consumables/:id/transfer", transferConsumable)
+	r.GET("/consumables/:id/ledger", listConsumableLedger)
+	r.POST("/device/:id/interfaces", createInterface)
+	r.GET("/device/:id/interfaces", listDeviceInterfaces)
+	r.PUT("/interfaces/:id", updateInterface)
+	r.DELETE("/interfaces/:id", deleteInterface)
+	r.GET("/interfaces/lookup", lookupInterface)
+	r.GET("/interfaces/export", exportInterfaces)
+	r.GET("/device/:id/sims", getDeviceSIMs)
+	r.POST("/sims", createSIM)
+	r.GET("/sims", listSIMs)
+	r.POST("/sims/:id/attach", attachSIM)
+	r.POST("/sims/:id/detach", detachSIM)
+	r.GET("/sims/:id/history", getSIMHistory)
+	r.POST("/mobile-lines", createMobileLine)
+	r.GET("/mobile-lines", listMobileLines)
+	r.PUT("/mobile-lines/:id", updateMobileLine)
+	r.POST("/mobile-lines/:id/cancel", cancelMobileLine)
+	r.POST("/leases", createLease)
+	r.GET("/leases", listLeases)
+	r.GET("/leases/:id", getLease)
+	r.PUT("/leases/:id", updateLease)
+	r.POST("/leases/:id/devices", addDevicesToLease)
+	r.POST("/leases/:id/devices/:device_id/decision", decideLeaseDevice)
+	r.POST("/leases/:id/devices/:device_id/complete", completeLeaseDevice)
+	r.POST("/calendar/feeds", createCalendarFeed)
+	r.GET("/calendar/feeds", listCalendarFeeds)
+	r.DELETE("/calendar/feeds/:id", deleteCalendarFeed)
+	r.GET("/ical/:token", serveCalendarFeed)
+	r.POST("/searches", createSavedSearch)
+	r.GET("/searches", listSavedSearches)
+	r.GET("/searches/:id", getSavedSearch)
+	r.PUT("/searches/:id", updateSavedSearch)
+	r.DELETE("/searches/:id", deleteSavedSearch)
+	r.GET("/searches/:id/run", runSavedSearch)
+	r.GET("/searches/:id/export", exportSavedSearch)
+	r.POST("/searches/:id/subscription", subscribeSavedSearch)
+	r.DELETE("/searches/:id/subscription", unsubscribeSavedSearch)
+	r.GET("/searches/:id/changes", listSavedSearchChanges)
+	r.POST("/watches", createWatch)
+	r.GET("/watches", listWatches)
+	r.DELETE("/watches/:id", deleteWatch)
+	r.GET("/notifications", listNotifications)
+	r.POST("/notifications/read-all", markAllNotificationsRead)
+	r.POST("/notifications/:id/read", markNotification(true))
+	r.POST("/notifications/:id/unread", markNotification(false))
+	r.GET("/notification-preferences", getNotificationPreference)
+	r.PUT("/notification-preferences", updateNotificationPreference)
+	r.POST("/directory/sync", runDirectorySync)
+	r.GET("/directory/status", getDirectorySyncStatus)
+	r.GET("/directory/flagged-devices", listFlaggedDevices)
+	registerSCIMRoutes(r)
+	registerAdminRoutes(r)
 
 	return r
 }
@@ -0,0 +1,1157 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SCIM 2.0 provisioning (RFC 7643/7644). Users map onto Employee rows and
+// Groups onto Group rows; an employee's Role is derived from the groups they
+// belong to using config.SCIM.GroupRoles.
+
+const (
+	scimUserSchema       = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema      = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimEnterpriseSchema = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	scimListSchema       = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema      = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Group is a set of employees pushed by the identity provider.
+type Group struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	DisplayName string     `gorm:"column:display_name;uniqueIndex" json:"display_name"`
+	ExternalID  string     `gorm:"column:external_id" json:"external_id"`
+	Members     []Employee `gorm:"many2many:group_members" json:"members"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+var scimUserColumns = map[string]scimColumn{
+	"id":                {Name: "id", Kind: scimInt},
+	"username":          {Name: "user_name", Kind: scimString},
+	"externalid":        {Name: "external_id", Kind: scimString},
+	"emails":            {Name: "email", Kind: scimString},
+	"emails.value":      {Name: "email", Kind: scimString},
+	"name.givenname":    {Name: "first_name", Kind: scimString},
+	"name.familyname":   {Name: "last_name", Kind: scimString},
+	"title":             {Name: "title", Kind: scimString},
+	"active":            {Name: "active", Kind: scimBool},
+	"meta.created":      {Name: "created_at", Kind: scimTime},
+	"meta.lastmodified": {Name: "updated_at", Kind: scimTime},
+	strings.ToLower(scimEnterpriseSchema + ":department"): {Name: "department", Kind: scimString},
+}
+
+var scimGroupColumns = map[string]scimColumn{
+	"id":                {Name: "id", Kind: scimInt},
+	"displayname":       {Name: "display_name", Kind: scimString},
+	"externalid":        {Name: "external_id", Kind: scimString},
+	"meta.created":      {Name: "created_at", Kind: scimTime},
+	"meta.lastmodified": {Name: "updated_at", Kind: scimTime},
+}
+
+type scimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+type scimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimEnterprise struct {
+	Department string          `json:"department,omitempty"`
+	Manager    *scimMultiValue `json:"manager,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type scimUser struct {
+	Schemas    []string         `json:"schemas"`
+	ID         string           `json:"id,omitempty"`
+	ExternalID string           `json:"externalId,omitempty"`
+	UserName   string           `json:"userName"`
+	Name       *scimName        `json:"name,omitempty"`
+	Emails     []scimMultiValue `json:"emails,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Active     *bool            `json:"active,omitempty"`
+	Roles      []scimMultiValue `json:"roles,omitempty"`
+	Groups     []scimMultiValue `json:"groups,omitempty"`
+	Enterprise *scimEnterprise  `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta       *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []scimMultiValue `json:"members"`
+	Meta        *scimMeta        `json:"meta,omitempty"`
+}
+
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// scimError is returned by helpers so handlers can answer with the right
+// status and scimType.
+type scimError struct {
+	Status   int
+	SCIMType string
+	Detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.Detail
+}
+
+func newSCIMError(status int, scimType, format string, args ...interface{}) *scimError {
+	return &scimError{Status: status, SCIMType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func scimJSON(c *gin.Context, code int, obj interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(code, obj)
+}
+
+func respondWithSCIMError(c *gin.Context, err error) {
+	var se *scimError
+	if !errors.As(err, &se) {
+		logger.Errorf("SCIM request failed: %v", err)
+		se = &scimError{Status: http.StatusInternalServerError, Detail: "Internal server error"}
+	}
+	body := gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(se.Status),
+		"detail":  se.Detail,
+	}
+	if se.SCIMType != "" {
+		body["scimType"] = se.SCIMType
+	}
+	scimJSON(c, se.Status, body)
+}
+
+// requireSCIMToken checks the bearer token configured for the identity provider.
+func requireSCIMToken(c *gin.Context) {
+	token := config().SCIM.Token
+	header := c.GetHeader("Authorization")
+	if token == "" || !strings.HasPrefix(header, "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(token)) != 1 {
+		logger.Warnf("Rejected SCIM request from %s", c.ClientIP())
+		respondWithSCIMError(c, newSCIMError(http.StatusUnauthorized, "", "Invalid or missing bearer token"))
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+func registerSCIMRoutes(r *gin.Engine) {
+	scim := r.Group("/scim/v2", requireSCIMToken)
+	scim.GET("/Users", scimListUsers)
+	scim.POST("/Users", scimCreateUser)
+	scim.GET("/Users/:id", scimGetUser)
+	scim.PUT("/Users/:id", scimReplaceUser)
+	scim.PATCH("/Users/:id", scimPatchUser)
+	scim.DELETE("/Users/:id", scimDeleteUser)
+	scim.GET("/Groups", scimListGroups)
+	scim.POST("/Groups", scimCreateGroup)
+	scim.GET("/Groups/:id", scimGetGroup)
+	scim.PUT("/Groups/:id", scimReplaceGroup)
+	scim.PATCH("/Groups/:id", scimPatchGroup)
+	scim.DELETE("/Groups/:id", scimDeleteGroup)
+}
+
+// scimPagination reads startIndex (1-based) and count per RFC 7644 3.4.2.4.
+func scimPagination(c *gin.Context) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	max := config().SCIM.MaxResults
+	count, err = strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(max)))
+	if err != nil || count < 0 {
+		count = max
+	}
+	if count > max {
+		count = max
+	}
+	return startIndex, count
+}
+
+func scimApplyFilter(query *gorm.DB, filter string, columns map[string]scimColumn) (*gorm.DB, error) {
+	if filter == "" {
+		return query, nil
+	}
+	parsed, err := parseSCIMFilter(filter)
+	if err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	clause, args, err := parsed.toSQL(columns)
+	if err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, "invalidFilter", "%v", err)
+	}
+	return query.Where(clause, args...), nil
+}
+
+func scimResourceID(c *gin.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, newSCIMError(http.StatusNotFound, "", "Resource %s not found", c.Param("id"))
+	}
+	return uint(id), nil
+}
+
+func scimLocation(c *gin.Context, resource string, id uint) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%d", scheme, c.Request.Host, resource, id)
+}
+
+// Users
+
+func employeeToSCIM(c *gin.Context, e Employee, groups []Group) scimUser {
+	active := e.Active
+	user := scimUser{
+		Schemas:    []string{scimUserSchema, scimEnterpriseSchema},
+		ID:         strconv.FormatUint(uint64(e.ID), 10),
+		ExternalID: e.ExternalID,
+		UserName:   e.UserName,
+		Name: &scimName{
+			GivenName:  e.FirstName,
+			FamilyName: e.LastName,
+			Formatted:  strings.TrimSpace(e.FirstName + " " + e.LastName),
+		},
+		Title:  e.Title,
+		Active: &active,
+		Enterprise: &scimEnterprise{
+			Department: e.Department,
+		},
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      e.CreatedAt,
+			LastModified: e.UpdatedAt,
+			Location:     scimLocation(c, "Users", e.ID),
+		},
+	}
+	if e.Email != "" {
+		user.Emails = []scimMultiValue{{Value: e.Email, Type: "work", Primary: true}}
+	}
+	if e.Role != "" {
+		user.Roles = []scimMultiValue{{Value: e.Role}}
+	}
+	if e.ManagerID != nil {
+		user.Enterprise.Manager = &scimMultiValue{Value: strconv.FormatUint(uint64(*e.ManagerID), 10)}
+	}
+	for _, g := range groups {
+		user.Groups = append(user.Groups, scimMultiValue{
+			Value:   strconv.FormatUint(uint64(g.ID), 10),
+			Display: g.DisplayName,
+			Ref:     scimLocation(c, "Groups", g.ID),
+		})
+	}
+	return user
+}
+
+// applySCIMUser copies the writable attributes of a SCIM user onto an employee.
+func applySCIMUser(e *Employee, user scimUser) error {
+	if user.UserName == "" {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+	e.UserName = user.UserName
+	e.ExternalID = user.ExternalID
+	if e.ExternalID == "" {
+		// external_id is unique, so fall back to userName, which is unique too.
+		e.ExternalID = user.UserName
+	}
+	e.FirstName, e.LastName = "", ""
+	if user.Name != nil {
+		e.FirstName = user.Name.GivenName
+		e.LastName = user.Name.FamilyName
+	}
+	e.Email = primaryEmail(user.Emails)
+	e.Title = user.Title
+	e.Active = user.Active == nil || *user.Active
+	e.Department = ""
+	e.ManagerID = nil
+	if user.Enterprise != nil {
+		e.Department = user.Enterprise.Department
+		if user.Enterprise.Manager != nil && user.Enterprise.Manager.Value != "" {
+			managerID, err := strconv.ParseUint(user.Enterprise.Manager.Value, 10, 64)
+			if err != nil {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "manager must reference a user id")
+			}
+			id := uint(managerID)
+			e.ManagerID = &id
+		}
+	}
+	return nil
+}
+
+func primaryEmail(emails []scimMultiValue) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+func groupsForEmployee(tx *gorm.DB, employeeID uint) ([]Group, error) {
+	var groups []Group
+	err := tx.Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.employee_id = ?", employeeID).Find(&groups).Error
+	return groups, err
+}
+
+func loadSCIMUser(c *gin.Context, tx *gorm.DB, id uint) (scimUser, error) {
+	var employee Employee
+	if err := tx.First(&employee, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return scimUser{}, newSCIMError(http.StatusNotFound, "", "User %d not found", id)
+		}
+		return scimUser{}, err
+	}
+	groups, err := groupsForEmployee(tx, id)
+	if err != nil {
+		return scimUser{}, err
+	}
+	return employeeToSCIM(c, employee, groups), nil
+}
+
+// saveSCIMEmployee writes an employee, keeping DeactivatedAt in step with Active.
+func saveSCIMEmployee(tx *gorm.DB, e *Employee, wasActive bool) error {
+	if !e.Active && wasActive {
+		now := time.Now()
+		e.DeactivatedAt = &now
+	} else if e.Active {
+		e.DeactivatedAt = nil
+	}
+	err := tx.Save(e).Error
+	if err != nil && isUniqueViolation(err) {
+		return newSCIMError(http.StatusConflict, "uniqueness", "userName or externalId already exists")
+	}
+	if err == nil && !e.Active && wasActive {
+		_, err = startOffboarding(tx, e.ID, *e.DeactivatedAt)
+	}
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key")
+}
+
+func scimListUsers(c *gin.Context) {
+	startIndex, count := scimPagination(c)
+
+	query, err := scimApplyFilter(db.Model(&Employee{}), c.Query("filter"), scimUserColumns)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	var employees []Employee
+	if count > 0 {
+		if err := query.Order("id").Offset(startIndex - 1).Limit(count).Find(&employees).Error; err != nil {
+			respondWithSCIMError(c, err)
+			return
+		}
+	}
+
+	users := make([]scimUser, 0, len(employees))
+	for _, e := range employees {
+		groups, err := groupsForEmployee(db, e.ID)
+		if err != nil {
+			respondWithSCIMError(c, err)
+			return
+		}
+		users = append(users, employeeToSCIM(c, e, groups))
+	}
+
+	scimJSON(c, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+func scimGetUser(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	user, err := loadSCIMUser(c, db, id)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+func scimCreateUser(c *gin.Context) {
+	var user scimUser
+	if err := c.ShouldBindJSON(&user); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+
+	employee := Employee{Source: "scim", Role: config().SCIM.DefaultRole}
+	if err := applySCIMUser(&employee, user); err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	if err := saveSCIMEmployee(db, &employee, employee.Active); err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM user created: %s (%d)", employee.UserName, employee.ID)
+	scimJSON(c, http.StatusCreated, employeeToSCIM(c, employee, nil))
+}
+
+func scimReplaceUser(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	var user scimUser
+	if err := c.ShouldBindJSON(&user); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var employee Employee
+		if err := tx.First(&employee, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newSCIMError(http.StatusNotFound, "", "User %d not found", id)
+			}
+			return err
+		}
+		wasActive := employee.Active
+		if err := applySCIMUser(&employee, user); err != nil {
+			return err
+		}
+		return saveSCIMEmployee(tx, &employee, wasActive)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM user replaced: %d", id)
+	scimGetUser(c)
+}
+
+func scimPatchUser(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	var patch scimPatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		user, err := loadSCIMUser(c, tx, id)
+		if err != nil {
+			return err
+		}
+		wasActive := user.Active != nil && *user.Active
+		for _, op := range patch.Operations {
+			if err := applyUserPatch(&user, op); err != nil {
+				return err
+			}
+		}
+		var employee Employee
+		if err := tx.First(&employee, id).Error; err != nil {
+			return err
+		}
+		if err := applySCIMUser(&employee, user); err != nil {
+			return err
+		}
+		return saveSCIMEmployee(tx, &employee, wasActive)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM user patched: %d", id)
+	scimGetUser(c)
+}
+
+// scimDeleteUser deactivates rather than deletes, so device history that
+// points at the employee stays intact.
+func scimDeleteUser(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var employee Employee
+		if err := tx.First(&employee, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return newSCIMError(http.StatusNotFound, "", "User %d not found", id)
+			}
+			return err
+		}
+		wasActive := employee.Active
+		employee.Active = false
+		return saveSCIMEmployee(tx, &employee, wasActive)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM user deactivated: %d", id)
+	c.Status(http.StatusNoContent)
+}
+
+// applyUserPatch applies one PATCH operation (RFC 7644 3.5.2) to a user.
+func applyUserPatch(user *scimUser, op scimPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "unknown patch op %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return newSCIMError(http.StatusBadRequest, "noTarget", "remove requires a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+		}
+		for attr, value := range values {
+			if err := setUserAttribute(user, attr, value, kind); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return setUserAttribute(user, op.Path, op.Value, kind)
+}
+
+func setUserAttribute(user *scimUser, path string, raw json.RawMessage, kind string) error {
+	remove := kind == "remove"
+	attr := strings.ToLower(path)
+	attr = strings.TrimPrefix(attr, strings.ToLower(scimUserSchema)+":")
+
+	if user.Name == nil {
+		user.Name = &scimName{}
+	}
+	if user.Enterprise == nil {
+		user.Enterprise = &scimEnterprise{}
+	}
+	enterprise := strings.ToLower(scimEnterpriseSchema)
+
+	switch attr {
+	case "username":
+		if remove {
+			return newSCIMError(http.StatusBadRequest, "mutability", "userName cannot be removed")
+		}
+		return decodeSCIMString(raw, &user.UserName)
+	case "externalid":
+		if remove {
+			user.ExternalID = ""
+			return nil
+		}
+		return decodeSCIMString(raw, &user.ExternalID)
+	case "title":
+		if remove {
+			user.Title = ""
+			return nil
+		}
+		return decodeSCIMString(raw, &user.Title)
+	case "active":
+		active := false
+		if !remove {
+			if err := decodeSCIMBool(raw, &active); err != nil {
+				return err
+			}
+		}
+		user.Active = &active
+		return nil
+	case "name":
+		if remove {
+			user.Name = &scimName{}
+			return nil
+		}
+		var name scimName
+		if err := json.Unmarshal(raw, &name); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "invalid name: %v", err)
+		}
+		if kind == "replace" {
+			user.Name = &name
+			return nil
+		}
+		if name.GivenName != "" {
+			user.Name.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			user.Name.FamilyName = name.FamilyName
+		}
+		return nil
+	case "name.givenname":
+		if remove {
+			user.Name.GivenName = ""
+			return nil
+		}
+		return decodeSCIMString(raw, &user.Name.GivenName)
+	case "name.familyname":
+		if remove {
+			user.Name.FamilyName = ""
+			return nil
+		}
+		return decodeSCIMString(raw, &user.Name.FamilyName)
+	case "emails", "emails.value", `emails[type eq "work"].value`, "emails[primary eq true].value":
+		if remove {
+			user.Emails = nil
+			return nil
+		}
+		if attr == "emails" {
+			var emails []scimMultiValue
+			if err := json.Unmarshal(raw, &emails); err != nil {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "invalid emails: %v", err)
+			}
+			user.Emails = emails
+			return nil
+		}
+		var email string
+		if err := decodeSCIMString(raw, &email); err != nil {
+			return err
+		}
+		user.Emails = []scimMultiValue{{Value: email, Type: "work", Primary: true}}
+		return nil
+	case enterprise, enterprise + ":department", "department":
+		if attr == enterprise {
+			if remove {
+				user.Enterprise = &scimEnterprise{}
+				return nil
+			}
+			var ext scimEnterprise
+			if err := json.Unmarshal(raw, &ext); err != nil {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "invalid enterprise extension: %v", err)
+			}
+			user.Enterprise = &ext
+			return nil
+		}
+		if remove {
+			user.Enterprise.Department = ""
+			return nil
+		}
+		return decodeSCIMString(raw, &user.Enterprise.Department)
+	case enterprise + ":manager", enterprise + ":manager.value", "manager":
+		if remove {
+			user.Enterprise.Manager = nil
+			return nil
+		}
+		var manager scimMultiValue
+		if err := json.Unmarshal(raw, &manager); err != nil {
+			// Some providers send the bare id instead of {"value": id}.
+			if err := decodeSCIMString(raw, &manager.Value); err != nil {
+				return err
+			}
+		}
+		user.Enterprise.Manager = &manager
+		return nil
+	case "schemas", "id", "meta", "groups", "roles":
+		// read-only or managed elsewhere; ignored as RFC 7644 allows
+		return nil
+	}
+	return newSCIMError(http.StatusBadRequest, "invalidPath", "unsupported path %q", path)
+}
+
+func decodeSCIMString(raw json.RawMessage, dst *string) error {
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "expected a string value")
+	}
+	return nil
+}
+
+// decodeSCIMBool accepts JSON booleans and the "True"/"False" strings some
+// providers send.
+func decodeSCIMBool(raw json.RawMessage, dst *bool) error {
+	if err := json.Unmarshal(raw, dst); err == nil {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			*dst = b
+			return nil
+		}
+	}
+	return newSCIMError(http.StatusBadRequest, "invalidValue", "expected a boolean value")
+}
+
+// Groups
+
+func groupToSCIM(c *gin.Context, g Group) scimGroup {
+	group := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          strconv.FormatUint(uint64(g.ID), 10),
+		ExternalID:  g.ExternalID,
+		DisplayName: g.DisplayName,
+		Members:     []scimMultiValue{},
+		Meta: &scimMeta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     scimLocation(c, "Groups", g.ID),
+		},
+	}
+	for _, m := range g.Members {
+		group.Members = append(group.Members, scimMultiValue{
+			Value:   strconv.FormatUint(uint64(m.ID), 10),
+			Display: m.UserName,
+			Ref:     scimLocation(c, "Users", m.ID),
+		})
+	}
+	return group
+}
+
+func loadGroup(tx *gorm.DB, id uint) (Group, error) {
+	var group Group
+	if err := tx.Preload("Members").First(&group, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return group, newSCIMError(http.StatusNotFound, "", "Group %d not found", id)
+		}
+		return group, err
+	}
+	return group, nil
+}
+
+// memberEmployees resolves SCIM member references to existing employees.
+func memberEmployees(tx *gorm.DB, members []scimMultiValue) ([]Employee, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, 0, len(members))
+	for _, m := range members {
+		id, err := strconv.ParseUint(m.Value, 10, 64)
+		if err != nil {
+			return nil, newSCIMError(http.StatusBadRequest, "invalidValue", "member %q is not a user id", m.Value)
+		}
+		ids = append(ids, uint(id))
+	}
+	var employees []Employee
+	if err := tx.Where("id IN ?", ids).Find(&employees).Error; err != nil {
+		return nil, err
+	}
+	if len(employees) != len(uniqueUints(ids)) {
+		return nil, newSCIMError(http.StatusBadRequest, "invalidValue", "one or more members do not exist")
+	}
+	return employees, nil
+}
+
+func uniqueUints(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var out []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func employeeIDs(employees []Employee) []uint {
+	ids := make([]uint, len(employees))
+	for i, e := range employees {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+// refreshRoles recomputes the role of each employee from their group
+// membership. The first entry in config.SCIM.GroupRoles that matches wins.
+func refreshRoles(tx *gorm.DB, ids []uint) error {
+	for _, id := range uniqueUints(ids) {
+		groups, err := groupsForEmployee(tx, id)
+		if err != nil {
+			return err
+		}
+		role := roleForGroups(groups)
+		if err := tx.Model(&Employee{}).Where("id = ?", id).Update("role", role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func roleForGroups(groups []Group) string {
+	for _, mapping := range config().SCIM.GroupRoles {
+		for _, g := range groups {
+			if strings.EqualFold(g.DisplayName, mapping.Group) {
+				return mapping.Role
+			}
+		}
+	}
+	return config().SCIM.DefaultRole
+}
+
+// saveGroupMembers replaces a group's members and refreshes the roles of
+// everyone who joined or left.
+func saveGroupMembers(tx *gorm.DB, group *Group, members []Employee) error {
+	affected := append(employeeIDs(group.Members), employeeIDs(members)...)
+	if err := tx.Model(group).Association("Members").Replace(members); err != nil {
+		return err
+	}
+	group.Members = members
+	return refreshRoles(tx, affected)
+}
+
+func scimListGroups(c *gin.Context) {
+	startIndex, count := scimPagination(c)
+
+	query, err := scimApplyFilter(db.Model(&Group{}), c.Query("filter"), scimGroupColumns)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	var groups []Group
+	if count > 0 {
+		// excludedAttributes=members is common for large groups.
+		if !strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members") {
+			query = query.Preload("Members")
+		}
+		if err := query.Order("id").Offset(startIndex - 1).Limit(count).Find(&groups).Error; err != nil {
+			respondWithSCIMError(c, err)
+			return
+		}
+	}
+
+	resources := make([]scimGroup, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, groupToSCIM(c, g))
+	}
+
+	scimJSON(c, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func scimGetGroup(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	group, err := loadGroup(db, id)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, groupToSCIM(c, group))
+}
+
+// groupSaveError reports a displayName that is already taken as a SCIM
+// uniqueness error.
+func groupSaveError(err error, group Group) error {
+	if isUniqueViolation(err) {
+		return newSCIMError(http.StatusConflict, "uniqueness", "Group %q already exists", group.DisplayName)
+	}
+	return err
+}
+
+func scimCreateGroup(c *gin.Context) {
+	var body scimGroup
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+	if body.DisplayName == "" {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidValue", "displayName is required"))
+		return
+	}
+
+	group := Group{DisplayName: body.DisplayName, ExternalID: body.ExternalID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		members, err := memberEmployees(tx, body.Members)
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("Members").Create(&group).Error; err != nil {
+			return groupSaveError(err, group)
+		}
+		return saveGroupMembers(tx, &group, members)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM group created: %s (%d)", group.DisplayName, group.ID)
+	scimJSON(c, http.StatusCreated, groupToSCIM(c, group))
+}
+
+func scimReplaceGroup(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	var body scimGroup
+	if err := c.ShouldBindJSON(&body); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+	if body.DisplayName == "" {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidValue", "displayName is required"))
+		return
+	}
+
+	var group Group
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if group, err = loadGroup(tx, id); err != nil {
+			return err
+		}
+		members, err := memberEmployees(tx, body.Members)
+		if err != nil {
+			return err
+		}
+		group.DisplayName = body.DisplayName
+		group.ExternalID = body.ExternalID
+		if err := tx.Omit("Members").Save(&group).Error; err != nil {
+			return groupSaveError(err, group)
+		}
+		return saveGroupMembers(tx, &group, members)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM group replaced: %d", id)
+	scimJSON(c, http.StatusOK, groupToSCIM(c, group))
+}
+
+func scimPatchGroup(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+	var patch scimPatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		respondWithSCIMError(c, newSCIMError(http.StatusBadRequest, "invalidSyntax", "%v", err))
+		return
+	}
+
+	var group Group
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if group, err = loadGroup(tx, id); err != nil {
+			return err
+		}
+		members := make(map[string]scimMultiValue)
+		for _, m := range groupToSCIM(c, group).Members {
+			members[m.Value] = m
+		}
+		for _, op := range patch.Operations {
+			if err := applyGroupPatch(&group, members, op); err != nil {
+				return err
+			}
+		}
+		list := make([]scimMultiValue, 0, len(members))
+		for _, m := range members {
+			list = append(list, m)
+		}
+		employees, err := memberEmployees(tx, list)
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("Members").Save(&group).Error; err != nil {
+			return groupSaveError(err, group)
+		}
+		return saveGroupMembers(tx, &group, employees)
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM group patched: %d", id)
+	scimJSON(c, http.StatusOK, groupToSCIM(c, group))
+}
+
+// applyGroupPatch handles displayName changes and member add/remove/replace,
+// including the members[value eq "id"] removal form.
+func applyGroupPatch(group *Group, members map[string]scimMultiValue, op scimPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	path := strings.ToLower(op.Path)
+
+	if path == "" {
+		if kind == "remove" {
+			return newSCIMError(http.StatusBadRequest, "noTarget", "remove requires a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "value must be an object when path is omitted")
+		}
+		for attr, value := range values {
+			if err := applyGroupPatch(group, members, scimPatchOperation{Op: op.Op, Path: attr, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case path == "displayname":
+		if kind == "remove" {
+			return newSCIMError(http.StatusBadRequest, "mutability", "displayName cannot be removed")
+		}
+		return decodeSCIMString(op.Value, &group.DisplayName)
+	case path == "externalid":
+		if kind == "remove" {
+			group.ExternalID = ""
+			return nil
+		}
+		return decodeSCIMString(op.Value, &group.ExternalID)
+	case path == "members":
+		var values []scimMultiValue
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return newSCIMError(http.StatusBadRequest, "invalidValue", "members must be a list")
+			}
+		}
+		switch kind {
+		case "add":
+			for _, v := range values {
+				members[v.Value] = v
+			}
+		case "replace":
+			for k := range members {
+				delete(members, k)
+			}
+			for _, v := range values {
+				members[v.Value] = v
+			}
+		case "remove":
+			if len(values) == 0 {
+				for k := range members {
+					delete(members, k)
+				}
+			}
+			for _, v := range values {
+				delete(members, v.Value)
+			}
+		default:
+			return newSCIMError(http.StatusBadRequest, "invalidSyntax", "unknown patch op %q", op.Op)
+		}
+		return nil
+	case strings.HasPrefix(path, "members[") && kind == "remove":
+		filter, err := parseSCIMFilter(strings.TrimSuffix(strings.TrimPrefix(op.Path[len("members"):], "["), "]"))
+		if err != nil {
+			return newSCIMError(http.StatusBadRequest, "invalidPath", "%v", err)
+		}
+		cmp, ok := filter.(scimCompare)
+		if !ok || !strings.EqualFold(cmp.Attr, "value") || cmp.Op != "eq" {
+			return newSCIMError(http.StatusBadRequest, "invalidPath", "only members[value eq \"id\"] is supported")
+		}
+		value, _ := cmp.Value.(string)
+		delete(members, value)
+		return nil
+	}
+	return newSCIMError(http.StatusBadRequest, "invalidPath", "unsupported path %q", op.Path)
+}
+
+func scimDeleteGroup(c *gin.Context) {
+	id, err := scimResourceID(c)
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		group, err := loadGroup(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&group).Association("Members").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(&group).Error; err != nil {
+			return err
+		}
+		return refreshRoles(tx, employeeIDs(group.Members))
+	})
+	if err != nil {
+		respondWithSCIMError(c, err)
+		return
+	}
+
+	logger.Infof("SCIM group deleted: %d", id)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SCIM filter support (RFC 7644 section 3.4.2.2). Filters are parsed into a
+// small expression tree and translated to a SQL WHERE clause against a fixed
+// attribute-to-column mapping, so only mapped attributes can be filtered on.
+
+type scimColumnKind int
+
+const (
+	scimString scimColumnKind = iota
+	scimBool
+	scimInt
+	scimTime
+)
+
+type scimColumn struct {
+	Name string
+	Kind scimColumnKind
+}
+
+type scimFilter interface {
+	toSQL(columns map[string]scimColumn) (string, []interface{}, error)
+}
+
+type scimLogical struct {
+	Op          string // "and" or "or"
+	Left, Right scimFilter
+}
+
+type scimNot struct {
+	Inner scimFilter
+}
+
+type scimCompare struct {
+	Attr  string
+	Op    string
+	Value interface{} // string, float64, bool or nil
+}
+
+func (f scimLogical) toSQL(columns map[string]scimColumn) (string, []interface{}, error) {
+	left, leftArgs, err := f.Left.toSQL(columns)
+	if err != nil {
+		return "", nil, err
+	}
+	right, rightArgs, err := f.Right.toSQL(columns)
+	if err != nil {
+		return "", nil, err
+	}
+	return "(" + left + " " + strings.ToUpper(f.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+}
+
+func (f scimNot) toSQL(columns map[string]scimColumn) (string, []interface{}, error) {
+	inner, args, err := f.Inner.toSQL(columns)
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT (" + inner + ")", args, nil
+}
+
+func (f scimCompare) toSQL(columns map[string]scimColumn) (string, []interface{}, error) {
+	column, ok := columns[strings.ToLower(f.Attr)]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported filter attribute %q", f.Attr)
+	}
+	col := column.Name
+
+	if f.Op == "pr" {
+		if column.Kind == scimString {
+			return "(" + col + " IS NOT NULL AND " + col + " <> '')", nil, nil
+		}
+		return col + " IS NOT NULL", nil, nil
+	}
+
+	if column.Kind == scimString {
+		s, ok := f.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("attribute %q expects a string", f.Attr)
+		}
+		// String attributes are compared case-insensitively (caseExact=false).
+		lower := "LOWER(" + col + ")"
+		s = strings.ToLower(s)
+		switch f.Op {
+		case "eq":
+			return lower + " = ?", []interface{}{s}, nil
+		case "ne":
+			return lower + " <> ?", []interface{}{s}, nil
+		case "co":
+			return lower + " LIKE ?", []interface{}{"%" + escapeLike(s) + "%"}, nil
+		case "sw":
+			return lower + " LIKE ?", []interface{}{escapeLike(s) + "%"}, nil
+		case "ew":
+			return lower + " LIKE ?", []interface{}{"%" + escapeLike(s)}, nil
+		case "gt", "ge", "lt", "le":
+			return lower + " " + scimOrderOps[f.Op] + " ?", []interface{}{s}, nil
+		}
+		return "", nil, fmt.Errorf("unsupported operator %q", f.Op)
+	}
+
+	var value interface{}
+	switch column.Kind {
+	case scimBool:
+		b, ok := f.Value.(bool)
+		if !ok {
+			return "", nil, fmt.Errorf("attribute %q expects a boolean", f.Attr)
+		}
+		value = b
+		if f.Op != "eq" && f.Op != "ne" {
+			return "", nil, fmt.Errorf("operator %q is not valid for booleans", f.Op)
+		}
+	case scimInt:
+		switch v := f.Value.(type) {
+		case float64:
+			value = int64(v)
+		case string:
+			// ids are strings in SCIM but integers here
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return "", nil, fmt.Errorf("attribute %q expects a number", f.Attr)
+			}
+			value = n
+		default:
+			return "", nil, fmt.Errorf("attribute %q expects a number", f.Attr)
+		}
+	case scimTime:
+		s, ok := f.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("attribute %q expects a timestamp", f.Attr)
+		}
+		value = s
+	}
+
+	switch f.Op {
+	case "eq":
+		return col + " = ?", []interface{}{value}, nil
+	case "ne":
+		return col + " <> ?", []interface{}{value}, nil
+	case "gt", "ge", "lt", "le":
+		return col + " " + scimOrderOps[f.Op] + " ?", []interface{}{value}, nil
+	}
+	return "", nil, fmt.Errorf("operator %q is not valid for attribute %q", f.Op, f.Attr)
+}
+
+var scimOrderOps = map[string]string{"gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// parseSCIMFilter parses a filter expression such as
+// `userName eq "bjensen" and (active eq true or title pr)`.
+func parseSCIMFilter(input string) (scimFilter, error) {
+	tokens, err := tokenizeSCIMFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &scimFilterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return filter, nil
+}
+
+type scimToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeSCIMFilter(input string) ([]scimToken, error) {
+	var tokens []scimToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, scimToken{text: string(r)})
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(string(runes[i:j+1])), &s); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %v", err)
+			}
+			tokens = append(tokens, scimToken{text: s, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '(' && runes[j] != ')' && runes[j] != '"' {
+				if runes[j] == '[' {
+					return nil, fmt.Errorf("value filters on multi-valued attributes are not supported")
+				}
+				j++
+			}
+			tokens = append(tokens, scimToken{text: string(runes[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens []scimToken
+	pos    int
+}
+
+func (p *scimFilterParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *scimFilterParser) next() (scimToken, error) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, fmt.Errorf("unexpected end of filter")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *scimFilterParser) parseOr() (scimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = scimLogical{Op: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseAnd() (scimFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = scimLogical{Op: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseUnary() (scimFilter, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		if !p.peekKeyword("(") {
+			return nil, fmt.Errorf("expected ( after not")
+		}
+		inner, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return scimNot{Inner: inner}, nil
+	}
+	if p.peekKeyword("(") {
+		return p.parseGroup()
+	}
+	return p.parseComparison()
+}
+
+func (p *scimFilterParser) parseGroup() (scimFilter, error) {
+	p.pos++ // (
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.peekKeyword(")") {
+		return nil, fmt.Errorf("expected )")
+	}
+	p.pos++
+	return inner, nil
+}
+
+func (p *scimFilterParser) parseComparison() (scimFilter, error) {
+	attr, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if attr.quoted || attr.text == "(" || attr.text == ")" {
+		return nil, fmt.Errorf("expected attribute name, got %q", attr.text)
+	}
+	opToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(opToken.text)
+	if opToken.quoted {
+		return nil, fmt.Errorf("expected operator, got %q", opToken.text)
+	}
+	if op == "pr" {
+		return scimCompare{Attr: attr.text, Op: op}, nil
+	}
+	switch op {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q", opToken.text)
+	}
+
+	valueToken, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if valueToken.quoted {
+		value = valueToken.text
+	} else {
+		switch strings.ToLower(valueToken.text) {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			n, err := strconv.ParseFloat(valueToken.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", valueToken.text)
+			}
+			value = n
+		}
+	}
+	return scimCompare{Attr: attr.text, Op: op, Value: value}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test translating a simple equality filter
+func TestSCIMFilterEquality(t *testing.T) {
+	filter, err := parseSCIMFilter(`userName eq "BJensen"`)
+	assert.NoError(t, err)
+
+	clause, args, err := filter.toSQL(scimUserColumns)
+	assert.NoError(t, err)
+	assert.Equal(t, "LOWER(user_name) = ?", clause)
+	assert.Equal(t, []interface{}{"bjensen"}, args)
+}
+
+// Test precedence of and/or, grouping and presence
+func TestSCIMFilterLogical(t *testing.T) {
+	filter, err := parseSCIMFilter(`title pr and (active eq true or name.familyName sw "J")`)
+	assert.NoError(t, err)
+
+	clause, args, err := filter.toSQL(scimUserColumns)
+	assert.NoError(t, err)
+	assert.Equal(t, "((title IS NOT NULL AND title <> '') AND (active = ? OR LOWER(last_name) LIKE ?))", clause)
+	assert.Equal(t, []interface{}{true, "j%"}, args)
+}
+
+// Test that unknown attributes and malformed filters are rejected
+func TestSCIMFilterInvalid(t *testing.T) {
+	filter, err := parseSCIMFilter(`password eq "x"`)
+	assert.NoError(t, err)
+	_, _, err = filter.toSQL(scimUserColumns)
+	assert.Error(t, err)
+
+	for _, input := range []string{`userName eq`, `userName xx "a"`, `(userName eq "a"`, `emails[type eq "work"] pr`} {
+		_, err := parseSCIMFilter(input)
+		assert.Error(t, err, input)
+	}
+}
+
+// Test PATCH operations with and without a path
+func TestApplyUserPatch(t *testing.T) {
+	active := true
+	user := scimUser{UserName: "bjensen", Active: &active}
+
+	ops := []scimPatchOperation{
+		{Op: "replace", Path: "active", Value: []byte(`"False"`)},
+		{Op: "add", Value: []byte(`{"name.givenName":"Barbara","title":"Engineer"}`)},
+		{Op: "replace", Path: `emails[type eq "work"].value`, Value: []byte(`"bj@example.com"`)},
+		{Op: "replace", Path: scimEnterpriseSchema + ":department", Value: []byte(`"IT"`)},
+	}
+	for _, op := range ops {
+		assert.NoError(t, applyUserPatch(&user, op))
+	}
+
+	assert.False(t, *user.Active)
+	assert.Equal(t, "Barbara", user.Name.GivenName)
+	assert.Equal(t, "Engineer", user.Title)
+	assert.Equal(t, "bj@example.com", primaryEmail(user.Emails))
+	assert.Equal(t, "IT", user.Enterprise.Department)
+}
+
+// Test adding and removing group members
+func TestApplyGroupPatch(t *testing.T) {
+	group := Group{DisplayName: "Staff"}
+	members := map[string]scimMultiValue{"1": {Value: "1"}, "2": {Value: "2"}}
+
+	assert.NoError(t, applyGroupPatch(&group, members, scimPatchOperation{Op: "add", Path: "members", Value: []byte(`[{"value":"3"}]`)}))
+	assert.NoError(t, applyGroupPatch(&group, members, scimPatchOperation{Op: "remove", Path: `members[value eq "1"]`}))
+	assert.NoError(t, applyGroupPatch(&group, members, scimPatchOperation{Op: "replace", Value: []byte(`{"displayName":"Engineers"}`)}))
+
+	assert.Len(t, members, 2)
+	assert.Contains(t, members, "2")
+	assert.Contains(t, members, "3")
+	assert.Equal(t, "Engineers", group.DisplayName)
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SavedSearch is a named listDevices filter, sort and column set. It is
+// private to its owner unless shared with a group.
+type SavedSearch struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	Name      string     `gorm:"column:name" json:"name"`
+	OwnerID   uint       `gorm:"column:owner_id;index" json:"owner_id"`
+	GroupID   *uint      `gorm:"column:group_id;index" json:"group_id"` // shared with this group's members
+	Query     string     `gorm:"column:query" json:"query"`             // listDevices query string, e.g. status=In+Stock&sort=brand
+	Columns   string     `gorm:"column:columns" json:"columns"`         // comma-separated device fields; all when empty
+	CheckedAt *time.Time `gorm:"column:checked_at" json:"checked_at"`   // last change check
+	CreatedAt time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// SavedSearchMatch is a device that matched a search at its last check.
+type SavedSearchMatch struct {
+	SearchID uint `gorm:"column:search_id;primaryKey"`
+	DeviceID uint `gorm:"column:device_id;primaryKey"`
+}
+
+// SavedSearchChange records devices entering and leaving a search's results.
+type SavedSearchChange struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	SearchID   uint      `gorm:"column:search_id;index" json:"search_id"`
+	Added      string    `gorm:"column:added" json:"added"`     // comma-separated device IDs
+	Removed    string    `gorm:"column:removed" json:"removed"` // comma-separated device IDs
+	DetectedAt time.Time `gorm:"column:detected_at" json:"detected_at"`
+}
+
+// filter parses the stored query, using the search's columns as the fields.
+func (s SavedSearch) filter() (deviceFilter, error) {
+	values, err := url.ParseQuery(s.Query)
+	if err != nil {
+		return deviceFilter{}, err
+	}
+	values.Set("fields", s.Columns)
+	return parseDeviceFilter(values)
+}
+
+// diffIDs compares two sets of device IDs, returning the added and removed
+// IDs in ascending order.
+func diffIDs(previous, current []uint) (added, removed []uint) {
+	before := make(map[uint]bool, len(previous))
+	for _, id := range previous {
+		before[id] = true
+	}
+	now := make(map[uint]bool, len(current))
+	for _, id := range current {
+		now[id] = true
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range previous {
+		if !now[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed
+}
+
+func joinIDs(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// checkSavedSearch runs a search, stores its matches and returns the change
+// since the last check. The first check only records a baseline.
+func checkSavedSearch(tx *gorm.DB, search SavedSearch, now time.Time) (*SavedSearchChange, error) {
+	filter, err := search.filter()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := findDevicesWithGeo(filter.apply(tx.Model(&Device{})), filter.Geo, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+	current := make([]uint, len(devices))
+	for i, d := range devices {
+		current[i] = d.ID
+	}
+
+	var previous []uint
+	if err := tx.Model(&SavedSearchMatch{}).Where("search_id = ?", search.ID).Pluck("device_id", &previous).Error; err != nil {
+		return nil, err
+	}
+	added, removed := diffIDs(previous, current)
+
+	var change *SavedSearchChange
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		if search.CheckedAt != nil && (len(added) > 0 || len(removed) > 0) {
+			change = &SavedSearchChange{SearchID: search.ID, Added: joinIDs(added), Removed: joinIDs(removed), DetectedAt: now}
+			if err := tx.Create(change).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("search_id = ?", search.ID).Delete(&SavedSearchMatch{}).Error; err != nil {
+			return err
+		}
+		if len(current) > 0 {
+			matches := make([]SavedSearchMatch, len(current))
+			for i, id := range current {
+				matches[i] = SavedSearchMatch{SearchID: search.ID, DeviceID: id}
+			}
+			if err := tx.CreateInBatches(matches, chunkSize).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&SavedSearch{}).Where("id = ?", search.ID).Update("checked_at", now).Error
+	})
+	return change, err
+}
+
+// checkSavedSearches checks every search somebody is watching and notifies
+// the watchers of changes.
+func checkSavedSearches(ctx context.Context) error {
+	var searches []SavedSearch
+	err := db.WithContext(ctx).
+		Where("id IN (?)", db.Model(&Watch{}).Where("kind = ?", watchSearch).Select("target_id")).
+		Find(&searches).Error
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, search := range searches {
+		change, err := checkSavedSearch(db.WithContext(ctx), search, now)
+		if err != nil {
+			logger.Errorf("Failed to check saved search %d: %v", search.ID, err)
+			continue
+		}
+		if change != nil {
+			notifyLogged(db.WithContext(ctx), savedSearchChangeEvent(search, *change))
+		}
+	}
+	return nil
+}
+
+func savedSearchChangeEvent(search SavedSearch, change SavedSearchChange) notificationEvent {
+	var body []string
+	if change.Added != "" {
+		body = append(body, "New matches: devices "+change.Added)
+	}
+	if change.Removed != "" {
+		body = append(body, "No longer matching: devices "+change.Removed)
+	}
+	searchID := search.ID
+	return notificationEvent{
+		Type:     eventSearchChanged,
+		Subject:  fmt.Sprintf("Results of %q changed", search.Name),
+		Body:     strings.Join(body, "\n"),
+		SearchID: &searchID,
+		Key:      "search-change-" + strconv.Itoa(int(change.ID)),
+	}
+}
+
+func startSavedSearchJob() {
+	startJob("saved-searches", config().Searches.CheckInterval.Duration, checkSavedSearches)
+}
+
+// visibleSearches limits a query to the searches an employee owns or that
+// are shared with one of their groups.
+func visibleSearches(tx *gorm.DB, employeeID uint) (*gorm.DB, error) {
+	groups, err := groupIDsOf(tx, employeeID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return tx.Where("owner_id = ?", employeeID), nil
+	}
+	return tx.Where("owner_id = ? OR group_id IN ?", employeeID, groups), nil
+}
+
+// loadSavedSearch returns the search in the id parameter if the caller can
+// see it, responding with an error otherwise.
+func loadSavedSearch(c *gin.Context, caller Employee) (SavedSearch, bool) {
+	var search SavedSearch
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return search, false
+	}
+
+	query, err := visibleSearches(db, caller.ID)
+	if err == nil {
+		err = query.First(&search, idInt).Error
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Saved search not found")
+		} else {
+			logger.Errorf("Failed to retrieve saved search: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve saved search")
+		}
+		return search, false
+	}
+	return search, true
+}
+
+type savedSearchInput struct {
+	Name    string   `json:"name" binding:"required"`
+	Query   string   `json:"query"`
+	Columns []string `json:"columns"`
+	GroupID *uint    `json:"group_id"`
+}
+
+// toSearch validates the input and copies it onto search. Paging parameters
+// are dropped from the query since a search is run page by page.
+func (in savedSearchInput) toSearch(caller Employee, search *SavedSearch) (int, error) {
+	values, err := url.ParseQuery(strings.TrimPrefix(in.Query, "?"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("query must be a URL query string")
+	}
+	values.Del("page")
+	values.Del("limit")
+	values.Del("fields")
+	if _, err := parseDeviceFilter(values); err != nil {
+		return http.StatusBadRequest, err
+	}
+	columns := strings.Join(in.Columns, ",")
+	if _, err := parseDeviceFields(columns); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if in.GroupID != nil {
+		groups, err := groupIDsOf(db, caller.ID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		found := false
+		for _, id := range groups {
+			found = found || id == *in.GroupID
+		}
+		if !found {
+			return http.StatusForbidden, errors.New("you can only share with groups you belong to")
+		}
+	}
+
+	search.Name = in.Name
+	search.Query = values.Encode()
+	search.Columns = columns
+	search.GroupID = in.GroupID
+	return 0, nil
+}
+
+func createSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	var input savedSearchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	search := SavedSearch{OwnerID: caller.ID}
+	if code, err := input.toSearch(caller, &search); err != nil {
+		if code == http.StatusInternalServerError {
+			logger.Errorf("Failed to create saved search: %v", err)
+			respondWithError(c, code, "Failed to create saved search")
+		} else {
+			respondWithError(c, code, err.Error())
+		}
+		return
+	}
+
+	if err := db.Create(&search).Error; err != nil {
+		logger.Errorf("Failed to create saved search: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create saved search")
+		return
+	}
+
+	logger.Infof("Saved search created: %s", search.Name)
+	c.JSON(http.StatusCreated, search)
+}
+
+func listSavedSearches(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+
+	var searches []SavedSearch
+	query, err := visibleSearches(db, caller.ID)
+	if err == nil {
+		err = query.Order("name").Find(&searches).Error
+	}
+	if err != nil {
+		logger.Errorf("Failed to retrieve saved searches: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve saved searches")
+		return
+	}
+	c.JSON(http.StatusOK, searches)
+}
+
+func getSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	if search, ok := loadSavedSearch(c, caller); ok {
+		c.JSON(http.StatusOK, search)
+	}
+}
+
+func updateSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+	if search.OwnerID != caller.ID {
+		respondWithError(c, http.StatusForbidden, "Only the owner can change a saved search")
+		return
+	}
+
+	var input savedSearchInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if code, err := input.toSearch(caller, &search); err != nil {
+		if code == http.StatusInternalServerError {
+			logger.Errorf("Failed to update saved search: %v", err)
+			respondWithError(c, code, "Failed to update saved search")
+		} else {
+			respondWithError(c, code, err.Error())
+		}
+		return
+	}
+
+	err := db.Model(&SavedSearch{}).Where("id = ?", search.ID).Updates(map[string]interface{}{
+		"name":     search.Name,
+		"query":    search.Query,
+		"columns":  search.Columns,
+		"group_id": search.GroupID,
+	}).Error
+	if err != nil {
+		logger.Errorf("Failed to update saved search: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search updated successfully"})
+}
+
+func deleteSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+	if search.OwnerID != caller.ID {
+		respondWithError(c, http.StatusForbidden, "Only the owner can delete a saved search")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&SavedSearchMatch{}, &SavedSearchChange{}} {
+			if err := tx.Where("search_id = ?", search.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("kind = ? AND target_id = ?", watchSearch, search.ID).Delete(&Watch{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&SavedSearch{}, search.ID).Error
+	})
+	if err != nil {
+		logger.Errorf("Failed to delete saved search: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete saved search")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted successfully"})
+}
+
+// runSavedSearch returns a page of the search's results, like listDevices.
+func runSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+
+	limit, offset := pageParams(c, 10)
+
+	filter, err := search.filter()
+	if err != nil {
+		logger.Errorf("Saved search %d has an invalid query: %v", search.ID, err)
+		respondWithError(c, http.StatusInternalServerError, "Saved search has an invalid query")
+		return
+	}
+	devices, err := findDevicesWithGeo(filter.apply(db), filter.Geo, limit, offset)
+	if err != nil {
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+	result, err := selectDeviceFields(devices, filter.Fields)
+	if err != nil {
+		logger.Errorf("Failed to select device fields: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// exportSavedSearch writes all of a search's results as CSV in its columns.
+func exportSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+
+	filter, err := search.filter()
+	if err != nil {
+		logger.Errorf("Saved search %d has an invalid query: %v", search.ID, err)
+		respondWithError(c, http.StatusInternalServerError, "Saved search has an invalid query")
+		return
+	}
+	devices, err := findDevicesWithGeo(filter.apply(db), filter.Geo, -1, 0)
+	if err != nil {
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+
+	columns := filter.Fields
+	if len(columns) == 0 {
+		columns = deviceFields
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "search-"+strconv.Itoa(int(search.ID))+".csv"))
+	w := csv.NewWriter(c.Writer)
+	w.Write(columns)
+	for _, d := range devices {
+		row, err := deviceFieldValues(d)
+		if err != nil {
+			logger.Errorf("Failed to export device %d: %v", d.ID, err)
+			return
+		}
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if v := row[column]; v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logger.Errorf("Failed to write saved search export: %v", err)
+	}
+}
+
+func subscribeSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+
+	subscription := Watch{EmployeeID: caller.ID, Kind: watchSearch, TargetID: search.ID}
+	if err := db.Create(&subscription).Error; err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "Already subscribed")
+			return
+		}
+		logger.Errorf("Failed to subscribe to saved search: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to subscribe")
+		return
+	}
+	c.JSON(http.StatusCreated, subscription)
+}
+
+func unsubscribeSavedSearch(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("kind = ? AND target_id = ? AND employee_id = ?", watchSearch, search.ID, caller.ID).Delete(&Watch{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		var remaining int64
+		if err := tx.Model(&Watch{}).Where("kind = ? AND target_id = ?", watchSearch, search.ID).Count(&remaining).Error; err != nil {
+			return err
+		}
+		if remaining > 0 {
+			return nil
+		}
+		// Nobody is watching, so drop the baseline rather than let it go stale.
+		if err := tx.Where("search_id = ?", search.ID).Delete(&SavedSearchMatch{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&SavedSearch{}).Where("id = ?", search.ID).Update("checked_at", nil).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(c, http.StatusNotFound, "Not subscribed")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to unsubscribe from saved search: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed successfully"})
+}
+
+func listSavedSearchChanges(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	search, ok := loadSavedSearch(c, caller)
+	if !ok {
+		return
+	}
+
+	var changes []SavedSearchChange
+	if err := db.Where("search_id = ?", search.ID).Order("detected_at DESC").Find(&changes).Error; err != nil {
+		logger.Errorf("Failed to retrieve saved search changes: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve changes")
+		return
+	}
+	c.JSON(http.StatusOK, changes)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test diffing the device IDs of two search runs
+func TestDiffIDs(t *testing.T) {
+	added, removed := diffIDs([]uint{1, 2, 3}, []uint{5, 3, 4, 1})
+	assert.Equal(t, []uint{4, 5}, added)
+	assert.Equal(t, []uint{2}, removed)
+
+	added, removed = diffIDs(nil, nil)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+// Test that a saved search uses its columns as the selected fields
+func TestSavedSearchFilter(t *testing.T) {
+	search := SavedSearch{Query: "status=In+Stock&warranty_within_days=30&sort=brand", Columns: "id,device_name"}
+	filter, err := search.filter()
+	assert.NoError(t, err)
+	assert.Equal(t, "In Stock", filter.Status)
+	assert.Equal(t, 30, *filter.WarrantyWithin)
+	assert.Equal(t, "brand", filter.Sort)
+	assert.Equal(t, []string{"id", "device_name"}, filter.Fields)
+
+	_, err = parseDeviceFilter(url.Values{"fields": {"id,secret"}})
+	assert.Error(t, err)
+}
+
+// Test reducing devices to selected fields
+func TestSelectDeviceFields(t *testing.T) {
+	rows, err := selectDeviceFields([]Device{{ID: 7, DeviceName: "Laptop", Price: 1500000}}, []string{"id", "price"})
+	assert.NoError(t, err)
+
+	raw, _ := json.Marshal(rows)
+	assert.JSONEq(t, `[{"id":7,"price":1500000}]`, string(raw))
+}
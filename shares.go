@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DeviceShare grants read-only access to one device, without an account, to
+// whoever holds its link. Like calendar feeds, the link carries a random
+// token and only its SHA-256 hash is stored, so a link cannot be forged or
+// recovered from the database; expiry, single use and revocation are
+// enforced when it is opened.
+type DeviceShare struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	DeviceID  uint       `gorm:"column:device_id;index" json:"device_id"`
+	TokenHash string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+	Fields    string     `gorm:"column:fields" json:"fields"` // comma-separated; every field when empty
+	Note      string     `gorm:"column:note" json:"note"`     // who the link is for
+	SingleUse bool       `gorm:"column:single_use" json:"single_use"`
+	ExpiresAt time.Time  `gorm:"column:expires_at" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at"`
+	RevokedAt *time.Time `gorm:"column:revoked_at" json:"revoked_at"`
+	CreatedBy uint       `gorm:"column:created_by" json:"created_by"`
+	CreatedAt time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+// DeviceShareAccess is one attempt to open a share link.
+type DeviceShareAccess struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	ShareID    uint      `gorm:"column:share_id;index" json:"share_id"`
+	AccessedAt time.Time `gorm:"column:accessed_at" json:"accessed_at"`
+	IP         string    `gorm:"column:ip" json:"ip"`
+	UserAgent  string    `gorm:"column:user_agent" json:"user_agent"`
+	Outcome    string    `gorm:"column:outcome" json:"outcome"`
+}
+
+// Share access outcomes.
+const (
+	shareGranted = "granted"
+	shareExpired = "expired"
+	shareRevoked = "revoked"
+	shareUsed    = "used"
+)
+
+var shareRefusals = map[string]string{
+	shareExpired: "Share link has expired",
+	shareRevoked: "Share link has been revoked",
+	shareUsed:    "Share link has already been used",
+}
+
+// shareOutcome reports whether a share may be opened at now.
+func shareOutcome(s DeviceShare, now time.Time) string {
+	switch {
+	case s.RevokedAt != nil:
+		return shareRevoked
+	case !now.Before(s.ExpiresAt):
+		return shareExpired
+	case s.SingleUse && s.UsedAt != nil:
+		return shareUsed
+	}
+	return shareGranted
+}
+
+func (s DeviceShare) fieldList() []string {
+	if s.Fields == "" {
+		return nil
+	}
+	return strings.Split(s.Fields, ",")
+}
+
+func createDeviceShare(c *gin.Context) {
+	creator, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var input struct {
+		Fields    []string  `json:"fields"`
+		ExpiresIn *Duration `json:"expires_in"` // like "72h"
+		SingleUse bool      `json:"single_use"`
+		Note      string    `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, field := range input.Fields {
+		if !containsString(deviceFields, field) {
+			respondWithError(c, http.StatusBadRequest, "unknown field "+field)
+			return
+		}
+	}
+	ttl := config().Shares.DefaultTTL.Duration
+	if input.ExpiresIn != nil {
+		ttl = input.ExpiresIn.Duration
+	}
+	if max := config().Shares.MaxTTL.Duration; ttl <= 0 || ttl > max {
+		respondWithError(c, http.StatusBadRequest, "expires_in must be positive and at most "+max.String())
+		return
+	}
+
+	var device Device
+	if err := db.Select("id").First(&device, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Device not found")
+		} else {
+			logger.Errorf("Failed to retrieve device: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to create share link")
+		}
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logger.Errorf("Failed to generate share token: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	share := DeviceShare{
+		DeviceID:  device.ID,
+		TokenHash: hashFeedToken(token),
+		Fields:    strings.Join(input.Fields, ","),
+		Note:      input.Note,
+		SingleUse: input.SingleUse,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: creator.ID,
+	}
+	if err := db.Create(&share).Error; err != nil {
+		logger.Errorf("Failed to create share link: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create share link")
+		return
+	}
+
+	logger.Infof("Share link %d created for device %d by employee %d", share.ID, share.DeviceID, creator.ID)
+	// The token is only shown once.
+	c.JSON(http.StatusCreated, gin.H{"share": share, "url": "/shared/" + token})
+}
+
+func listDeviceShares(c *gin.Context) {
+	if _, ok := currentEmployee(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var shares []DeviceShare
+	if err := db.Where("device_id = ?", idInt).Order("id").Find(&shares).Error; err != nil {
+		logger.Errorf("Failed to retrieve share links: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve share links")
+		return
+	}
+	c.JSON(http.StatusOK, shares)
+}
+
+// findShareForManagement loads a share the caller created, or any share for
+// an admin.
+func findShareForManagement(c *gin.Context) (DeviceShare, bool) {
+	var share DeviceShare
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return share, false
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return share, false
+	}
+	if err := db.First(&share, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Share link not found")
+		} else {
+			logger.Errorf("Failed to retrieve share link: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve share link")
+		}
+		return share, false
+	}
+	if share.CreatedBy != caller.ID && caller.Role != roleAdmin {
+		respondWithError(c, http.StatusForbidden, "Only the creator can manage a share link")
+		return share, false
+	}
+	return share, true
+}
+
+func revokeDeviceShare(c *gin.Context) {
+	share, ok := findShareForManagement(c)
+	if !ok {
+		return
+	}
+	if share.RevokedAt == nil {
+		now := time.Now()
+		if err := db.Model(&share).Update("revoked_at", now).Error; err != nil {
+			logger.Errorf("Failed to revoke share link: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to revoke share link")
+			return
+		}
+		share.RevokedAt = &now
+	}
+
+	logger.Infof("Share link revoked: %d", share.ID)
+	c.JSON(http.StatusOK, share)
+}
+
+func listDeviceShareAccesses(c *gin.Context) {
+	share, ok := findShareForManagement(c)
+	if !ok {
+		return
+	}
+	var accesses []DeviceShareAccess
+	if err := db.Where("share_id = ?", share.ID).Order("accessed_at DESC").Find(&accesses).Error; err != nil {
+		logger.Errorf("Failed to retrieve share accesses: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve share accesses")
+		return
+	}
+	c.JSON(http.StatusOK, accesses)
+}
+
+// openDeviceShare serves /shared/<token> to anyone holding the link. Every
+// attempt on a known link is logged, whether or not it is let through.
+func openDeviceShare(c *gin.Context) {
+	var share DeviceShare
+	if err := db.Where("token_hash = ?", hashFeedToken(c.Param("token"))).First(&share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Share link not found")
+		} else {
+			logger.Errorf("Failed to retrieve share link: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to open share link")
+		}
+		return
+	}
+
+	now := time.Now()
+	outcome := shareOutcome(share, now)
+	var device Device
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if outcome == shareGranted && share.SingleUse {
+			// Claim the link atomically so two concurrent opens cannot both succeed.
+			result := tx.Model(&DeviceShare{}).Where("id = ? AND used_at IS NULL", share.ID).Update("used_at", now)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				outcome = shareUsed
+			}
+		}
+		if outcome == shareGranted {
+			if err := tx.First(&device, share.DeviceID).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&DeviceShareAccess{
+			ShareID:    share.ID,
+			AccessedAt: now,
+			IP:         c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			Outcome:    outcome,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(c, http.StatusNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to open share link: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to open share link")
+		return
+	}
+	if outcome != shareGranted {
+		logger.Warnf("Share link %d refused: %s", share.ID, outcome)
+		respondWithError(c, http.StatusGone, shareRefusals[outcome])
+		return
+	}
+
+	var record interface{} = device
+	if fields := share.fieldList(); len(fields) > 0 {
+		if record, err = selectedFieldValues(device, fields); err != nil {
+			logger.Errorf("Failed to select device fields: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to open share link")
+			return
+		}
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test when a share link may be opened
+func TestShareOutcome(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Hour)
+	share := DeviceShare{ExpiresAt: now.Add(time.Hour)}
+
+	assert.Equal(t, shareGranted, shareOutcome(share, now))
+	assert.Equal(t, shareExpired, shareOutcome(share, now.Add(time.Hour)))
+
+	share.UsedAt = &earlier
+	assert.Equal(t, shareGranted, shareOutcome(share, now), "reusable links stay open after use")
+	share.SingleUse = true
+	assert.Equal(t, shareUsed, shareOutcome(share, now))
+
+	share.RevokedAt = &earlier
+	assert.Equal(t, shareRevoked, shareOutcome(share, now))
+}
+
+// Test the fields a share link exposes
+func TestShareFieldList(t *testing.T) {
+	assert.Nil(t, DeviceShare{}.fieldList())
+	assert.Equal(t, []string{"device_name", "status"}, DeviceShare{Fields: "device_name,status"}.fieldList())
+}
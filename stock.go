@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// StockThreshold is the minimum number of spare devices of a type (and
+// optionally model) to keep at a location, or across all locations when
+// LocationID is nil.
+type StockThreshold struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	DeviceType   string    `gorm:"column:device_type" json:"device_type" binding:"required"`
+	Model        string    `gorm:"column:model" json:"model"`
+	LocationID   *uint     `gorm:"column:location_id" json:"location_id"`
+	Minimum      int       `gorm:"column:minimum" json:"minimum"`
+	LeadTimeDays int       `gorm:"column:lead_time_days" json:"lead_time_days"` // supplier delivery time
+	CreatedAt    time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// StockAlert is raised when stock drops below a threshold and resolved when
+// it recovers.
+type StockAlert struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	ThresholdID uint       `gorm:"column:threshold_id;index" json:"threshold_id"`
+	InStock     int        `gorm:"column:in_stock" json:"in_stock"`
+	Minimum     int        `gorm:"column:minimum" json:"minimum"`
+	RaisedAt    time.Time  `gorm:"column:raised_at" json:"raised_at"`
+	ResolvedAt  *time.Time `gorm:"column:resolved_at" json:"resolved_at"`
+}
+
+// StockLevel is the current state of one threshold.
+type StockLevel struct {
+	Threshold         StockThreshold `json:"threshold"`
+	InStock           int            `json:"in_stock"`
+	BelowThreshold    bool           `json:"below_threshold"`
+	Consumed          int            `json:"consumed"` // devices handed out or retired during the window
+	DailyConsumption  float64        `json:"daily_consumption"`
+	ProjectedStockOut *time.Time     `json:"projected_stock_out"`
+	SuggestedOrder    int            `json:"suggested_order"`
+}
+
+// projectStock estimates the daily consumption rate, the date stock runs out
+// at that rate, and how many devices to buy to stay above minimum through
+// the supplier lead time plus coverDays.
+func projectStock(inStock, consumed int, window time.Duration, minimum, leadTimeDays, coverDays int, now time.Time) (rate float64, stockOut *time.Time, suggested int) {
+	windowDays := window.Hours() / 24
+	if windowDays > 0 {
+		rate = float64(consumed) / windowDays
+	}
+
+	needed := minimum
+	if rate > 0 {
+		out := now.Add(time.Duration(float64(inStock) / rate * 24 * float64(time.Hour)))
+		stockOut = &out
+		needed += int(math.Ceil(rate * float64(leadTimeDays+coverDays)))
+	}
+	if needed > inStock {
+		suggested = needed - inStock
+	}
+	return rate, stockOut, suggested
+}
+
+// scopeToThreshold limits a device query to the threshold's type, model and location.
+func scopeToThreshold(query *gorm.DB, t StockThreshold, table string) *gorm.DB {
+	query = query.Where("LOWER("+table+".device_type) = LOWER(?)", t.DeviceType)
+	if t.Model != "" {
+		query = query.Where("LOWER("+table+".model) = LOWER(?)", t.Model)
+	}
+	if t.LocationID != nil {
+		query = query.Where(table+".location_id = ?", *t.LocationID)
+	}
+	return query
+}
+
+// consumedStatuses are the statuses of devices that are used up: assigned
+// or retired.
+func consumedStatuses() []string {
+	return append([]string{statusAssigned}, config().Retention.TerminalStatuses...)
+}
+
+func computeStockLevel(tx *gorm.DB, t StockThreshold, now time.Time) (StockLevel, error) {
+	statuses := config().Stock.InStockStatuses
+	window := config().Stock.ConsumptionWindow.Duration
+
+	var inStock int64
+	if err := scopeToThreshold(tx.Model(&Device{}), t, "devices").
+		Where("devices.status IN ?", statuses).Count(&inStock).Error; err != nil {
+		return StockLevel{}, err
+	}
+
+	// Reservations and transfers take devices out of stock only for a while;
+	// a device is consumed when it is handed out or retired. Reserved devices
+	// count when they are handed out, not when they are reserved.
+	used := consumedStatuses()
+	var consumed int64
+	err := scopeToThreshold(tx.Model(&DeviceStatusChange{}), t, "devices").
+		Joins("JOIN devices ON devices.id = device_status_changes.device_id").
+		Where("device_status_changes.to_status IN ? AND device_status_changes.from_status NOT IN ?", used, used).
+		Where("device_status_changes.changed_at >= ?", now.Add(-window)).
+		Count(&consumed).Error
+	if err != nil {
+		return StockLevel{}, err
+	}
+
+	rate, stockOut, suggested := projectStock(int(inStock), int(consumed), window, t.Minimum, t.LeadTimeDays, config().Stock.CoverDays, now)
+	return StockLevel{
+		Threshold:         t,
+		InStock:           int(inStock),
+		BelowThreshold:    int(inStock) < t.Minimum,
+		Consumed:          int(consumed),
+		DailyConsumption:  rate,
+		ProjectedStockOut: stockOut,
+		SuggestedOrder:    suggested,
+	}, nil
+}
+
+// checkStockLevels raises an alert for each threshold that stock has fallen
+// below and resolves alerts whose stock has recovered.
+func checkStockLevels(ctx context.Context) error {
+	var thresholds []StockThreshold
+	if err := db.WithContext(ctx).Find(&thresholds).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, t := range thresholds {
+		level, err := computeStockLevel(db.WithContext(ctx), t, now)
+		if err != nil {
+			return err
+		}
+
+		var open StockAlert
+		err = db.WithContext(ctx).Where("threshold_id = ? AND resolved_at IS NULL", t.ID).First(&open).Error
+		hasOpen := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		switch {
+		case level.BelowThreshold && !hasOpen:
+			alert := StockAlert{ThresholdID: t.ID, InStock: level.InStock, Minimum: t.Minimum, RaisedAt: now}
+			if err := db.WithContext(ctx).Create(&alert).Error; err != nil {
+				return err
+			}
+			logger.Warnf("Stock of %s %s below threshold %d: %d in stock, suggest ordering %d",
+				t.DeviceType, t.Model, t.Minimum, level.InStock, level.SuggestedOrder)
+			notifyLogged(db.WithContext(ctx), notificationEvent{
+				Type:    eventStockLow,
+				Subject: fmt.Sprintf("Low stock: %s %s", t.DeviceType, t.Model),
+				Body:    fmt.Sprintf("%d in stock, minimum %d. Suggested order: %d.", level.InStock, t.Minimum, level.SuggestedOrder),
+				Key:     "stock-alert-" + strconv.Itoa(int(alert.ID)),
+			})
+		case !level.BelowThreshold && hasOpen:
+			if err := db.WithContext(ctx).Model(&open).Update("resolved_at", now).Error; err != nil {
+				return err
+			}
+			logger.Infof("Stock of %s %s back above threshold %d", t.DeviceType, t.Model, t.Minimum)
+		}
+	}
+	return nil
+}
+
+func startStockJob() {
+	startJob("stock-levels", config().Stock.CheckInterval.Duration, checkStockLevels)
+}
+
+func createStockThreshold(c *gin.Context) {
+	var threshold StockThreshold
+	if err := c.ShouldBindJSON(&threshold); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if threshold.Minimum < 0 || threshold.LeadTimeDays < 0 {
+		respondWithError(c, http.StatusBadRequest, "minimum and lead_time_days must not be negative")
+		return
+	}
+
+	if err := db.Create(&threshold).Error; err != nil {
+		logger.Errorf("Failed to create stock threshold: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create stock threshold")
+		return
+	}
+
+	logger.Infof("Stock threshold created: %v", threshold)
+	c.JSON(http.StatusCreated, threshold)
+}
+
+func listStockThresholds(c *gin.Context) {
+	var thresholds []StockThreshold
+	if err := db.Order("id").Find(&thresholds).Error; err != nil {
+		logger.Errorf("Failed to retrieve stock thresholds: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve stock thresholds")
+		return
+	}
+	c.JSON(http.StatusOK, thresholds)
+}
+
+func updateStockThreshold(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var threshold StockThreshold
+	if err := c.ShouldBindJSON(&threshold); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if threshold.Minimum < 0 || threshold.LeadTimeDays < 0 {
+		respondWithError(c, http.StatusBadRequest, "minimum and lead_time_days must not be negative")
+		return
+	}
+
+	result := db.Model(&StockThreshold{}).Where("id = ?", idInt).Updates(map[string]interface{}{
+		"device_type":    threshold.DeviceType,
+		"model":          threshold.Model,
+		"location_id":    threshold.LocationID,
+		"minimum":        threshold.Minimum,
+		"lead_time_days": threshold.LeadTimeDays,
+	})
+	if result.Error != nil {
+		logger.Errorf("Failed to update stock threshold: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update stock threshold")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Stock threshold not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock threshold updated successfully"})
+}
+
+func deleteStockThreshold(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	result := db.Delete(&StockThreshold{}, idInt)
+	if result.Error != nil {
+		logger.Errorf("Failed to delete stock threshold: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete stock threshold")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Stock threshold not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stock threshold deleted successfully"})
+}
+
+// getStockLevels reports every threshold; ?below=true keeps only those under minimum.
+func getStockLevels(c *gin.Context) {
+	var thresholds []StockThreshold
+	if err := db.Order("id").Find(&thresholds).Error; err != nil {
+		logger.Errorf("Failed to retrieve stock thresholds: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve stock levels")
+		return
+	}
+
+	now := time.Now()
+	levels := []StockLevel{}
+	for _, t := range thresholds {
+		level, err := computeStockLevel(db, t, now)
+		if err != nil {
+			logger.Errorf("Failed to compute stock level: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to retrieve stock levels")
+			return
+		}
+		if c.Query("below") == "true" && !level.BelowThreshold {
+			continue
+		}
+		levels = append(levels, level)
+	}
+	c.JSON(http.StatusOK, levels)
+}
+
+func listStockAlerts(c *gin.Context) {
+	query := db.Order("raised_at DESC")
+	if c.Query("open") == "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+
+	var alerts []StockAlert
+	if err := query.Find(&alerts).Error; err != nil {
+		logger.Errorf("Failed to retrieve stock alerts: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve stock alerts")
+		return
+	}
+	c.JSON(http.StatusOK, alerts)
+}
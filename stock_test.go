@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test consumption rate, stock-out date and purchase suggestion
+func TestProjectStock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := 30 * 24 * time.Hour
+
+	rate, stockOut, suggested := projectStock(10, 15, window, 5, 14, 30, now)
+
+	assert.InDelta(t, 0.5, rate, 0.0001)
+	assert.Equal(t, now.AddDate(0, 0, 20), *stockOut)
+	// 5 minimum + 0.5/day over 44 days - 10 in stock
+	assert.Equal(t, 17, suggested)
+}
+
+// Test that without consumption only the minimum is topped up
+func TestProjectStockNoConsumption(t *testing.T) {
+	now := time.Now()
+
+	rate, stockOut, suggested := projectStock(2, 0, 30*24*time.Hour, 5, 14, 30, now)
+	assert.Zero(t, rate)
+	assert.Nil(t, stockOut)
+	assert.Equal(t, 3, suggested)
+
+	_, _, suggested = projectStock(8, 0, 30*24*time.Hour, 5, 14, 30, now)
+	assert.Zero(t, suggested)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushEvery is how many records are written between flushes.
+const ndjsonFlushEvery = 100
+
+// wantsNDJSON reports whether the caller asked for a newline-delimited JSON
+// stream, by Accept header or ?stream=true.
+func wantsNDJSON(c *gin.Context) bool {
+	return c.Query("stream") == "true" || strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// streamDevices writes the devices matching filter one JSON object per line,
+// reading them from the database a row at a time. A negative limit streams
+// every match. It stops early if the client goes away.
+func streamDevices(c *gin.Context, filter deviceFilter, limit, offset int) {
+	ctx := c.Request.Context()
+	query := filter.Geo.apply(filter.apply(db.WithContext(ctx).Model(&Device{})))
+	// Without PostGIS radius matches are only known in Go, so paging waits for them.
+	goFilter := filter.Geo.needsGoFilter()
+	if !goFilter {
+		query = query.Limit(limit).Offset(offset)
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve devices")
+		return
+	}
+	defer rows.Close()
+
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", ndjsonContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	encoder := json.NewEncoder(c.Writer)
+
+	written, skipped := 0, 0
+	for rows.Next() {
+		if limit >= 0 && written >= limit {
+			break
+		}
+		var device Device
+		if err := db.ScanRows(rows, &device); err != nil {
+			logger.Errorf("Failed to read device row: %v", err)
+			encoder.Encode(gin.H{"error": "Failed to retrieve devices"})
+			return
+		}
+		if goFilter {
+			if !filter.Geo.matches(device) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+		}
+
+		var record interface{} = device
+		if len(filter.Fields) > 0 {
+			if record, err = selectedFieldValues(device, filter.Fields); err != nil {
+				logger.Errorf("Failed to select device fields: %v", err)
+				encoder.Encode(gin.H{"error": "Failed to retrieve devices"})
+				return
+			}
+		}
+		if err := encoder.Encode(record); err != nil {
+			logger.Warnf("Device stream stopped after %d devices: %v", written, err)
+			return
+		}
+		written++
+		if written%ndjsonFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		if ctx.Err() != nil {
+			logger.Warnf("Device stream stopped after %d devices: client went away", written)
+			return
+		}
+		logger.Errorf("Failed to retrieve devices: %v", err)
+		encoder.Encode(gin.H{"error": "Failed to retrieve devices"})
+		return
+	}
+	c.Writer.Flush()
+	logger.Infof("Devices streamed: %d", written)
+}
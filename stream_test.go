@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test choosing the NDJSON stream by Accept header or query parameter
+func TestWantsNDJSON(t *testing.T) {
+	for _, tc := range []struct {
+		url, accept string
+		want        bool
+	}{
+		{"/device", "", false},
+		{"/device", "application/json", false},
+		{"/device", "application/x-ndjson", true},
+		{"/device", "application/x-ndjson, application/json;q=0.5", true},
+		{"/device?stream=true", "", true},
+		{"/device?stream=false", "", false},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", tc.url, nil)
+		if tc.accept != "" {
+			c.Request.Header.Set("Accept", tc.accept)
+		}
+		assert.Equal(t, tc.want, wantsNDJSON(c), tc.url+" "+tc.accept)
+	}
+}
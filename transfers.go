@@ -0,0 +1,729 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Transfer moves devices from one location to another. Shipping puts its
+// devices in transit; they reach the destination as they are received.
+type Transfer struct {
+	ID             uint                  `gorm:"primaryKey" json:"id"`
+	FromLocationID uint                  `gorm:"column:from_location_id;index" json:"from_location_id" binding:"required"`
+	ToLocationID   uint                  `gorm:"column:to_location_id;index" json:"to_location_id" binding:"required"`
+	Shipper        string                `gorm:"column:shipper" json:"shipper"`
+	TrackingNumber string                `gorm:"column:tracking_number;index" json:"tracking_number"`
+	Status         string                `gorm:"column:status;index" json:"status"`
+	Note           string                `gorm:"column:note" json:"note"`
+	CreatedBy      uint                  `gorm:"column:created_by" json:"created_by"`
+	ShippedAt      *time.Time            `gorm:"column:shipped_at" json:"shipped_at"`
+	ClosedAt       *time.Time            `gorm:"column:closed_at" json:"closed_at"` // everything received, or the rest written off
+	Items          []TransferItem        `json:"items"`
+	Discrepancies  []TransferDiscrepancy `json:"discrepancies"`
+	CreatedAt      time.Time             `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time             `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TransferItem is one device on a transfer.
+type TransferItem struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	TransferID uint       `gorm:"column:transfer_id;index" json:"transfer_id"`
+	DeviceID   uint       `gorm:"column:device_id;index" json:"device_id"`
+	Status     string     `gorm:"column:status" json:"status"`
+	PrevStatus string     `gorm:"column:prev_status" json:"prev_status"` // device status before shipping, restored on receipt
+	ReceivedAt *time.Time `gorm:"column:received_at" json:"received_at"`
+	ReceivedBy *uint      `gorm:"column:received_by" json:"received_by"`
+}
+
+// TransferDiscrepancy is a difference between what was shipped and what
+// arrived.
+type TransferDiscrepancy struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	TransferID uint       `gorm:"column:transfer_id;index" json:"transfer_id"`
+	DeviceID   *uint      `gorm:"column:device_id" json:"device_id"`
+	Kind       string     `gorm:"column:kind" json:"kind"`
+	Note       string     `gorm:"column:note" json:"note"`
+	ReportedBy *uint      `gorm:"column:reported_by" json:"reported_by"` // nil when found by the system
+	ReportedAt time.Time  `gorm:"column:reported_at" json:"reported_at"`
+	ResolvedAt *time.Time `gorm:"column:resolved_at;index" json:"resolved_at"`
+	Resolution string     `gorm:"column:resolution" json:"resolution"`
+}
+
+const (
+	transferPending   = "pending"
+	transferInTransit = "in_transit"
+	transferPartial   = "partially_received"
+	transferReceived  = "received"
+	transferClosed    = "closed" // closed with devices missing
+	transferCancelled = "cancelled"
+
+	transferItemPending   = "pending"
+	transferItemInTransit = "in_transit"
+	transferItemReceived  = "received"
+	transferItemMissing   = "missing"
+
+	discrepancyMissing    = "missing"    // shipped but never received
+	discrepancyUnexpected = "unexpected" // received but not on the transfer
+	discrepancyDamaged    = "damaged"
+	discrepancyOther      = "other"
+)
+
+// receiptPlan sorts the devices scanned in at the destination into those
+// on the transfer and still in transit, those already received and those
+// that were never on it.
+func receiptPlan(items []TransferItem, deviceIDs []uint) (receive []TransferItem, already, unexpected []uint) {
+	byDevice := make(map[uint]TransferItem, len(items))
+	for _, item := range items {
+		byDevice[item.DeviceID] = item
+	}
+	for _, id := range uniqueUints(deviceIDs) {
+		item, ok := byDevice[id]
+		switch {
+		case !ok:
+			unexpected = append(unexpected, id)
+		case item.Status == transferItemInTransit:
+			receive = append(receive, item)
+		default:
+			already = append(already, id)
+		}
+	}
+	return receive, already, unexpected
+}
+
+// transferStatusAfterReceipt is the status of a shipped transfer given its
+// items: received once none are in transit, partially received once any is.
+func transferStatusAfterReceipt(items []TransferItem) string {
+	inTransit, received := 0, 0
+	for _, item := range items {
+		switch item.Status {
+		case transferItemInTransit:
+			inTransit++
+		case transferItemReceived:
+			received++
+		}
+	}
+	switch {
+	case inTransit == 0:
+		return transferReceived
+	case received > 0:
+		return transferPartial
+	default:
+		return transferInTransit
+	}
+}
+
+// markDeletedTransferItem records that the device of a transfer item has
+// been deleted, so the item is missing.
+func markDeletedTransferItem(tx *gorm.DB, transfer *Transfer, itemID uint, now time.Time) error {
+	for i := range transfer.Items {
+		item := &transfer.Items[i]
+		if item.ID != itemID {
+			continue
+		}
+		item.Status = transferItemMissing
+		if err := tx.Model(item).Update("status", item.Status).Error; err != nil {
+			return err
+		}
+		deviceID := item.DeviceID
+		return tx.Create(&TransferDiscrepancy{
+			TransferID: transfer.ID, DeviceID: &deviceID, Kind: discrepancyMissing,
+			Note: "device was deleted", ReportedAt: now,
+		}).Error
+	}
+	return nil
+}
+
+// onOpenTransfer reports whether a device is on a transfer that has not
+// arrived yet.
+func onOpenTransfer(tx *gorm.DB, deviceID uint) (bool, error) {
+	var count int64
+	err := tx.Model(&TransferItem{}).Where("device_id = ? AND status IN ?", deviceID,
+		[]string{transferItemPending, transferItemInTransit}).Count(&count).Error
+	return count > 0, err
+}
+
+// transferRequestError is a problem with the request found inside a
+// transfer transaction.
+type transferRequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *transferRequestError) Error() string {
+	return e.Message
+}
+
+// respondTransferError maps err to a response.
+func respondTransferError(c *gin.Context, err error, action string) {
+	var reqErr *transferRequestError
+	switch {
+	case errors.As(err, &reqErr):
+		respondWithError(c, reqErr.Status, reqErr.Message)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		respondWithError(c, http.StatusNotFound, "Transfer not found")
+	default:
+		logger.Errorf("Failed to %s: %v", action, err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to "+action)
+	}
+}
+
+func createTransfer(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Transfer
+		DeviceIDs []uint `json:"device_ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.FromLocationID == input.ToLocationID {
+		respondWithError(c, http.StatusBadRequest, "from_location_id and to_location_id must differ")
+		return
+	}
+
+	transfer := Transfer{
+		FromLocationID: input.FromLocationID,
+		ToLocationID:   input.ToLocationID,
+		Shipper:        strings.TrimSpace(input.Shipper),
+		TrackingNumber: strings.TrimSpace(input.TrackingNumber),
+		Note:           input.Note,
+		Status:         transferPending,
+		CreatedBy:      caller.ID,
+	}
+	deviceIDs := uniqueUints(input.DeviceIDs)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var locations int64
+		if err := tx.Model(&Location{}).Where("id IN ?", []uint{transfer.FromLocationID, transfer.ToLocationID}).
+			Count(&locations).Error; err != nil {
+			return err
+		}
+		if locations != 2 {
+			return &transferRequestError{http.StatusBadRequest, "from_location_id and to_location_id must be existing locations"}
+		}
+
+		var devices []Device
+		if err := tx.Select("id", "location_id").Where("id IN ?", deviceIDs).Find(&devices).Error; err != nil {
+			return err
+		}
+		if len(devices) != len(deviceIDs) {
+			return &transferRequestError{http.StatusBadRequest, "every device_id must be an existing device"}
+		}
+		var elsewhere []uint
+		for _, d := range devices {
+			if d.LocationID == nil || *d.LocationID != transfer.FromLocationID {
+				elsewhere = append(elsewhere, d.ID)
+			}
+		}
+		if len(elsewhere) > 0 {
+			return &transferRequestError{http.StatusBadRequest, fmt.Sprintf("devices not at the source location: %v", elsewhere)}
+		}
+
+		var moving []uint
+		if err := tx.Model(&TransferItem{}).Where("device_id IN ? AND status IN ?", deviceIDs,
+			[]string{transferItemPending, transferItemInTransit}).Pluck("device_id", &moving).Error; err != nil {
+			return err
+		}
+		if len(moving) > 0 {
+			return &transferRequestError{http.StatusConflict, fmt.Sprintf("devices already on an open transfer: %v", moving)}
+		}
+
+		for _, id := range deviceIDs {
+			transfer.Items = append(transfer.Items, TransferItem{DeviceID: id, Status: transferItemPending})
+		}
+		return tx.Create(&transfer).Error
+	})
+	if err != nil {
+		respondTransferError(c, err, "create transfer")
+		return
+	}
+
+	logger.Infof("Transfer %d created from location %d to %d with %d devices",
+		transfer.ID, transfer.FromLocationID, transfer.ToLocationID, len(transfer.Items))
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// lockTransfer loads a transfer and its items for update.
+func lockTransfer(tx *gorm.DB, id int) (Transfer, error) {
+	var transfer Transfer
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").First(&transfer, id).Error
+	return transfer, err
+}
+
+func transferID(c *gin.Context) (int, bool) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return 0, false
+	}
+	return idInt, true
+}
+
+func getTransfer(c *gin.Context) {
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var transfer Transfer
+	if err := db.Preload("Items").Preload("Discrepancies").First(&transfer, idInt).Error; err != nil {
+		respondTransferError(c, err, "retrieve transfer")
+		return
+	}
+	c.JSON(http.StatusOK, transfer)
+}
+
+// listTransfers returns transfers, newest first, filtered by ?status= and
+// by ?location_id= as either end.
+func listTransfers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	query := db.Preload("Items").Order("id DESC")
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if raw := c.Query("location_id"); raw != "" {
+		locationID, err := strconv.Atoi(raw)
+		if err != nil {
+			respondWithError(c, http.StatusBadRequest, "Invalid location ID format")
+			return
+		}
+		query = query.Where("from_location_id = ? OR to_location_id = ?", locationID, locationID)
+	}
+	var transfers []Transfer
+	if err := query.Limit(limit).Offset(offset).Find(&transfers).Error; err != nil {
+		logger.Errorf("Failed to retrieve transfers: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve transfers")
+		return
+	}
+	c.JSON(http.StatusOK, transfers)
+}
+
+// shipTransfer hands a pending transfer to the shipper. Its devices leave
+// the source location and are In Transit until received.
+func shipTransfer(c *gin.Context) {
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Shipper        string `json:"shipper"`
+		TrackingNumber string `json:"tracking_number"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var transfer Transfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if transfer, err = lockTransfer(tx, idInt); err != nil {
+			return err
+		}
+		if transfer.Status != transferPending {
+			return &transferRequestError{http.StatusConflict, "Only pending transfers can be shipped"}
+		}
+		if input.Shipper != "" {
+			transfer.Shipper = strings.TrimSpace(input.Shipper)
+		}
+		if input.TrackingNumber != "" {
+			transfer.TrackingNumber = strings.TrimSpace(input.TrackingNumber)
+		}
+
+		for i := range transfer.Items {
+			item := &transfer.Items[i]
+			var device Device
+			err := tx.Select("id", "status", "location_id").First(&device, item.DeviceID).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return &transferRequestError{http.StatusConflict, fmt.Sprintf("device %d no longer exists", item.DeviceID)}
+			}
+			if err != nil {
+				return err
+			}
+			if device.LocationID == nil || *device.LocationID != transfer.FromLocationID {
+				return &transferRequestError{http.StatusConflict, fmt.Sprintf("device %d is no longer at the source location", item.DeviceID)}
+			}
+			item.PrevStatus = device.Status
+			item.Status = transferItemInTransit
+			if err := tx.Model(item).Updates(map[string]interface{}{
+				"status": item.Status, "prev_status": item.PrevStatus,
+			}).Error; err != nil {
+				return err
+			}
+			if err := updateDeviceFields(tx, item.DeviceID, map[string]interface{}{
+				"status": statusInTransit, "location_id": nil,
+			}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		transfer.Status, transfer.ShippedAt = transferInTransit, &now
+		return tx.Model(&transfer).Updates(map[string]interface{}{
+			"status": transfer.Status, "shipped_at": now,
+			"shipper": transfer.Shipper, "tracking_number": transfer.TrackingNumber,
+		}).Error
+	})
+	if err != nil {
+		respondTransferError(c, err, "ship transfer")
+		return
+	}
+
+	logger.Infof("Transfer %d shipped with %s %s", transfer.ID, transfer.Shipper, transfer.TrackingNumber)
+	c.JSON(http.StatusOK, transfer)
+}
+
+// receiveTransfer confirms devices arriving at the destination. Any subset
+// can be received at a time. Devices that were not on the transfer are
+// recorded as unexpected and left where they are.
+func receiveTransfer(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		DeviceIDs []uint `json:"device_ids" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var transfer Transfer
+	var received []TransferItem
+	var already, unexpected []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if transfer, err = lockTransfer(tx, idInt); err != nil {
+			return err
+		}
+		if transfer.Status != transferInTransit && transfer.Status != transferPartial {
+			return &transferRequestError{http.StatusConflict, "Transfer is not in transit"}
+		}
+
+		var planned []TransferItem
+		planned, already, unexpected = receiptPlan(transfer.Items, input.DeviceIDs)
+		now := time.Now()
+		for _, item := range planned {
+			status := item.PrevStatus
+			if status == "" {
+				status = statusInStock
+			}
+			err := updateDeviceFields(tx, item.DeviceID, map[string]interface{}{
+				"status": status, "location_id": transfer.ToLocationID,
+			})
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The device was deleted in transit; it cannot arrive.
+				if err := markDeletedTransferItem(tx, &transfer, item.ID, now); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&TransferItem{}).Where("id = ?", item.ID).Updates(map[string]interface{}{
+				"status": transferItemReceived, "received_at": now, "received_by": caller.ID,
+			}).Error; err != nil {
+				return err
+			}
+			received = append(received, item)
+		}
+		for _, id := range unexpected {
+			deviceID := id
+			if err := tx.Create(&TransferDiscrepancy{
+				TransferID: transfer.ID, DeviceID: &deviceID, Kind: discrepancyUnexpected,
+				Note: "received but not on this transfer", ReportedBy: &caller.ID, ReportedAt: now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		for i, item := range transfer.Items {
+			for _, r := range received {
+				if r.ID == item.ID {
+					transfer.Items[i].Status = transferItemReceived
+					transfer.Items[i].ReceivedAt = &now
+					transfer.Items[i].ReceivedBy = &caller.ID
+				}
+			}
+		}
+		updates := map[string]interface{}{"status": transferStatusAfterReceipt(transfer.Items)}
+		if updates["status"] == transferReceived {
+			updates["closed_at"] = now
+			transfer.ClosedAt = &now
+		}
+		transfer.Status = updates["status"].(string)
+		return tx.Model(&transfer).Updates(updates).Error
+	})
+	if err != nil {
+		respondTransferError(c, err, "receive transfer")
+		return
+	}
+	if len(unexpected) > 0 {
+		logger.Warnf("Transfer %d received devices not on it: %v", transfer.ID, unexpected)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfer":         transfer,
+		"received":         len(received),
+		"already_received": already,
+		"unexpected":       unexpected,
+	})
+}
+
+// closeTransfer gives up on devices still in transit: they are marked Lost
+// and each is reported as missing.
+func closeTransfer(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Note string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil && !errors.Is(err, io.EOF) {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var transfer Transfer
+	var missing []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if transfer, err = lockTransfer(tx, idInt); err != nil {
+			return err
+		}
+		if transfer.Status != transferInTransit && transfer.Status != transferPartial {
+			return &transferRequestError{http.StatusConflict, "Transfer is not in transit"}
+		}
+
+		now := time.Now()
+		for i := range transfer.Items {
+			item := &transfer.Items[i]
+			if item.Status != transferItemInTransit {
+				continue
+			}
+			item.Status = transferItemMissing
+			if err := tx.Model(item).Update("status", item.Status).Error; err != nil {
+				return err
+			}
+			// A device deleted in transit is just as missing.
+			err := updateDeviceFields(tx, item.DeviceID, map[string]interface{}{"status": statusLost})
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			deviceID := item.DeviceID
+			if err := tx.Create(&TransferDiscrepancy{
+				TransferID: transfer.ID, DeviceID: &deviceID, Kind: discrepancyMissing,
+				Note: input.Note, ReportedBy: &caller.ID, ReportedAt: now,
+			}).Error; err != nil {
+				return err
+			}
+			missing = append(missing, item.DeviceID)
+		}
+
+		transfer.Status, transfer.ClosedAt = transferClosed, &now
+		return tx.Model(&transfer).Updates(map[string]interface{}{"status": transfer.Status, "closed_at": now}).Error
+	})
+	if err != nil {
+		respondTransferError(c, err, "close transfer")
+		return
+	}
+
+	if len(missing) > 0 {
+		logger.Warnf("Transfer %d closed with devices missing: %v", transfer.ID, missing)
+	}
+	c.JSON(http.StatusOK, gin.H{"transfer": transfer, "missing": missing})
+}
+
+// cancelTransfer drops a transfer that has not shipped.
+func cancelTransfer(c *gin.Context) {
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		transfer, err := lockTransfer(tx, idInt)
+		if err != nil {
+			return err
+		}
+		if transfer.Status != transferPending {
+			return &transferRequestError{http.StatusConflict, "Only pending transfers can be cancelled"}
+		}
+		if err := tx.Where("transfer_id = ?", transfer.ID).Delete(&TransferItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&transfer).Update("status", transferCancelled).Error
+	})
+	if err != nil {
+		respondTransferError(c, err, "cancel transfer")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer cancelled successfully"})
+}
+
+// reportTransferDiscrepancy records a problem found with a shipment, such
+// as a damaged device.
+func reportTransferDiscrepancy(c *gin.Context) {
+	caller, ok := currentEmployee(c)
+	if !ok {
+		return
+	}
+	idInt, ok := transferID(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		DeviceID *uint  `json:"device_id"`
+		Kind     string `json:"kind" binding:"required"`
+		Note     string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if input.Kind != discrepancyDamaged && input.Kind != discrepancyOther {
+		respondWithError(c, http.StatusBadRequest, "kind must be damaged or other")
+		return
+	}
+
+	var transfer Transfer
+	if err := db.Preload("Items").First(&transfer, idInt).Error; err != nil {
+		respondTransferError(c, err, "report discrepancy")
+		return
+	}
+	if input.DeviceID != nil {
+		onTransfer := false
+		for _, item := range transfer.Items {
+			onTransfer = onTransfer || item.DeviceID == *input.DeviceID
+		}
+		if !onTransfer {
+			respondWithError(c, http.StatusBadRequest, "Device is not on this transfer")
+			return
+		}
+	}
+
+	discrepancy := TransferDiscrepancy{
+		TransferID: transfer.ID, DeviceID: input.DeviceID, Kind: input.Kind, Note: input.Note,
+		ReportedBy: &caller.ID, ReportedAt: time.Now(),
+	}
+	if err := db.Create(&discrepancy).Error; err != nil {
+		logger.Errorf("Failed to report discrepancy: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to report discrepancy")
+		return
+	}
+	logger.Warnf("Transfer %d discrepancy reported: %s %s", transfer.ID, discrepancy.Kind, discrepancy.Note)
+	c.JSON(http.StatusCreated, discrepancy)
+}
+
+// listTransferDiscrepancies returns discrepancies across transfers,
+// unresolved ones only unless ?resolved=true.
+func listTransferDiscrepancies(c *gin.Context) {
+	query := db.Order("reported_at DESC, id DESC")
+	if c.Query("resolved") != "true" {
+		query = query.Where("resolved_at IS NULL")
+	}
+	var discrepancies []TransferDiscrepancy
+	if err := query.Find(&discrepancies).Error; err != nil {
+		logger.Errorf("Failed to retrieve discrepancies: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve discrepancies")
+		return
+	}
+	c.JSON(http.StatusOK, discrepancies)
+}
+
+func resolveTransferDiscrepancy(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var input struct {
+		Resolution string `json:"resolution" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result := db.Model(&TransferDiscrepancy{}).Where("id = ? AND resolved_at IS NULL", idInt).
+		Updates(map[string]interface{}{"resolved_at": time.Now(), "resolution": input.Resolution})
+	if result.Error != nil {
+		logger.Errorf("Failed to resolve discrepancy: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to resolve discrepancy")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Open discrepancy not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Discrepancy resolved successfully"})
+}
+
+// deviceMovement is one leg of a device's travels between locations.
+type deviceMovement struct {
+	TransferID     uint       `json:"transfer_id"`
+	FromLocationID uint       `json:"from_location_id"`
+	ToLocationID   uint       `json:"to_location_id"`
+	Shipper        string     `json:"shipper"`
+	TrackingNumber string     `json:"tracking_number"`
+	Status         string     `json:"status"` // the item's status
+	ShippedAt      *time.Time `json:"shipped_at"`
+	ReceivedAt     *time.Time `json:"received_at"`
+}
+
+// getDeviceMovements returns every transfer a device has shipped on,
+// latest first.
+func getDeviceMovements(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+	var movements []deviceMovement
+	if err := db.Table("transfer_items").
+		Select("transfers.id AS transfer_id, transfers.from_location_id, transfers.to_location_id, "+
+			"transfers.shipper, transfers.tracking_number, transfer_items.status, "+
+			"transfers.shipped_at, transfer_items.received_at").
+		Joins("JOIN transfers ON transfers.id = transfer_items.transfer_id").
+		Where("transfer_items.device_id = ? AND transfers.shipped_at IS NOT NULL", idInt).
+		Order("transfers.shipped_at DESC, transfers.id DESC").
+		Scan(&movements).Error; err != nil {
+		logger.Errorf("Failed to retrieve device movements: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve device movements")
+		return
+	}
+	c.JSON(http.StatusOK, movements)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test sorting scanned devices on receipt of a transfer
+func TestReceiptPlan(t *testing.T) {
+	items := []TransferItem{
+		{ID: 1, DeviceID: 10, Status: transferItemInTransit},
+		{ID: 2, DeviceID: 11, Status: transferItemReceived},
+		{ID: 3, DeviceID: 12, Status: transferItemInTransit},
+	}
+
+	receive, already, unexpected := receiptPlan(items, []uint{10, 11, 99, 10})
+	assert.Len(t, receive, 1)
+	assert.Equal(t, uint(1), receive[0].ID)
+	assert.Equal(t, []uint{11}, already)
+	assert.Equal(t, []uint{99}, unexpected)
+}
+
+// Test the transfer status as its devices are received
+func TestTransferStatusAfterReceipt(t *testing.T) {
+	assert.Equal(t, transferInTransit, transferStatusAfterReceipt([]TransferItem{
+		{Status: transferItemInTransit}, {Status: transferItemInTransit},
+	}))
+	assert.Equal(t, transferPartial, transferStatusAfterReceipt([]TransferItem{
+		{Status: transferItemReceived}, {Status: transferItemInTransit},
+	}))
+	assert.Equal(t, transferReceived, transferStatusAfterReceipt([]TransferItem{
+		{Status: transferItemReceived}, {Status: transferItemReceived},
+	}))
+}
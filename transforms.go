@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// csvColumns are the fields of an import row, in CSV column order.
+var csvColumns = []string{
+	"device_name", "device_type", "brand", "model", "os", "os_version",
+	"purchase_date", "warranty_end", "status", "price",
+}
+
+// importRow is one CSV row keyed by csvColumns.
+type importRow map[string]string
+
+func rowFromRecord(data []string) importRow {
+	row := make(importRow, len(csvColumns))
+	for i, column := range csvColumns {
+		if i < len(data) {
+			row[column] = data[i]
+		} else {
+			row[column] = ""
+		}
+	}
+	return row
+}
+
+func deviceFromRow(row importRow) Device {
+	return Device{
+		DeviceName:   row["device_name"],
+		DeviceType:   row["device_type"],
+		Brand:        row["brand"],
+		Model:        row["model"],
+		Os:           row["os"],
+		OsVersion:    row["os_version"],
+		PurchaseDate: row["purchase_date"],
+		WarrantyEnd:  row["warranty_end"],
+		Status:       row["status"],
+		Price:        uint(atoiSafe(row["price"])),
+	}
+}
+
+// ImportTransform is a Starlark script run on every CSV import row. It must
+// define transform(row, raw), where row is a dict of the named columns and
+// raw the list of all columns on the line, and return the new row dict,
+// optionally as a (row, reason) tuple, or drop(reason) to skip the row.
+// Returning None keeps the row as it is. Transforms run in ascending
+// Position order, each seeing the previous one's output.
+type ImportTransform struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"column:name;uniqueIndex" json:"name" binding:"required"`
+	Script    string    `gorm:"column:script;type:text" json:"script" binding:"required"`
+	Enabled   bool      `gorm:"column:enabled" json:"enabled"`
+	Position  int       `gorm:"column:position" json:"position"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// droppedRow is what the drop builtin returns.
+type droppedRow string
+
+func (d droppedRow) String() string        { return "drop(" + strconv.Quote(string(d)) + ")" }
+func (d droppedRow) Type() string          { return "dropped_row" }
+func (d droppedRow) Freeze()               {}
+func (d droppedRow) Truth() starlark.Bool  { return starlark.True }
+func (d droppedRow) Hash() (uint32, error) { return 0, errors.New("unhashable type: dropped_row") }
+
+var dropBuiltin = starlark.NewBuiltin("drop", func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var reason string
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "reason", &reason); err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		return nil, errors.New("drop: reason must not be empty")
+	}
+	return droppedRow(reason), nil
+})
+
+// compiledTransform is a loaded transform script.
+type compiledTransform struct {
+	name string
+	fn   starlark.Callable
+}
+
+// transformChain is the enabled transforms, in order.
+type transformChain []compiledTransform
+
+func newTransformThread(name string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			logger.Infof("Import transform %s: %s", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(config().Transforms.MaxSteps)
+	return thread
+}
+
+func compileTransform(name, script string) (compiledTransform, error) {
+	thread := newTransformThread(name)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, name+".star", script,
+		starlark.StringDict{"drop": dropBuiltin})
+	if err != nil {
+		return compiledTransform{}, err
+	}
+	fn, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return compiledTransform{}, errors.New("script must define transform(row, raw)")
+	}
+	globals.Freeze() // shared between rows, so scripts cannot keep state
+	return compiledTransform{name: name, fn: fn}, nil
+}
+
+// apply runs the transform on a copy of row. It returns the new row and the
+// reason given for a change, or the reason the row was dropped.
+func (t compiledTransform) apply(row importRow, raw []string) (out importRow, reason string, dropped bool, err error) {
+	dict := starlark.NewDict(len(row))
+	for _, column := range csvColumns {
+		dict.SetKey(starlark.String(column), starlark.String(row[column]))
+	}
+	list := make([]starlark.Value, len(raw))
+	for i, v := range raw {
+		list[i] = starlark.String(v)
+	}
+
+	result, err := starlark.Call(newTransformThread(t.name), t.fn, starlark.Tuple{dict, starlark.NewList(list)}, nil)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("transform %s: %v", t.name, err)
+	}
+	if tuple, ok := result.(starlark.Tuple); ok && len(tuple) == 2 {
+		r, ok := starlark.AsString(tuple[1])
+		if !ok {
+			return nil, "", false, fmt.Errorf("transform %s: reason must be a string", t.name)
+		}
+		result, reason = tuple[0], r
+	}
+
+	switch v := result.(type) {
+	case starlark.NoneType:
+		return row, reason, false, nil
+	case droppedRow:
+		return nil, string(v), true, nil
+	case *starlark.Dict:
+		out, err := rowFromDict(v)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("transform %s: %v", t.name, err)
+		}
+		return out, reason, false, nil
+	default:
+		return nil, "", false, fmt.Errorf("transform %s: must return a dict, None or drop(reason), not %s", t.name, result.Type())
+	}
+}
+
+func rowFromDict(dict *starlark.Dict) (importRow, error) {
+	row := make(importRow, len(csvColumns))
+	for _, column := range csvColumns {
+		row[column] = ""
+	}
+	for _, item := range dict.Items() {
+		key, ok := starlark.AsString(item[0])
+		if !ok || !containsString(csvColumns, key) {
+			return nil, fmt.Errorf("unknown column %s", item[0])
+		}
+		switch v := item[1].(type) {
+		case starlark.String:
+			row[key] = string(v)
+		case starlark.NoneType:
+			row[key] = ""
+		case starlark.Int, starlark.Float, starlark.Bool:
+			row[key] = v.String()
+		default:
+			return nil, fmt.Errorf("column %s must be a string or number, not %s", key, v.Type())
+		}
+	}
+	return row, nil
+}
+
+// apply runs every transform in turn, stopping at the first that drops the
+// row. The reasons given for changes are collected in order.
+func (chain transformChain) apply(row importRow, raw []string) (importRow, []string, string, error) {
+	var reasons []string
+	for _, t := range chain {
+		out, reason, dropped, err := t.apply(row, raw)
+		if err != nil {
+			return nil, reasons, "", err
+		}
+		if dropped {
+			return nil, reasons, reason, nil
+		}
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+		row = out
+	}
+	return row, reasons, "", nil
+}
+
+func loadImportTransforms(ctx context.Context) (transformChain, error) {
+	var transforms []ImportTransform
+	if err := db.WithContext(ctx).Where("enabled = ?", true).Order("position, id").Find(&transforms).Error; err != nil {
+		return nil, err
+	}
+	chain := make(transformChain, 0, len(transforms))
+	for _, t := range transforms {
+		compiled, err := compileTransform(t.Name, t.Script)
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %v", t.Name, err)
+		}
+		chain = append(chain, compiled)
+	}
+	return chain, nil
+}
+
+// transformPreview is the outcome of transforming one line.
+type transformPreview struct {
+	Line    int       `json:"line"`
+	Input   importRow `json:"input"`
+	Output  importRow `json:"output,omitempty"`
+	Changed []string  `json:"changed,omitempty"` // columns that differ
+	Reasons []string  `json:"reasons,omitempty"`
+	Dropped string    `json:"dropped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func previewTransforms(chain transformChain, csvText string, maxRows int) []transformPreview {
+	previews := []transformPreview{}
+	for i, line := range strings.Split(strings.ReplaceAll(csvText, "\r\n", "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if len(previews) == maxRows {
+			break
+		}
+		raw := strings.Split(line, ",")
+		p := transformPreview{Line: i + 1, Input: rowFromRecord(raw)}
+		out, reasons, dropped, err := chain.apply(rowFromRecord(raw), raw)
+		p.Reasons = reasons
+		switch {
+		case err != nil:
+			p.Error = err.Error()
+		case dropped != "":
+			p.Dropped = dropped
+		default:
+			p.Output = out
+			for _, column := range csvColumns {
+				if out[column] != p.Input[column] {
+					p.Changed = append(p.Changed, column)
+				}
+			}
+			sort.Strings(p.Changed)
+		}
+		previews = append(previews, p)
+	}
+	return previews
+}
+
+// previewImportTransforms runs transforms over sample CSV text without
+// importing it. With a script, only that script runs; otherwise the enabled
+// transforms do.
+func previewImportTransforms(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	var input struct {
+		Script string `json:"script"`
+		CSV    string `json:"csv" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var chain transformChain
+	if input.Script != "" {
+		t, err := compileTransform("preview", input.Script)
+		if err != nil {
+			respondWithError(c, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		chain = transformChain{t}
+	} else {
+		var err error
+		if chain, err = loadImportTransforms(c.Request.Context()); err != nil {
+			logger.Errorf("Failed to load import transforms: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to load import transforms")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, previewTransforms(chain, input.CSV, config().Transforms.PreviewRows))
+}
+
+func createImportTransform(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	var transform ImportTransform
+	if err := c.ShouldBindJSON(&transform); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := compileTransform(transform.Name, transform.Script); err != nil {
+		respondWithError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	if err := db.Create(&transform).Error; err != nil {
+		if isUniqueViolation(err) {
+			respondWithError(c, http.StatusConflict, "A transform with this name already exists")
+			return
+		}
+		logger.Errorf("Failed to create import transform: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to create import transform")
+		return
+	}
+
+	logger.Infof("Import transform created: %s", transform.Name)
+	c.JSON(http.StatusCreated, transform)
+}
+
+func listImportTransforms(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	var transforms []ImportTransform
+	if err := db.Order("position, id").Find(&transforms).Error; err != nil {
+		logger.Errorf("Failed to retrieve import transforms: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve import transforms")
+		return
+	}
+	c.JSON(http.StatusOK, transforms)
+}
+
+func updateImportTransform(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var transform ImportTransform
+	if err := c.ShouldBindJSON(&transform); err != nil {
+		logger.Warnf("Invalid input: %v", err)
+		respondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := compileTransform(transform.Name, transform.Script); err != nil {
+		respondWithError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	result := db.Model(&ImportTransform{}).Where("id = ?", idInt).Updates(map[string]interface{}{
+		"name":     transform.Name,
+		"script":   transform.Script,
+		"enabled":  transform.Enabled,
+		"position": transform.Position,
+	})
+	if result.Error != nil {
+		if isUniqueViolation(result.Error) {
+			respondWithError(c, http.StatusConflict, "A transform with this name already exists")
+			return
+		}
+		logger.Errorf("Failed to update import transform: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to update import transform")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Import transform not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Import transform updated successfully"})
+}
+
+func deleteImportTransform(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	result := db.Delete(&ImportTransform{}, idInt)
+	if result.Error != nil {
+		logger.Errorf("Failed to delete import transform: %v", result.Error)
+		respondWithError(c, http.StatusInternalServerError, "Failed to delete import transform")
+		return
+	}
+	if result.RowsAffected == 0 {
+		respondWithError(c, http.StatusNotFound, "Import transform not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Import transform deleted successfully"})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const deriveTypeScript = `
+def transform(row, raw):
+    if row["model"] == "":
+        return drop("model is missing")
+    if row["model"].startswith("iPhone"):
+        row["device_type"] = "Phone"
+        return row, "device type derived from model"
+    return None
+`
+
+// Test deriving a column, keeping a row and dropping a row
+func TestTransformApply(t *testing.T) {
+	tr, err := compileTransform("derive", deriveTypeScript)
+	assert.NoError(t, err)
+	chain := transformChain{tr}
+
+	raw := []string{"Phone 1", "", "Apple", "iPhone 15", "iOS", "17", "2024-01-01", "2026-01-01", "In Stock", "999"}
+	row, reasons, dropped, err := chain.apply(rowFromRecord(raw), raw)
+	assert.NoError(t, err)
+	assert.Empty(t, dropped)
+	assert.Equal(t, "Phone", row["device_type"])
+	assert.Equal(t, []string{"device type derived from model"}, reasons)
+	assert.Equal(t, uint(999), deviceFromRow(row).Price)
+
+	raw = []string{"Laptop 1", "Laptop", "Dell", "XPS 13"}
+	row, _, _, err = chain.apply(rowFromRecord(raw), raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "Laptop", row["device_type"])
+	assert.Equal(t, "", row["price"])
+
+	raw = []string{"Unknown", "", "", ""}
+	_, _, dropped, err = chain.apply(rowFromRecord(raw), raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "model is missing", dropped)
+}
+
+// Test splitting a combined column using the raw line
+func TestTransformSplitColumn(t *testing.T) {
+	tr, err := compileTransform("split", `
+def transform(row, raw):
+    os, version = raw[4].split(" ", 1)
+    return {"device_name": raw[0], "os": os, "os_version": version, "price": int(raw[5])}
+`)
+	assert.NoError(t, err)
+
+	raw := []string{"Laptop 2", "Laptop", "Lenovo", "T14", "Windows 11", "1200"}
+	out, _, _, err := tr.apply(rowFromRecord(raw), raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "Windows", out["os"])
+	assert.Equal(t, "11", out["os_version"])
+	assert.Equal(t, "1200", out["price"])
+	assert.Equal(t, "", out["brand"])
+}
+
+// Test that scripts with errors, unknown columns or endless loops are caught
+func TestTransformErrors(t *testing.T) {
+	_, err := compileTransform("empty", "x = 1")
+	assert.EqualError(t, err, "script must define transform(row, raw)")
+
+	tr, err := compileTransform("unknown", `def transform(row, raw): return {"serial": "1"}`)
+	assert.NoError(t, err)
+	_, _, _, err = tr.apply(rowFromRecord(nil), nil)
+	assert.EqualError(t, err, `transform unknown: unknown column "serial"`)
+
+	tr, err = compileTransform("loop", `
+def transform(row, raw):
+    for i in range(1000000000):
+        pass
+`)
+	assert.NoError(t, err)
+	_, _, _, err = tr.apply(rowFromRecord(nil), nil)
+	assert.Error(t, err)
+}
+
+// Test the preview of a script over sample lines
+func TestPreviewTransforms(t *testing.T) {
+	tr, err := compileTransform("derive", deriveTypeScript)
+	assert.NoError(t, err)
+
+	previews := previewTransforms(transformChain{tr}, "A,,Apple,iPhone 15\r\n\nB,,,\nC,,,\n", 2)
+	assert.Len(t, previews, 2)
+	assert.Equal(t, 1, previews[0].Line)
+	assert.Equal(t, []string{"device_type"}, previews[0].Changed)
+	assert.Equal(t, 3, previews[1].Line)
+	assert.Equal(t, "model is missing", previews[1].Dropped)
+}
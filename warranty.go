@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WarrantyCoverage is what a provider reports about one device's warranty.
+type WarrantyCoverage struct {
+	End        time.Time
+	Confidence float64 // 0 to 1
+}
+
+// WarrantyProvider looks up warranty coverage by brand and serial number.
+// Lookup returns nil coverage when the provider has no record of the device.
+type WarrantyProvider interface {
+	Name() string
+	Handles(brand string) bool
+	Lookup(ctx context.Context, brand, serial string) (*WarrantyCoverage, error)
+}
+
+// HTTPWarrantyProvider queries a vendor's JSON warranty API.
+type HTTPWarrantyProvider struct {
+	Config WarrantyProviderConfig
+	Client *http.Client
+}
+
+func (p *HTTPWarrantyProvider) Name() string {
+	return p.Config.Name
+}
+
+func (p *HTTPWarrantyProvider) Handles(brand string) bool {
+	if len(p.Config.Brands) == 0 {
+		return true
+	}
+	for _, b := range p.Config.Brands {
+		if strings.EqualFold(b, brand) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *HTTPWarrantyProvider) Lookup(ctx context.Context, brand, serial string) (*WarrantyCoverage, error) {
+	target := strings.NewReplacer(
+		"{brand}", url.QueryEscape(brand),
+		"{serial}", url.QueryEscape(serial),
+	).Replace(p.Config.URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range p.Config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s returned %s", p.Config.Name, resp.Status)
+	}
+
+	var body interface{}
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("%s returned invalid JSON: %v", p.Config.Name, err)
+	}
+
+	raw, ok := jsonPath(body, p.Config.EndField).(string)
+	if !ok || raw == "" {
+		return nil, nil
+	}
+	layout := p.Config.EndFormat
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+	end, err := time.Parse(layout, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s returned an unreadable end date %q", p.Config.Name, raw)
+	}
+
+	coverage := &WarrantyCoverage{End: end, Confidence: p.Config.Confidence}
+	if p.Config.ConfidenceField != "" {
+		if n, ok := jsonPath(body, p.Config.ConfidenceField).(json.Number); ok {
+			if f, err := n.Float64(); err == nil && f >= 0 && f <= 1 {
+				coverage.Confidence = f
+			}
+		}
+	}
+	return coverage, nil
+}
+
+// jsonPath follows a dotted path of object keys and array indexes.
+func jsonPath(v interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// warrantyProviders builds the configured providers, in order of preference.
+func warrantyProviders(cfg WarrantyConfig) []WarrantyProvider {
+	client := &http.Client{Timeout: cfg.Timeout.Duration}
+	providers := make([]WarrantyProvider, 0, len(cfg.Providers))
+	for _, p := range cfg.Providers {
+		providers = append(providers, &HTTPWarrantyProvider{Config: p, Client: client})
+	}
+	return providers
+}
+
+// WarrantyCheck is the latest provider lookup for a device. A disagreement
+// means the provider's end date differs from the stored WarrantyEnd, which
+// is left for someone to review rather than overwritten.
+type WarrantyCheck struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	DeviceID    uint      `gorm:"column:device_id;uniqueIndex" json:"device_id"`
+	Source      string    `gorm:"column:source" json:"source"` // provider name
+	Confidence  float64   `gorm:"column:confidence" json:"confidence"`
+	CoverageEnd string    `gorm:"column:coverage_end" json:"coverage_end"` // YYYY-MM-DD; empty if the provider had no record
+	StoredEnd   string    `gorm:"column:stored_end" json:"stored_end"`     // WarrantyEnd when checked
+	Applied     bool      `gorm:"column:applied" json:"applied"`           // CoverageEnd was written to WarrantyEnd
+	Disagrees   bool      `gorm:"column:disagrees;index" json:"disagrees"`
+	Error       string    `gorm:"column:error" json:"error"`
+	CheckedAt   time.Time `gorm:"column:checked_at" json:"checked_at"`
+}
+
+var errNoWarrantyProvider = errors.New("no warranty provider for this brand")
+
+// reconcileWarranty compares a lookup with the stored end date. Missing end
+// dates are filled in; differing ones are flagged.
+func reconcileWarranty(stored string, coverage *WarrantyCoverage) WarrantyCheck {
+	check := WarrantyCheck{StoredEnd: stored}
+	if coverage == nil {
+		return check
+	}
+	check.Confidence = coverage.Confidence
+	check.CoverageEnd = coverage.End.Format("2006-01-02")
+	switch {
+	case stored == "":
+		check.Applied = true
+	case stored != check.CoverageEnd:
+		check.Disagrees = true
+	}
+	return check
+}
+
+// checkDeviceWarranty looks a device up with the first provider that
+// handles its brand and records the result.
+func checkDeviceWarranty(ctx context.Context, providers []WarrantyProvider, device Device) (WarrantyCheck, error) {
+	var provider WarrantyProvider
+	for _, p := range providers {
+		if p.Handles(device.Brand) {
+			provider = p
+			break
+		}
+	}
+
+	var check WarrantyCheck
+	var lookupErr error
+	if provider == nil {
+		lookupErr = errNoWarrantyProvider
+		check = WarrantyCheck{StoredEnd: device.WarrantyEnd, Error: lookupErr.Error()}
+	} else {
+		coverage, err := provider.Lookup(ctx, device.Brand, device.SerialNumber)
+		if err != nil {
+			lookupErr = err
+			check = WarrantyCheck{StoredEnd: device.WarrantyEnd, Error: err.Error()}
+		} else {
+			check = reconcileWarranty(device.WarrantyEnd, coverage)
+		}
+		check.Source = provider.Name()
+	}
+	check.DeviceID = device.ID
+	check.CheckedAt = time.Now()
+
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if check.Applied {
+			// Only fill the date if nobody set one since the device was read.
+			result := tx.Model(&Device{}).Where("id = ? AND warranty_end = ''", device.ID).
+				Update("warranty_end", check.CoverageEnd)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				var current Device
+				if err := tx.Select("id", "warranty_end").First(&current, device.ID).Error; err != nil {
+					return err
+				}
+				check.Applied = false
+				check.StoredEnd = current.WarrantyEnd
+				check.Disagrees = current.WarrantyEnd != check.CoverageEnd
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "device_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"source", "confidence", "coverage_end", "stored_end", "applied", "disagrees", "error", "checked_at",
+			}),
+		}).Create(&check).Error
+	})
+	if err != nil {
+		return check, err
+	}
+	return check, lookupErr
+}
+
+// enrichWarranties checks devices with a serial number that have not been
+// checked yet, including new and imported ones. Failed checks, and checks
+// whose device has had its warranty end changed since, are redone after
+// warranty.retry_after, so a disagreement someone resolved is cleared.
+func enrichWarranties(ctx context.Context) error {
+	cfg := config().Warranty
+	if len(cfg.Providers) == 0 {
+		return nil
+	}
+	providers := warrantyProviders(cfg)
+
+	var devices []Device
+	err := db.WithContext(ctx).
+		Where("serial_number <> ''").
+		Where("NOT EXISTS (SELECT 1 FROM warranty_checks wc WHERE wc.device_id = devices.id AND (wc.checked_at > ? OR "+
+			"(wc.error = '' AND (wc.stored_end = devices.warranty_end OR (wc.applied AND wc.coverage_end = devices.warranty_end)))))",
+			time.Now().Add(-cfg.RetryAfter.Duration)).
+		Order("id").Limit(cfg.BatchSize).Find(&devices).Error
+	if err != nil {
+		return err
+	}
+
+	checked, flagged, failed := 0, 0, 0
+	for _, device := range devices {
+		if ctx.Err() != nil {
+			break
+		}
+		check, err := checkDeviceWarranty(ctx, providers, device)
+		switch {
+		case errors.Is(err, errNoWarrantyProvider):
+		case err != nil:
+			failed++
+			logger.Warnf("Warranty lookup for device %d failed: %v", device.ID, err)
+		default:
+			checked++
+			if check.Disagrees {
+				flagged++
+			}
+		}
+	}
+	if checked > 0 || failed > 0 {
+		logger.Infof("Warranty enrichment: %d checked, %d disagreements, %d failed", checked, flagged, failed)
+	}
+	return nil
+}
+
+func startWarrantyJob() {
+	startJob("warranty-enrichment", config().Warranty.Interval.Duration, enrichWarranties)
+}
+
+// lookupDeviceWarranty checks one device now.
+func lookupDeviceWarranty(c *gin.Context) {
+	idInt, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logger.Warnf("Invalid ID format: %v", err)
+		respondWithError(c, http.StatusBadRequest, "Invalid ID format")
+		return
+	}
+
+	var device Device
+	if err := db.First(&device, idInt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(c, http.StatusNotFound, "Device not found")
+		} else {
+			logger.Errorf("Failed to retrieve device: %v", err)
+			respondWithError(c, http.StatusInternalServerError, "Failed to look up warranty")
+		}
+		return
+	}
+	if device.SerialNumber == "" {
+		respondWithError(c, http.StatusUnprocessableEntity, "Device has no serial number")
+		return
+	}
+
+	check, err := checkDeviceWarranty(c.Request.Context(), warrantyProviders(config().Warranty), device)
+	if errors.Is(err, errNoWarrantyProvider) {
+		respondWithError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Warranty lookup for device %d failed: %v", device.ID, err)
+		respondWithError(c, http.StatusBadGateway, "Warranty lookup failed")
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+// listWarrantyChecks returns lookup results; ?disagreements=true keeps only
+// the ones that differ from the stored date.
+func listWarrantyChecks(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset := (page - 1) * limit
+
+	query := db.Order("device_id")
+	if c.Query("disagreements") == "true" {
+		query = query.Where("disagrees")
+	}
+	var checks []WarrantyCheck
+	if err := query.Limit(limit).Offset(offset).Find(&checks).Error; err != nil {
+		logger.Errorf("Failed to retrieve warranty checks: %v", err)
+		respondWithError(c, http.StatusInternalServerError, "Failed to retrieve warranty checks")
+		return
+	}
+	c.JSON(http.StatusOK, checks)
+}
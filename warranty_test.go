@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubWarrantyServer answers like a vendor API that knows one serial number.
+func stubWarrantyServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("serial") {
+		case "SN-1":
+			w.Write([]byte(`{"serial": "SN-1", "entitlements": [{"end": "2026-03-31", "score": 0.75}]}`))
+		case "SN-BAD":
+			w.Write([]byte(`{"entitlements": [{"end": "31/03/2026"}]}`))
+		case "SN-DOWN":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// Test the HTTP adapter against a stub vendor API
+func TestHTTPWarrantyProviderLookup(t *testing.T) {
+	server := stubWarrantyServer(t)
+	defer server.Close()
+
+	provider := &HTTPWarrantyProvider{Config: WarrantyProviderConfig{
+		Name:            "stub",
+		Brands:          []string{"Acme"},
+		URL:             server.URL + "/warranty?brand={brand}&serial={serial}",
+		Headers:         map[string]string{"X-Api-Key": "secret"},
+		EndField:        "entitlements.0.end",
+		Confidence:      0.9,
+		ConfidenceField: "entitlements.0.score",
+	}}
+	ctx := context.Background()
+
+	assert.True(t, provider.Handles("ACME"))
+	assert.False(t, provider.Handles("Other"))
+
+	coverage, err := provider.Lookup(ctx, "Acme", "SN-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, coverage) {
+		assert.Equal(t, time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), coverage.End)
+		assert.Equal(t, 0.75, coverage.Confidence)
+	}
+
+	coverage, err = provider.Lookup(ctx, "Acme", "SN-UNKNOWN")
+	assert.NoError(t, err)
+	assert.Nil(t, coverage)
+
+	_, err = provider.Lookup(ctx, "Acme", "SN-BAD")
+	assert.Error(t, err)
+	_, err = provider.Lookup(ctx, "Acme", "SN-DOWN")
+	assert.Error(t, err)
+
+	provider.Config.Headers = nil
+	_, err = provider.Lookup(ctx, "Acme", "SN-1")
+	assert.Error(t, err)
+}
+
+// Test comparing a lookup with the stored warranty end
+func TestReconcileWarranty(t *testing.T) {
+	coverage := &WarrantyCoverage{End: time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), Confidence: 0.9}
+
+	check := reconcileWarranty("", coverage)
+	assert.True(t, check.Applied)
+	assert.False(t, check.Disagrees)
+	assert.Equal(t, "2026-03-31", check.CoverageEnd)
+
+	check = reconcileWarranty("2026-03-31", coverage)
+	assert.False(t, check.Applied)
+	assert.False(t, check.Disagrees)
+
+	check = reconcileWarranty("2025-03-31", coverage)
+	assert.False(t, check.Applied)
+	assert.True(t, check.Disagrees)
+
+	check = reconcileWarranty("2025-03-31", nil)
+	assert.Empty(t, check.CoverageEnd)
+	assert.False(t, check.Disagrees)
+}
+
+// Test following paths into a decoded JSON document
+func TestJSONPath(t *testing.T) {
+	doc := map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": "x"}}}
+	assert.Equal(t, "x", jsonPath(doc, "a.0.b"))
+	assert.Nil(t, jsonPath(doc, "a.1.b"))
+	assert.Nil(t, jsonPath(doc, "a.b"))
+	assert.Nil(t, jsonPath(doc, ""))
+}